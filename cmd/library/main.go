@@ -0,0 +1,131 @@
+// Command library scans a folder of local music files and writes the tracks
+// as a PlaylistData JSON file, which can be uploaded to /api/games/import
+// any number of times. With -server it uploads the scan directly and
+// creates a game.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/library"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("library: ")
+
+	output := flag.String("o", "", "write the track list to this file instead of stdout")
+	name := flag.String("name", "", "playlist name (defaults to the folder name)")
+	server := flag.String("server", "", "base URL of a bingo server to create a game on, e.g. http://localhost:8080")
+	players := flag.Int("players", 4, "number of players when creating a game")
+	plates := flag.Int("plates", 3, "plates per player when creating a game")
+	contentType := flag.String("content-type", models.ContentTypeMixed, "plate content type when creating a game")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: library [flags] DIR\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, skipped, err := library.Scan(flag.Arg(0))
+	for _, s := range skipped {
+		log.Printf("skipped %v", s)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *name != "" {
+		data.PlaylistName = *name
+	}
+	log.Printf("found %d tracks in %s", len(data.Tracks), flag.Arg(0))
+
+	if *server != "" {
+		code, err := createGame(*server, data, *players, *plates, *contentType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Game created: %s/game-view.html?code=%s\n", strings.TrimRight(*server, "/"), code)
+		if *output == "" {
+			return
+		}
+	}
+
+	out, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		os.Stdout.Write(append(out, '\n'))
+		return
+	}
+	if err := os.WriteFile(*output, append(out, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// createGame uploads the scanned track list to the import endpoint.
+func createGame(server string, data models.PlaylistData, players, plates int, contentType string) (string, error) {
+	playlist, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("player_count", strconv.Itoa(players))
+	mw.WriteField("plates_per_player", strconv.Itoa(plates))
+	mw.WriteField("content_type", contentType)
+	mw.WriteField("format", "json")
+	part, err := mw.CreateFormFile("file", data.PlaylistName+".json")
+	if err != nil {
+		return "", err
+	}
+	part.Write(playlist)
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Post(strings.TrimRight(server, "/")+"/api/games/import", mw.FormDataContentType(), &body)
+	if err != nil {
+		return "", fmt.Errorf("creating game: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error.Message != "" {
+			return "", fmt.Errorf("creating game: %s (%s)", apiErr.Error.Message, resp.Status)
+		}
+		return "", fmt.Errorf("creating game: %s", resp.Status)
+	}
+
+	var created struct {
+		GameCode string `json:"game_code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+	return created.GameCode, nil
+}
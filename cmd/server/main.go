@@ -1,13 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/config"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/database"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/handlers"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/metrics"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 15 * time.Second
+	// Game creation pages through the whole playlist on Spotify, so leave
+	// plenty of room for large playlists before the write deadline hits.
+	writeTimeout    = 60 * time.Second
+	idleTimeout     = 120 * time.Second
+	shutdownTimeout = 30 * time.Second
+
+	sessionCleanupInterval = time.Hour
 )
 
 func main() {
@@ -15,6 +38,7 @@ func main() {
 	godotenv.Load()
 
 	cfg := config.Load()
+	slog.SetDefault(newLogger(cfg.LogFormat))
 
 	// Debug: Check if Spotify credentials are loaded
 	if cfg.SpotifyID == "" {
@@ -30,21 +54,156 @@ func main() {
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
-	defer db.Close()
+
+	if err := run(cfg, db); err != nil {
+		log.Printf("Server error: %v", err)
+		db.Close()
+		os.Exit(1)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
+	}
+	log.Printf("Shutdown complete")
+}
+
+// run serves HTTP until SIGINT/SIGTERM is received, then drains in-flight
+// requests, stops the background workers and returns. The database is left
+// open so the caller can close it once nothing else is using it.
+func run(cfg *config.Config, db *database.DB) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	names, err := trackname.LoadFile(cfg.TrackNameRules)
+	if err != nil {
+		return err
+	}
 
 	authHandler := handlers.NewAuthHandler(db, cfg)
-	gameHandler := handlers.NewGameHandler(db)
+	gameHandler := handlers.NewGameHandler(db, cfg, names)
 
-	http.Handle("GET /", http.FileServer(http.Dir("./www/")))
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.Dir("./www/")))
 
-	http.HandleFunc("GET /auth/spotify", authHandler.SpotifyLogin)
-	http.HandleFunc("GET /auth/callback", authHandler.SpotifyCallback)
-	http.HandleFunc("GET /api/user", authHandler.UserInfo)
+	mux.HandleFunc("GET /auth/spotify", authHandler.SpotifyLogin)
+	mux.HandleFunc("GET /auth/callback", authHandler.SpotifyCallback)
+	mux.HandleFunc("GET /api/user", authHandler.UserInfo)
+	mux.HandleFunc("GET /api/playlists", authHandler.SearchPlaylists)
+	mux.HandleFunc("GET /api/playlists/{id}/names", gameHandler.PreviewTrackNames)
 
-	http.HandleFunc("POST /api/games", gameHandler.CreateGame)
-	http.HandleFunc("GET /api/games/join", gameHandler.JoinGame)
-	http.HandleFunc("GET /api/games/all-plates", gameHandler.GetAllPlates)
+	mux.HandleFunc("POST /api/games", gameHandler.CreateGame)
+	mux.HandleFunc("POST /api/games/import", gameHandler.ImportGame)
+	mux.HandleFunc("GET /api/games/join", gameHandler.JoinGame)
+	mux.HandleFunc("GET /api/games/all-plates", gameHandler.GetAllPlates)
+	mux.HandleFunc("PUT /api/games/{code}/tracks/{trackID}/name", gameHandler.SetDisplayName)
+	mux.HandleFunc("GET /api/games/{code}/calls", gameHandler.ListCalls)
+	mux.HandleFunc("POST /api/games/{code}/calls", gameHandler.CallTrack)
+	mux.HandleFunc("POST /api/games/{code}/claims", gameHandler.ClaimPlate)
+	mux.HandleFunc("GET /api/games/{code}/difficulty", gameHandler.GetDifficulty)
+	mux.HandleFunc("GET /api/games/{code}/setlist", gameHandler.GetSetlist)
+	mux.HandleFunc("PUT /api/games/{code}/setlist", gameHandler.UpdateSetlist)
+	mux.HandleFunc("POST /api/games/{code}/setlist/lock", gameHandler.LockSetlist)
+	mux.HandleFunc("POST /api/games/{code}/setlist/next", gameHandler.NextTrack)
+	mux.HandleFunc("GET /api/games/{code}/rounds", gameHandler.ListRounds)
+	mux.HandleFunc("POST /api/games/{code}/rounds", gameHandler.StartRound)
+	mux.HandleFunc("POST /api/games/{code}/rounds/{number}/finish", gameHandler.FinishRound)
+	mux.HandleFunc("GET /api/games/{code}/winners", gameHandler.GetWinners)
+	mux.HandleFunc("GET /api/games/{code}/teams", gameHandler.ListTeams)
+	mux.HandleFunc("POST /api/games/{code}/teams", gameHandler.CreateTeam)
+	mux.HandleFunc("POST /api/games/{code}/teams/{id}/join", gameHandler.JoinTeam)
+	mux.HandleFunc("GET /api/games/{code}/seats", gameHandler.ListSeats)
+	mux.HandleFunc("POST /api/games/{code}/seats", gameHandler.AddSeats)
+	mux.HandleFunc("POST /api/games/{code}/seats/{seat}/release", gameHandler.ReleaseSeat)
+	mux.HandleFunc("POST /api/games/{code}/seats/{seat}/transfer", gameHandler.TransferSeat)
+	mux.HandleFunc("POST /api/games/{code}/device-transfer", gameHandler.StartDeviceTransfer)
+	mux.HandleFunc("POST /api/device-transfer/redeem", gameHandler.RedeemDeviceTransfer)
+	mux.HandleFunc("PUT /api/games/{code}/plates/{number}/marks", gameHandler.MarkPlate)
+	mux.HandleFunc("GET /api/games/{code}/events", gameHandler.StreamEvents)
+
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           middleware.Chain(mux, middleware.RequestID, middleware.AccessLog, middleware.Recover),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	// Shutdown waits for active requests, which event streams never stop
+	// being on their own.
+	srv.RegisterOnShutdown(gameHandler.CloseStreams)
 
-	log.Printf("Server starting on port %s", cfg.Port)
-	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		cleanupSessions(workerCtx, db)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Server starting on port %s", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+		close(serveErr)
+	}()
+
+	var runErr error
+	select {
+	case runErr = <-serveErr:
+	case <-ctx.Done():
+		log.Printf("Shutdown signal received, draining connections")
+	}
+	// A second signal during the drain falls back to the default behaviour
+	// and kills the process immediately.
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown did not complete: %v", err)
+		srv.Close()
+	}
+
+	stopWorkers()
+	workers.Wait()
+
+	return runErr
+}
+
+// cleanupSessions periodically removes expired user sessions until ctx is
+// cancelled.
+func cleanupSessions(ctx context.Context, db *database.DB) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := db.DeleteExpiredSessions(ctx, time.Now())
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					log.Printf("Error cleaning up expired sessions: %v", err)
+				}
+				continue
+			}
+			if n > 0 {
+				log.Printf("Removed %d expired sessions", n)
+			}
+		}
+	}
+}
+
+// newLogger builds the process-wide logger. Setting it as the slog default
+// also routes the standard log package through it.
+func newLogger(format string) *slog.Logger {
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
 }
@@ -0,0 +1,112 @@
+// Package claims checks plates against the tracks called so far. A field
+// is satisfied by any called track that shares one of its keys, so a
+// different release of the same song, or a track where the artist is only
+// featured, still counts.
+package claims
+
+import (
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/normalize"
+)
+
+// Result is the state of one plate.
+type Result struct {
+	Matched [3][9]bool `json:"matched"`
+	// Rows is the number of rows whose every field is matched.
+	Rows int  `json:"rows"`
+	Full bool `json:"full"`
+}
+
+// Checker matches fields using a normalizer.
+type Checker struct {
+	names *normalize.Normalizer
+}
+
+func New(names *normalize.Normalizer) *Checker {
+	return &Checker{names: names}
+}
+
+// FieldKeys returns the keys a field is matched on: its stored key and,
+// for track fields, the exact track. Fields generated before keys were
+// stored fall back to their artist name.
+func (c *Checker) FieldKeys(field models.BingoField) []string {
+	if field.Content == "" {
+		return nil
+	}
+	var keys []string
+	if field.Key != "" {
+		keys = append(keys, field.Key)
+	} else if field.Type == "artist" {
+		keys = append(keys, c.names.ArtistKey(field.Content))
+	}
+	if field.TrackID != "" {
+		keys = append(keys, normalize.TrackIDKey(field.TrackID))
+	}
+	return keys
+}
+
+// Called collects the keys satisfied by the called tracks.
+func (c *Checker) Called(tracks []models.Track) map[string]bool {
+	keys := make(map[string]bool)
+	for _, t := range tracks {
+		for _, key := range c.names.TrackKeys(t) {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// Check matches every field of a plate against the called keys.
+func (c *Checker) Check(fields models.PlateFields, called map[string]bool) Result {
+	var result Result
+	for row := range fields.Grid {
+		filled, matched := 0, 0
+		for col, field := range fields.Grid[row] {
+			keys := c.FieldKeys(field)
+			if len(keys) == 0 {
+				continue
+			}
+			filled++
+			for _, key := range keys {
+				if called[key] {
+					result.Matched[row][col] = true
+					matched++
+					break
+				}
+			}
+		}
+		if filled > 0 && matched == filled {
+			result.Rows++
+		}
+	}
+	result.Full = result.Rows == len(fields.Grid)
+	return result
+}
+
+// Reaches reports whether the plate has won a prize tier.
+func (r Result) Reaches(tier string) bool {
+	switch tier {
+	case models.PrizeTierOneRow:
+		return r.Rows >= 1
+	case models.PrizeTierTwoRows:
+		return r.Rows >= 2
+	case models.PrizeTierFull:
+		return r.Full
+	}
+	return false
+}
+
+// WonAt returns how many of the calls, in order, it took the plate to reach
+// tier, or 0 if it hasn't.
+func (c *Checker) WonAt(fields models.PlateFields, calls []models.Track, tier string) int {
+	called := make(map[string]bool)
+	for i, t := range calls {
+		for _, key := range c.names.TrackKeys(t) {
+			called[key] = true
+		}
+		if c.Check(fields, called).Reaches(tier) {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -8,19 +8,30 @@ type Config struct {
 	Port          string
 	SpotifyID     string
 	SpotifySecret string
-	BaseURL       string
-	DatabasePath  string
-	SessionSecret string
+	// Overridable so tests and local development can point at a fake.
+	SpotifyAPIURL      string
+	SpotifyAccountsURL string
+	BaseURL            string
+	DatabasePath       string
+	SessionSecret      string
+	LogFormat          string
+	// TrackNameRules is an optional JSON rule set file for cleaning track
+	// names; the built-in rules are used when empty.
+	TrackNameRules string
 }
 
 func Load() *Config {
 	return &Config{
-		Port:          getEnv("PORT", "8080"),
-		SpotifyID:     getEnv("SPOTIFY_CLIENT_ID", ""),
-		SpotifySecret: getEnv("SPOTIFY_CLIENT_SECRET", ""),
-		BaseURL:       getEnv("BASE_URL", "http://localhost:8080"),
-		DatabasePath:  getEnv("DATABASE_PATH", "./bingo.db"),
-		SessionSecret: getEnv("SESSION_SECRET", "your-secret-key-here"),
+		Port:               getEnv("PORT", "8080"),
+		SpotifyID:          getEnv("SPOTIFY_CLIENT_ID", ""),
+		SpotifySecret:      getEnv("SPOTIFY_CLIENT_SECRET", ""),
+		SpotifyAPIURL:      getEnv("SPOTIFY_API_URL", "https://api.spotify.com"),
+		SpotifyAccountsURL: getEnv("SPOTIFY_ACCOUNTS_URL", "https://accounts.spotify.com"),
+		BaseURL:            getEnv("BASE_URL", "http://localhost:8080"),
+		DatabasePath:       getEnv("DATABASE_PATH", "./bingo.db"),
+		SessionSecret:      getEnv("SESSION_SECRET", "your-secret-key-here"),
+		LogFormat:          getEnv("LOG_FORMAT", "text"),
+		TrackNameRules:     getEnv("TRACK_NAME_RULES", ""),
 	}
 }
 
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// LookupSource returns the cached tracks for a source at src.SnapshotID.
+// The boolean is false when that snapshot hasn't been stored.
+func (db *DB) LookupSource(ctx context.Context, src models.Source) (models.PlaylistData, bool, error) {
+	var id int64
+	var name string
+	err := db.QueryRowContext(ctx, `SELECT id, name FROM sources WHERE type = ? AND source_id = ? AND snapshot_id = ?`,
+		src.Type, src.ID, src.SnapshotID).Scan(&id, &name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.PlaylistData{}, false, nil
+	}
+	if err != nil {
+		return models.PlaylistData{}, false, fmt.Errorf("failed to look up source: %w", err)
+	}
+
+	tracks, err := db.sourceTracks(ctx, id)
+	if err != nil {
+		return models.PlaylistData{}, false, err
+	}
+	return models.PlaylistData{PlaylistID: src.ID, PlaylistName: name, Tracks: tracks}, true, nil
+}
+
+// SaveSource stores a fetched snapshot of a source and its tracks. Saving a
+// snapshot that is already stored is a no-op.
+func (db *DB) SaveSource(ctx context.Context, src models.Source, data models.PlaylistData) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO sources (type, source_id, snapshot_id, name, track_count) VALUES (?, ?, ?, ?, ?)`,
+		src.Type, src.ID, src.SnapshotID, data.PlaylistName, len(data.Tracks))
+	if err != nil {
+		return fmt.Errorf("failed to insert source: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	sourceID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	// Each snapshot keeps its own copy of its tracks, so nothing saved
+	// later can change the tracks of games built from it.
+	insertTrack, err := tx.PrepareContext(ctx, `INSERT INTO source_tracks (source_id, position, track_id, name, artists, year, duration_ms, path, artist_ids, album, genres, popularity)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertTrack.Close()
+
+	for i, t := range data.Tracks {
+		artists, _ := json.Marshal(t.Artists)
+		artistIDs, _ := json.Marshal(t.ArtistIDs)
+		genres, _ := json.Marshal(t.Genres)
+		if _, err := insertTrack.ExecContext(ctx, sourceID, i, t.ID, t.Name, string(artists), t.Year, t.DurationMS, t.Path,
+			string(artistIDs), t.Album, string(genres), t.Popularity); err != nil {
+			return fmt.Errorf("failed to save source track: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LinkGameSources records which stored snapshots a game was built from, in
+// order, as part of tx creating the game. Every source must have been
+// saved with SaveSource.
+func LinkGameSources(ctx context.Context, tx *sql.Tx, gameCode string, sources []models.Source) error {
+	for i, src := range sources {
+		res, err := tx.ExecContext(ctx, `INSERT INTO game_sources (game_code, position, source_id)
+			SELECT ?, ?, id FROM sources WHERE type = ? AND source_id = ? AND snapshot_id = ?`,
+			gameCode, i, src.Type, src.ID, src.SnapshotID)
+		if err != nil {
+			return fmt.Errorf("failed to link game source: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return fmt.Errorf("source %s %q snapshot %q is not stored", src.Type, src.ID, src.SnapshotID)
+		}
+	}
+	return nil
+}
+
+// GameTracks returns the track list a game was built from. Games that
+// reference stored sources have them merged in order with duplicates
+// removed; older games carry the full list in games.playlist_data.
+func (db *DB) GameTracks(ctx context.Context, gameCode string) (models.PlaylistData, error) {
+	var playlistJSON string
+	err := db.QueryRowContext(ctx, `SELECT playlist_data FROM games WHERE game_code = ?`, gameCode).Scan(&playlistJSON)
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+	data, err := models.PlaylistDataFromJSON(playlistJSON)
+	if err != nil {
+		return models.PlaylistData{}, fmt.Errorf("failed to decode playlist data: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT source_id FROM game_sources WHERE game_code = ? ORDER BY position`, gameCode)
+	if err != nil {
+		return models.PlaylistData{}, fmt.Errorf("failed to query game sources: %w", err)
+	}
+	var sourceIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return models.PlaylistData{}, err
+		}
+		sourceIDs = append(sourceIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.PlaylistData{}, err
+	}
+	if len(sourceIDs) == 0 {
+		return data, nil
+	}
+
+	data.Tracks = nil
+	seen := make(map[string]bool)
+	for _, id := range sourceIDs {
+		tracks, err := db.sourceTracks(ctx, id)
+		if err != nil {
+			return models.PlaylistData{}, err
+		}
+		for _, t := range tracks {
+			if seen[t.ID] {
+				continue
+			}
+			seen[t.ID] = true
+			data.Tracks = append(data.Tracks, t)
+		}
+	}
+	return data, nil
+}
+
+func (db *DB) sourceTracks(ctx context.Context, sourceID int64) ([]models.Track, error) {
+	rows, err := db.QueryContext(ctx, `SELECT track_id, name, artists, year, duration_ms, path, artist_ids, album, genres, popularity
+		FROM source_tracks WHERE source_id = ? ORDER BY position`, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query source tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []models.Track
+	for rows.Next() {
+		var t models.Track
+		var artists, artistIDs, genres string
+		if err := rows.Scan(&t.ID, &t.Name, &artists, &t.Year, &t.DurationMS, &t.Path, &artistIDs, &t.Album, &genres, &t.Popularity); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(artists), &t.Artists)
+		json.Unmarshal([]byte(artistIDs), &t.ArtistIDs)
+		json.Unmarshal([]byte(genres), &t.Genres)
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
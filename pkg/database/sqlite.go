@@ -1,8 +1,10 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -52,6 +54,100 @@ func (db *DB) createTables() error {
 			expires_at DATETIME,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS sources (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			source_id TEXT NOT NULL,
+			snapshot_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			track_count INTEGER NOT NULL,
+			fetched_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(type, source_id, snapshot_id)
+		)`,
+		fmt.Sprintf(sourceTracksWithData, "IF NOT EXISTS source_tracks"),
+		`CREATE TABLE IF NOT EXISTS game_sources (
+			game_code TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			source_id INTEGER NOT NULL,
+			PRIMARY KEY (game_code, position),
+			FOREIGN KEY (game_code) REFERENCES games(game_code),
+			FOREIGN KEY (source_id) REFERENCES sources(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS track_name_overrides (
+			game_code TEXT NOT NULL,
+			track_id TEXT NOT NULL,
+			display_name TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (game_code, track_id),
+			FOREIGN KEY (game_code) REFERENCES games(game_code)
+		)`,
+		`CREATE TABLE IF NOT EXISTS calls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_code TEXT NOT NULL,
+			track_id TEXT NOT NULL,
+			called_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (game_code) REFERENCES games(game_code),
+			UNIQUE(game_code, track_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS rounds (
+			game_code TEXT NOT NULL,
+			number INTEGER NOT NULL,
+			prize_tier TEXT NOT NULL,
+			prizes TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'active',
+			new_plates INTEGER NOT NULL DEFAULT 0,
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finished_at DATETIME,
+			PRIMARY KEY (game_code, number),
+			FOREIGN KEY (game_code) REFERENCES games(game_code)
+		)`,
+		`CREATE TABLE IF NOT EXISTS winners (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_code TEXT NOT NULL,
+			round INTEGER NOT NULL,
+			tier TEXT NOT NULL,
+			prize TEXT NOT NULL DEFAULT '',
+			user_session_id TEXT NOT NULL,
+			plate_number INTEGER NOT NULL,
+			call_number INTEGER NOT NULL,
+			won_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (game_code) REFERENCES games(game_code),
+			UNIQUE(game_code, round, tier, user_session_id, plate_number)
+		)`,
+		`CREATE TABLE IF NOT EXISTS teams (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_code TEXT NOT NULL,
+			name TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (game_code) REFERENCES games(game_code),
+			UNIQUE(game_code, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS team_members (
+			game_code TEXT NOT NULL,
+			user_session_id TEXT NOT NULL,
+			team_id INTEGER NOT NULL,
+			joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (game_code, user_session_id),
+			FOREIGN KEY (team_id) REFERENCES teams(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS device_transfers (
+			token TEXT PRIMARY KEY,
+			code TEXT NOT NULL UNIQUE,
+			game_code TEXT NOT NULL,
+			user_session_id TEXT NOT NULL,
+			invalidate_old INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (game_code) REFERENCES games(game_code)
+		)`,
+		`CREATE TABLE IF NOT EXISTS setlist_entries (
+			game_code TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			track_id TEXT NOT NULL,
+			excluded INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (game_code, track_id),
+			FOREIGN KEY (game_code) REFERENCES games(game_code)
+		)`,
 	}
 
 	for _, query := range queries {
@@ -78,6 +174,7 @@ func (db *DB) runMigrations() error {
 
 	hasContentType := false
 	hasPlatesPerPlayer := false
+	hasSource := false
 	for rows.Next() {
 		var cid int
 		var name string
@@ -97,6 +194,9 @@ func (db *DB) runMigrations() error {
 		if name == "plates_per_player" {
 			hasPlatesPerPlayer = true
 		}
+		if name == "source" {
+			hasSource = true
+		}
 	}
 
 	// Add content_type column if it doesn't exist
@@ -115,5 +215,231 @@ func (db *DB) runMigrations() error {
 		}
 	}
 
+	// Add source column if it doesn't exist. Older games were all built from
+	// a single playlist; their descriptor is left empty.
+	if !hasSource {
+		_, err = db.Exec(`ALTER TABLE games ADD COLUMN source TEXT NOT NULL DEFAULT '{"sources":[]}'`)
+		if err != nil {
+			return fmt.Errorf("failed to add source column: %w", err)
+		}
+	}
+
+	// Games created before recipes existed only have a content type, which
+	// maps to a fixed recipe.
+	if err := db.addColumn("games", "content_recipe", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	if err := db.addColumn("games", "difficulty", "TEXT NOT NULL DEFAULT 'normal'"); err != nil {
+		return err
+	}
+	// Games created before plates were balanced have no fairness report.
+	if err := db.addColumn("games", "fairness", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := db.addColumn("games", "setlist_locked", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// Plates generated before difficulty scores existed have none.
+	if err := db.addColumn("plates", "difficulty", "REAL"); err != nil {
+		return err
+	}
+
+	// Plates and calls belong to a round. Their unique constraints change
+	// with it, which SQLite can only do by copying the table.
+	hasRound, err := db.hasColumn("plates", "round")
+	if err != nil {
+		return err
+	}
+	if !hasRound {
+		if err := db.rebuildTable("plates", platesByRound,
+			"id, game_code, user_session_id, plate_number, fields, created_at, difficulty"); err != nil {
+			return err
+		}
+	}
+	hasRound, err = db.hasColumn("calls", "round")
+	if err != nil {
+		return err
+	}
+	if !hasRound {
+		if err := db.rebuildTable("calls", callsByRound, "id, game_code, track_id, called_at"); err != nil {
+			return err
+		}
+	}
+	// Games from before rounds existed are in their first round.
+	_, err = db.Exec(`INSERT INTO rounds (game_code, number, prize_tier, status, started_at)
+		SELECT game_code, 1, 'one_row', 'active', created_at FROM games
+		WHERE game_code NOT IN (SELECT game_code FROM rounds)`)
+	if err != nil {
+		return fmt.Errorf("failed to add first rounds: %w", err)
+	}
+	// Rounds started before prizes could be configured award their prize
+	// tier with no limit on winners.
+	if err := db.addColumn("rounds", "prizes", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// Spotify tokens used to set when the whole session expired, so hosts
+	// lost their games an hour in. Sessions from then keep that expiry for
+	// both.
+	hasTokenExpiry, err := db.hasColumn("user_sessions", "token_expires_at")
+	if err != nil {
+		return err
+	}
+	if !hasTokenExpiry {
+		if err := db.addColumn("user_sessions", "token_expires_at", "DATETIME"); err != nil {
+			return err
+		}
+		if _, err := db.Exec(`UPDATE user_sessions SET token_expires_at = expires_at WHERE spotify_token IS NOT NULL`); err != nil {
+			return fmt.Errorf("failed to copy token expiry: %w", err)
+		}
+	}
+
+	// Track data used to be shared between snapshots in a tracks table,
+	// where a later snapshot or import overwrote what earlier ones stored.
+	// Each snapshot now keeps its own copy.
+	hasTrackData, err := db.hasColumn("source_tracks", "name")
+	if err != nil {
+		return err
+	}
+	if !hasTrackData {
+		if err := db.moveTrackData(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// platesByRound, sourceTracksWithData and callsByRound are the current
+// plates, source_tracks and calls schemas, formatted with the table name.
+const (
+	platesByRound = `CREATE TABLE %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_code TEXT NOT NULL,
+		user_session_id TEXT NOT NULL,
+		round INTEGER NOT NULL DEFAULT 1,
+		plate_number INTEGER NOT NULL,
+		fields TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		difficulty REAL,
+		FOREIGN KEY (game_code) REFERENCES games(game_code),
+		UNIQUE(game_code, user_session_id, round, plate_number)
+	)`
+	sourceTracksWithData = `CREATE TABLE %s (
+		source_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		track_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		artists TEXT NOT NULL,
+		year INTEGER NOT NULL DEFAULT 0,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		path TEXT NOT NULL DEFAULT '',
+		artist_ids TEXT NOT NULL DEFAULT '[]',
+		album TEXT NOT NULL DEFAULT '',
+		genres TEXT NOT NULL DEFAULT '[]',
+		popularity INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (source_id, position),
+		FOREIGN KEY (source_id) REFERENCES sources(id)
+	)`
+	callsByRound = `CREATE TABLE %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_code TEXT NOT NULL,
+		round INTEGER NOT NULL DEFAULT 1,
+		track_id TEXT NOT NULL,
+		called_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (game_code) REFERENCES games(game_code),
+		UNIQUE(game_code, round, track_id)
+	)`
+)
+
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// addColumn adds a column to a table unless it already has it.
+func (db *DB) addColumn(table, column, definition string) error {
+	has, err := db.hasColumn(table, column)
+	if err != nil || has {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s column: %w", table, column, err)
+	}
+	return nil
+}
+
+// rebuildTable replaces table with one created from schema, copying the
+// listed columns across.
+func (db *DB) rebuildTable(table, schema, columns string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	steps := []string{
+		fmt.Sprintf(schema, table+"_new"),
+		fmt.Sprintf("INSERT INTO %s_new (%s) SELECT %s FROM %s", table, columns, columns, table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s_new RENAME TO %s", table, table),
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step); err != nil {
+			return fmt.Errorf("failed to rebuild %s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// moveTrackData copies each snapshot's tracks out of the shared tracks
+// table into source_tracks and drops it.
+func (db *DB) moveTrackData() error {
+	// Tracks cached before albums, genres and popularity were stored keep
+	// empty values.
+	trackColumns := []struct{ name, definition string }{
+		{"artist_ids", "TEXT NOT NULL DEFAULT '[]'"},
+		{"album", "TEXT NOT NULL DEFAULT ''"},
+		{"genres", "TEXT NOT NULL DEFAULT '[]'"},
+		{"popularity", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, col := range trackColumns {
+		if err := db.addColumn("tracks", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	steps := []string{
+		fmt.Sprintf(sourceTracksWithData, "source_tracks_new"),
+		`INSERT INTO source_tracks_new (source_id, position, track_id, name, artists, year, duration_ms, path, artist_ids, album, genres, popularity)
+			SELECT st.source_id, st.position, st.track_id, t.name, t.artists, t.year, t.duration_ms, t.path, t.artist_ids, t.album, t.genres, t.popularity
+			FROM source_tracks st JOIN tracks t ON t.id = st.track_id`,
+		"DROP TABLE source_tracks",
+		"ALTER TABLE source_tracks_new RENAME TO source_tracks",
+		"DROP TABLE tracks",
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step); err != nil {
+			return fmt.Errorf("failed to move track data: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteExpiredSessions removes sessions that expired before now and returns
+// how many were deleted.
+func (db *DB) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM user_sessions WHERE expires_at IS NOT NULL AND expires_at < ?`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	return res.RowsAffected()
+}
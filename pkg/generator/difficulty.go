@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"math"
+	"sort"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+const (
+	// unknownPopularity stands in for tracks without a popularity score,
+	// such as imported ones, so they are neither favoured nor avoided.
+	unknownPopularity = 50
+
+	// balanceTolerance is how far, in score points, a plate's difficulty
+	// may sit from the game average before it is regenerated.
+	balanceTolerance = 5.0
+	maxRebalances    = 30
+)
+
+// Plate is a generated plate with its difficulty score.
+type Plate struct {
+	Fields models.PlateFields
+	// Difficulty runs from 0 for a plate of hits to 100 for one of deep
+	// cuts.
+	Difficulty float64
+}
+
+// pool is the track list plates are drawn from, with each track's chance
+// of being picked and the popularity behind every field key.
+type pool struct {
+	tracks []models.Track
+	// cumulative holds the running sum of track weights, for weighted
+	// picks by binary search.
+	cumulative []float64
+	// popularity is the popularity of the best-known track that satisfies
+	// a field key, since that is the song most players will recognise.
+	popularity map[string]int
+}
+
+func (g *Generator) newPool(tracks []models.Track, difficulty string) *pool {
+	p := &pool{
+		tracks:     tracks,
+		cumulative: make([]float64, len(tracks)),
+		popularity: make(map[string]int),
+	}
+	total := 0.0
+	for i, t := range tracks {
+		total += trackWeight(t.Popularity, difficulty)
+		p.cumulative[i] = total
+		for _, key := range g.names.TrackKeys(t) {
+			p.popularity[key] = max(p.popularity[key], t.Popularity)
+		}
+	}
+	return p
+}
+
+// trackWeight biases easy games toward hits and hard games toward deep
+// cuts. Squaring makes a top-10 hit roughly ten times as likely as an
+// album track on an easy plate.
+func trackWeight(popularity int, difficulty string) float64 {
+	if popularity <= 0 {
+		popularity = unknownPopularity
+	}
+	switch difficulty {
+	case models.DifficultyEasy:
+		return math.Pow(float64(popularity+1), 2)
+	case models.DifficultyHard:
+		return math.Pow(float64(101-popularity), 2)
+	}
+	return 1
+}
+
+func (g *Generator) pick(p *pool) models.Track {
+	r := g.rng.Float64() * p.cumulative[len(p.cumulative)-1]
+	i := sort.SearchFloat64s(p.cumulative, r)
+	return p.tracks[min(i, len(p.tracks)-1)]
+}
+
+// score rates a plate by the popularity of its fields.
+func (p *pool) score(plate models.PlateFields) float64 {
+	total, n := 0, 0
+	for _, row := range plate.Grid {
+		for _, f := range row {
+			if f.Content == "" {
+				continue
+			}
+			pop := p.popularity[f.Key]
+			if pop <= 0 {
+				pop = unknownPopularity
+			}
+			total += pop
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return 100 - float64(total)/float64(n)
+}
+
+// balance regenerates plates that are much easier or harder than the
+// average, keeping whichever attempt lands closest, so that no player is
+// dealt a plate that is far quicker to complete than the others.
+func (g *Generator) balance(plates []Plate, p *pool, recipe models.ContentRecipe) {
+	if len(plates) < 2 {
+		return
+	}
+	target := 0.0
+	for _, plate := range plates {
+		target += plate.Difficulty
+	}
+	target /= float64(len(plates))
+
+	for i := range plates {
+		for range maxRebalances {
+			if math.Abs(plates[i].Difficulty-target) <= balanceTolerance {
+				break
+			}
+			fields := g.generateSinglePlate(p, recipe)
+			if score := p.score(fields); math.Abs(score-target) < math.Abs(plates[i].Difficulty-target) {
+				plates[i] = Plate{Fields: fields, Difficulty: score}
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package generator
+
+import (
+	"math"
+	"sort"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+const (
+	// randomPlaySamples is how many shuffled play orders stand in for
+	// random play. Every plate is timed against the same orders, so the
+	// comparison between plates is fair even with few samples.
+	randomPlaySamples = 64
+
+	// fairnessTolerance is the allowed gap between the quickest and slowest
+	// plate of a tier, as a share of the track list. With 60 tracks every
+	// plate should finish each tier within 6 calls of the others.
+	fairnessTolerance = 0.1
+
+	// maxFairnessMoves bounds the moves tried on one plate.
+	maxFairnessMoves = 1000
+)
+
+// tiers are the prize tiers a plate is timed for: one row, two rows and
+// the full plate.
+const tiers = 3
+
+// fairness times plates against play orders. times holds, for every field
+// key, the call number at which each order first satisfies it.
+type fairness struct {
+	times   map[string][]float64
+	samples int
+	// never is the call number used for keys no call satisfies, one past
+	// the last call.
+	never float64
+}
+
+// newFairness times the tracks against playOrder, a list of track IDs, or
+// against shuffled orders of every track when none of playOrder is known.
+func (g *Generator) newFairness(tracks []models.Track, playOrder []string) *fairness {
+	byID := make(map[string]models.Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+	var planned []models.Track
+	for _, id := range playOrder {
+		if t, ok := byID[id]; ok {
+			planned = append(planned, t)
+		}
+	}
+
+	var orders [][]models.Track
+	if len(planned) > 0 {
+		orders = [][]models.Track{planned}
+	} else {
+		for range randomPlaySamples {
+			order := append([]models.Track(nil), tracks...)
+			g.rng.Shuffle(len(order), func(i, j int) {
+				order[i], order[j] = order[j], order[i]
+			})
+			orders = append(orders, order)
+		}
+	}
+
+	f := &fairness{
+		times:   make(map[string][]float64),
+		samples: len(orders),
+		never:   float64(len(orders[0]) + 1),
+	}
+	for s, order := range orders {
+		for call, t := range order {
+			for _, key := range g.names.TrackKeys(t) {
+				times, ok := f.times[key]
+				if !ok {
+					times = make([]float64, f.samples)
+					for i := range times {
+						times[i] = f.never
+					}
+					f.times[key] = times
+				}
+				times[s] = min(times[s], float64(call+1))
+			}
+		}
+	}
+	return f
+}
+
+// completion is the expected number of calls until the plate completes one
+// row, two rows and the full plate.
+func (f *fairness) completion(plate models.PlateFields) [tiers]float64 {
+	var expected [tiers]float64
+	for s := range f.samples {
+		var rows [3]float64
+		for r, row := range plate.Grid {
+			for _, field := range row {
+				if field.Content == "" {
+					continue
+				}
+				t := f.never
+				if times, ok := f.times[field.Key]; ok {
+					t = times[s]
+				}
+				rows[r] = max(rows[r], t)
+			}
+		}
+		sort.Float64s(rows[:])
+		for tier := range tiers {
+			expected[tier] += rows[tier] / float64(f.samples)
+		}
+	}
+	return expected
+}
+
+func (f *fairness) spread(times [][tiers]float64) models.CompletionSpread {
+	var lo, hi [tiers]float64
+	for i, t := range times {
+		for tier := range tiers {
+			if i == 0 || t[tier] < lo[tier] {
+				lo[tier] = t[tier]
+			}
+			if i == 0 || t[tier] > hi[tier] {
+				hi[tier] = t[tier]
+			}
+		}
+	}
+	return models.CompletionSpread{OneRow: hi[0] - lo[0], TwoRows: hi[1] - lo[1], Full: hi[2] - lo[2]}
+}
+
+// equalize evens out how soon plates are expected to finish each tier.
+// Plates that stray from the average are changed one move at a time:
+// swapping two fields between rows, which shifts row times without
+// changing the plate's contents, or replacing a field with another of the
+// same kind. A move is kept unless it takes the plate further from the
+// average or pushes its difficulty score out of balance; keeping moves
+// that change nothing lets a plate wander out of a local dead end.
+func (g *Generator) equalize(plates []Plate, p *pool, f *fairness) models.FairnessReport {
+	times := make([][tiers]float64, len(plates))
+	for i, plate := range plates {
+		times[i] = f.completion(plate.Fields)
+	}
+	report := models.FairnessReport{Before: f.spread(times)}
+	if len(plates) < 2 {
+		report.After = report.Before
+		return report
+	}
+
+	tolerance := max(1, fairnessTolerance*(f.never-1))
+	difficulty := 0.0
+	for _, plate := range plates {
+		difficulty += plate.Difficulty / float64(len(plates))
+	}
+
+	// Every plate is steered into a band of half the tolerance around the
+	// average of the first draw, so that any two plates end up within the
+	// tolerance of each other. The target stays put while plates move,
+	// otherwise plates chase each other around a drifting average.
+	var target [tiers]float64
+	for _, t := range times {
+		for tier := range tiers {
+			target[tier] += t[tier] / float64(len(times))
+		}
+	}
+	cost := func(t [tiers]float64) float64 {
+		c := 0.0
+		for tier := range tiers {
+			if d := math.Abs(t[tier]-target[tier]) - tolerance/2; d > 0 {
+				c += d * d
+			}
+		}
+		return c
+	}
+
+	for i := range plates {
+		current := cost(times[i])
+		for range maxFairnessMoves {
+			if current == 0 {
+				break
+			}
+			candidate, ok := g.move(plates[i].Fields, p)
+			if !ok {
+				continue
+			}
+			score := p.score(candidate)
+			if math.Abs(score-difficulty) > max(balanceTolerance, math.Abs(plates[i].Difficulty-difficulty)) {
+				continue
+			}
+			t := f.completion(candidate)
+			if c := cost(t); c <= current {
+				plates[i] = Plate{Fields: candidate, Difficulty: score}
+				times[i], current = t, c
+			}
+		}
+	}
+
+	report.After = f.spread(times)
+	return report
+}
+
+// move returns a copy of the plate with one random change.
+func (g *Generator) move(plate models.PlateFields, p *pool) (models.PlateFields, bool) {
+	var cells [][2]int
+	used := make(map[string]bool)
+	for r, row := range plate.Grid {
+		for c, field := range row {
+			if field.Content != "" {
+				cells = append(cells, [2]int{r, c})
+				used[field.Key] = true
+			}
+		}
+	}
+	a := cells[g.rng.Intn(len(cells))]
+
+	if g.rng.Intn(2) == 0 {
+		b := cells[g.rng.Intn(len(cells))]
+		if a[0] == b[0] {
+			return plate, false
+		}
+		plate.Grid[a[0]][a[1]], plate.Grid[b[0]][b[1]] = plate.Grid[b[0]][b[1]], plate.Grid[a[0]][a[1]]
+		return plate, true
+	}
+
+	old := plate.Grid[a[0]][a[1]]
+	field := g.getRandomContent(p, used, old.Type)
+	if used[field.Key] {
+		return plate, false
+	}
+	plate.Grid[a[0]][a[1]] = field
+	return plate, true
+}
@@ -0,0 +1,82 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/normalize"
+)
+
+func seeded(seed int64) *Generator {
+	return &Generator{rng: rand.New(rand.NewSource(seed)), names: normalize.Default()}
+}
+
+func testPlaylist(n int) models.PlaylistData {
+	data := models.PlaylistData{PlaylistID: "pl", PlaylistName: "Test"}
+	for i := range n {
+		data.Tracks = append(data.Tracks, models.Track{
+			ID:         fmt.Sprintf("t%d", i),
+			Name:       fmt.Sprintf("Song %d", i),
+			Artists:    []string{fmt.Sprintf("Artist %d", i%(n/2))},
+			Year:       1970 + i%40,
+			Popularity: i%100 + 1,
+		})
+	}
+	return data
+}
+
+func TestEqualizeBoundsSpread(t *testing.T) {
+	data := testPlaylist(80)
+	var planned []string
+	for i := len(data.Tracks) - 1; i >= 0; i-- {
+		planned = append(planned, data.Tracks[i].ID)
+	}
+
+	tests := []struct {
+		name      string
+		playOrder []string
+		want      string
+	}{
+		{"planned", planned, models.PlayOrderPlanned},
+		{"random", nil, models.PlayOrderRandom},
+	}
+	for _, tt := range tests {
+		for seed := range int64(20) {
+			recipe, _ := models.RecipeForContentType(models.ContentTypeMixed)
+			g := seeded(seed)
+			plates, report, err := g.GeneratePlates(data, 5, Options{
+				Recipe:     recipe,
+				Difficulty: models.DifficultyNormal,
+				PlayOrder:  tt.playOrder,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plates) != 5 || report.PlayOrder != tt.want {
+				t.Fatalf("%s: %d plates, play order %q", tt.name, len(plates), report.PlayOrder)
+			}
+
+			tolerance := fairnessTolerance * float64(len(data.Tracks))
+			if got := report.After.Max(); got > tolerance {
+				t.Errorf("%s seed %d: spread after = %+v, want every tier within %.0f calls (before %+v)", tt.name, seed, report.After, tolerance, report.Before)
+			}
+			if report.After.Max() > report.Before.Max() {
+				t.Errorf("%s seed %d: spread grew from %+v to %+v", tt.name, seed, report.Before, report.After)
+			}
+
+			// The report must describe the plates actually returned.
+			if tt.playOrder != nil {
+				f := g.newFairness(data.Tracks, tt.playOrder)
+				var times [][tiers]float64
+				for _, p := range plates {
+					times = append(times, f.completion(p.Fields))
+				}
+				if got := f.spread(times); got != report.After {
+					t.Errorf("%s seed %d: recomputed spread %+v, reported %+v", tt.name, seed, got, report.After)
+				}
+			}
+		}
+	}
+}
@@ -3,60 +3,82 @@ package generator
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
 	"time"
 
 	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/normalize"
 )
 
 type Generator struct {
-	rng *rand.Rand
+	rng   *rand.Rand
+	names *normalize.Normalizer
 }
 
 func New() *Generator {
 	return &Generator{
-		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		names: normalize.Default(),
 	}
 }
 
-func (g *Generator) GeneratePlates(playlistData models.PlaylistData, count int, contentType string) ([]models.PlateFields, error) {
-	requiredTracks := count * 15 // Each plate has 15 fields (5 per row × 3 rows)
-	if len(playlistData.Tracks) < requiredTracks {
-		return nil, fmt.Errorf("playlist must have at least %d tracks for %d plates (need %d unique fields)", requiredTracks, count, requiredTracks)
-	}
+// Options control what goes on generated plates.
+type Options struct {
+	Recipe models.ContentRecipe
+	// Difficulty is one of the models.Difficulty values and biases which
+	// tracks the fields come from.
+	Difficulty string
+	// PlayOrder is the planned order of calls as track IDs. Plates are
+	// balanced for it, or for random play when it is empty.
+	PlayOrder []string
+}
 
-	var plates []models.PlateFields
-	usedCombinations := make(map[string]bool)
+// GeneratePlates generates count plates and balances them so that every
+// plate is about as difficult, and expected to finish about as soon, as
+// the others.
+func (g *Generator) GeneratePlates(playlistData models.PlaylistData, count int, opts Options) ([]Plate, models.FairnessReport, error) {
+	if err := g.CheckCapacity(playlistData, count, opts.Recipe); err != nil {
+		return nil, models.FairnessReport{}, err
+	}
+	// The same song on several releases would otherwise fill two fields.
+	tracks := g.names.UniqueSongs(playlistData.Tracks)
+	p := g.newPool(tracks, opts.Difficulty)
 
+	var plates []Plate
 	for range count {
-		plate, err := g.generateSinglePlate(playlistData.Tracks, usedCombinations, contentType)
-		if err != nil {
-			return nil, err
-		}
-		plates = append(plates, plate)
+		fields := g.generateSinglePlate(p, opts.Recipe)
+		plates = append(plates, Plate{Fields: fields, Difficulty: p.score(fields)})
 	}
+	g.balance(plates, p, opts.Recipe)
 
-	return plates, nil
+	f := g.newFairness(playlistData.Tracks, opts.PlayOrder)
+	report := g.equalize(plates, p, f)
+	report.PlayOrder = models.PlayOrderRandom
+	if f.samples == 1 {
+		report.PlayOrder = models.PlayOrderPlanned
+	}
+
+	return plates, report, nil
 }
 
-func (g *Generator) generateSinglePlate(tracks []models.Track, usedCombinations map[string]bool, contentType string) (models.PlateFields, error) {
+func (g *Generator) generateSinglePlate(p *pool, recipe models.ContentRecipe) models.PlateFields {
 	var plate models.PlateFields
 	usedContent := make(map[string]bool)
+	kinds := g.plateKinds(recipe)
 
+	i := 0
 	for row := 0; row < 3; row++ {
 		fieldsInRow := g.getRandomPositionsForRow()
 
 		for _, col := range fieldsInRow {
-			content, fieldType := g.getRandomContent(tracks, usedContent, contentType)
-			plate.Grid[row][col] = models.BingoField{
-				Content: content,
-				Type:    fieldType,
-				Marked:  false,
-			}
-			usedContent[content] = true
+			field := g.getRandomContent(p, usedContent, kinds[i])
+			plate.Grid[row][col] = field
+			usedContent[field.Key] = true
+			i++
 		}
 	}
 
-	return plate, nil
+	return plate
 }
 
 func (g *Generator) getRandomPositionsForRow() []int {
@@ -72,72 +94,96 @@ func (g *Generator) getRandomPositionsForRow() []int {
 	return positions[:5]
 }
 
-func (g *Generator) getRandomContent(tracks []models.Track, used map[string]bool, contentType string) (string, string) {
+// getRandomContent picks a field of the given kind whose key isn't on the
+// plate yet, so spelling variants of one artist or song never share a
+// plate. A playlist often has fewer distinct years, albums or genres than a
+// plate has fields, so when a kind runs out a track name is used instead.
+func (g *Generator) getRandomContent(p *pool, used map[string]bool, kind string) models.BingoField {
 	maxAttempts := 100
-	for attempts := 0; attempts < maxAttempts; attempts++ {
-		track := tracks[g.rng.Intn(len(tracks))]
-
-		switch contentType {
-		case models.ContentTypeTracks:
-			// Only use track names
-			if !used[track.Name] && track.Name != "" {
-				return track.Name, "track"
-			}
-		case models.ContentTypeArtists:
-			// Only use artist names
-			if len(track.Artists) > 0 {
-				artist := track.Artists[g.rng.Intn(len(track.Artists))]
-				if !used[artist] && artist != "" {
-					return artist, "artist"
-				}
-			}
-		case models.ContentTypeCombined:
-			// Combine track name and artist(s)
-			if len(track.Artists) > 0 && track.Name != "" {
-				artistName := track.Artists[0] // Use the first artist
-				if len(track.Artists) > 1 {
-					// If multiple artists, join with &
-					artistName = track.Artists[0]
-					for i := 1; i < len(track.Artists) && i < 2; i++ { // Limit to 2 artists max
-						artistName += " & " + track.Artists[i]
-					}
-				}
-				combined := track.Name + " - " + artistName
-				if !used[combined] {
-					return combined, "combined"
-				}
-			}
-		default:
-			// Mixed mode (default behavior)
-			useTrackName := g.rng.Float32() < 0.5
-			if useTrackName {
-				if !used[track.Name] && track.Name != "" {
-					return track.Name, "track"
-				}
-			} else {
-				if len(track.Artists) > 0 {
-					artist := track.Artists[g.rng.Intn(len(track.Artists))]
-					if !used[artist] && artist != "" {
-						return artist, "artist"
-					}
-				}
+	for _, k := range []string{kind, models.FieldTrack} {
+		for attempts := 0; attempts < maxAttempts; attempts++ {
+			track := g.pick(p)
+			if field, ok := g.field(track, k); ok && !used[field.Key] {
+				return field
 			}
 		}
 	}
 
-	// Fallback - return track name or artist based on content type
-	track := tracks[g.rng.Intn(len(tracks))]
-	switch contentType {
-	case models.ContentTypeArtists:
-		if len(track.Artists) > 0 {
-			return track.Artists[0], "artist"
+	// Fallback - every attempt hit a used key
+	return g.trackField(g.pick(p))
+}
+
+// field builds a field of the given kind from a track. It returns false if
+// the track has nothing for that kind, like an import without years.
+func (g *Generator) field(track models.Track, kind string) (models.BingoField, bool) {
+	switch kind {
+	case models.FieldTrack:
+		return g.trackField(track), track.Name != ""
+	case models.FieldArtist:
+		return g.artistField(track)
+	case models.FieldCombined:
+		return g.combinedField(track), track.Name != "" && len(track.Artists) > 0
+	case models.FieldYear:
+		if track.Year > 0 {
+			return models.BingoField{Content: strconv.Itoa(track.Year), Type: models.FieldYear, Key: normalize.YearKey(track.Year)}, true
+		}
+	case models.FieldDecade:
+		if track.Year > 0 {
+			return models.BingoField{Content: DecadeLabel(track.Year), Type: models.FieldDecade, Key: normalize.DecadeKey(track.Year)}, true
+		}
+	case models.FieldAlbum:
+		if track.Album != "" {
+			return models.BingoField{Content: track.Album, Type: models.FieldAlbum, Key: normalize.AlbumKey(track.Album)}, true
 		}
-	case models.ContentTypeCombined:
-		if len(track.Artists) > 0 && track.Name != "" {
-			return track.Name + " - " + track.Artists[0], "combined"
+	case models.FieldGenre:
+		if len(track.Genres) > 0 {
+			genre := track.Genres[g.rng.Intn(len(track.Genres))]
+			return models.BingoField{Content: genre, Type: models.FieldGenre, Key: normalize.GenreKey(genre)}, true
 		}
 	}
-	return track.Name, "track"
+	return models.BingoField{}, false
+}
+
+func (g *Generator) trackField(track models.Track) models.BingoField {
+	return models.BingoField{Content: track.Name, Type: models.FieldTrack, TrackID: track.ID, Key: g.names.SongKey(track.Name, track.Artists)}
+}
+
+// artistField picks one of the track's artists, counting featured artists
+// from credits like "A feat. B" separately.
+func (g *Generator) artistField(track models.Track) (models.BingoField, bool) {
+	artists := g.names.Artists(track.Artists)
+	if len(artists) == 0 {
+		return models.BingoField{}, false
+	}
+	artist := artists[g.rng.Intn(len(artists))]
+	return models.BingoField{Content: artist, Type: models.FieldArtist, Key: g.names.ArtistKey(artist)}, true
+}
+
+func (g *Generator) combinedField(track models.Track) models.BingoField {
+	return models.BingoField{Content: CombinedContent(track.Name, track.Artists), Type: models.FieldCombined, TrackID: track.ID, Key: g.names.SongKey(track.Name, track.Artists)}
+}
+
+// DecadeLabel names the decade of a year the way people say it: "80s" for
+// the last century, "2010s" for this one.
+func DecadeLabel(year int) string {
+	decade := year - year%10
+	if decade >= 1900 && decade < 2000 {
+		return fmt.Sprintf("%02ds", decade%100)
+	}
+	return fmt.Sprintf("%ds", decade)
+}
+
+// CombinedContent formats a combined field: the title followed by up to two
+// artists joined with "&".
+func CombinedContent(name string, artists []string) string {
+	if len(artists) == 0 {
+		return name
+	}
+	artistName := artists[0]
+	if len(artists) > 1 {
+		artistName += " & " + artists[1]
+	}
+	return name + " - " + artistName
 }
 
 func GenerateGameCode() string {
@@ -0,0 +1,157 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/normalize"
+)
+
+// KindSong is the CapacityError kind for the overall song count.
+const KindSong = "song"
+
+// CapacityError reports that a track list can't fill the requested plates.
+type CapacityError struct {
+	// Kind is a field kind, or KindSong when there are too few songs
+	// overall.
+	Kind      string
+	Required  int
+	Available int
+}
+
+func (e *CapacityError) Error() string {
+	if e.Kind == KindSong {
+		return fmt.Sprintf("need at least %d unique songs, have %d", e.Required, e.Available)
+	}
+	return fmt.Sprintf("need at least %d distinct %s values, have %d", e.Required, e.Kind, e.Available)
+}
+
+// CheckCapacity reports whether the tracks can fill count plates with
+// recipe. Every plate needs as many unique songs as it has fields, so that
+// plates differ, every kind with a minimum needs that many distinct values,
+// and every kind the recipe uses needs at least one.
+func (g *Generator) CheckCapacity(playlistData models.PlaylistData, count int, recipe models.ContentRecipe) error {
+	tracks := g.names.UniqueSongs(playlistData.Tracks)
+	if required := count * models.FieldsPerPlate; len(tracks) < required {
+		return &CapacityError{Kind: KindSong, Required: required, Available: len(tracks)}
+	}
+
+	distinct := g.distinctValues(tracks)
+	for _, kind := range recipe.Kinds() {
+		required := max(recipe.Minimums[kind], 1)
+		if distinct[kind] < required {
+			return &CapacityError{Kind: kind, Required: required, Available: distinct[kind]}
+		}
+	}
+	return nil
+}
+
+// distinctValues counts the distinct field keys per kind.
+func (g *Generator) distinctValues(tracks []models.Track) map[string]int {
+	keys := make(map[string]map[string]bool, len(models.FieldKinds))
+	add := func(kind, key string) {
+		if keys[kind] == nil {
+			keys[kind] = make(map[string]bool)
+		}
+		keys[kind][key] = true
+	}
+	for _, t := range tracks {
+		if t.Name != "" {
+			add(models.FieldTrack, g.names.SongKey(t.Name, t.Artists))
+			if len(t.Artists) > 0 {
+				add(models.FieldCombined, g.names.SongKey(t.Name, t.Artists))
+			}
+		}
+		for _, a := range g.names.Artists(t.Artists) {
+			add(models.FieldArtist, g.names.ArtistKey(a))
+		}
+		if t.Year > 0 {
+			add(models.FieldYear, normalize.YearKey(t.Year))
+			add(models.FieldDecade, normalize.DecadeKey(t.Year))
+		}
+		if t.Album != "" {
+			add(models.FieldAlbum, normalize.AlbumKey(t.Album))
+		}
+		for _, genre := range t.Genres {
+			add(models.FieldGenre, normalize.GenreKey(genre))
+		}
+	}
+
+	counts := make(map[string]int, len(keys))
+	for kind, set := range keys {
+		counts[kind] = len(set)
+	}
+	return counts
+}
+
+// plateKinds decides the kind of every field on one plate, in random
+// order. Minimums come first; the rest are shared out in proportion to the
+// weights, with the fractional leftovers drawn at random by weight so the
+// average over many plates stays close to the recipe.
+func (g *Generator) plateKinds(recipe models.ContentRecipe) []string {
+	var kinds []string
+	for _, k := range models.FieldKinds {
+		for range recipe.Minimums[k] {
+			kinds = append(kinds, k)
+		}
+	}
+
+	remaining := models.FieldsPerPlate - len(kinds)
+	total := 0.0
+	for _, k := range models.FieldKinds {
+		total += recipe.Weights[k]
+	}
+	if total == 0 {
+		// Only possible when the minimums already fill the plate.
+		total, recipe.Weights = 1, map[string]float64{models.FieldTrack: 1}
+	}
+
+	leftover := make(map[string]float64)
+	assigned := 0
+	for _, k := range models.FieldKinds {
+		share := recipe.Weights[k] / total * float64(remaining)
+		whole := int(math.Floor(share))
+		for range whole {
+			kinds = append(kinds, k)
+		}
+		assigned += whole
+		leftover[k] = share - float64(whole)
+	}
+	for ; assigned < remaining; assigned++ {
+		kinds = append(kinds, g.pickWeighted(leftover))
+	}
+
+	g.rng.Shuffle(len(kinds), func(i, j int) {
+		kinds[i], kinds[j] = kinds[j], kinds[i]
+	})
+	return kinds
+}
+
+// pickWeighted draws a kind in proportion to its weight and removes it, so
+// one kind doesn't take two leftover fields.
+func (g *Generator) pickWeighted(weights map[string]float64) string {
+	total := 0.0
+	for _, k := range models.FieldKinds {
+		total += weights[k]
+	}
+	if total <= 0 {
+		return models.FieldTrack
+	}
+	r := g.rng.Float64() * total
+	last := models.FieldTrack
+	for _, k := range models.FieldKinds {
+		w := weights[k]
+		if w <= 0 {
+			continue
+		}
+		last = k
+		if r < w {
+			delete(weights, k)
+			return k
+		}
+		r -= w
+	}
+	delete(weights, last)
+	return last
+}
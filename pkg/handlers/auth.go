@@ -9,13 +9,15 @@ import (
 
 	"github.com/kirkegaard/go-spotify-bingo/pkg/config"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/database"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
 )
 
 type AuthHandler struct {
-	db          *database.DB
-	spotifyAuth *spotify.AuthConfig
+	db            *database.DB
+	spotifyAuth   *spotify.AuthConfig
+	spotifyAPIURL string
 }
 
 func NewAuthHandler(db *database.DB, cfg *config.Config) *AuthHandler {
@@ -25,34 +27,20 @@ func NewAuthHandler(db *database.DB, cfg *config.Config) *AuthHandler {
 			ClientID:     cfg.SpotifyID,
 			ClientSecret: cfg.SpotifySecret,
 			RedirectURI:  cfg.BaseURL + "/auth/callback",
+			AccountsURL:  cfg.SpotifyAccountsURL,
 		},
+		spotifyAPIURL: cfg.SpotifyAPIURL,
 	}
 }
 
 func (h *AuthHandler) SpotifyLogin(w http.ResponseWriter, r *http.Request) {
-	sessionID := generateSessionID()
-
-	session := models.UserSession{
-		SessionID: sessionID,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour),
-	}
-
-	_, err := h.db.Exec(`INSERT INTO user_sessions (session_id, created_at, expires_at) VALUES (?, ?, ?)`,
-		session.SessionID, session.CreatedAt, session.ExpiresAt)
+	sessionID, err := startSession(w, r, h.db)
 	if err != nil {
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to create session", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create session")
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  session.ExpiresAt,
-		HttpOnly: true,
-		Path:     "/",
-	})
-
 	authURL := h.spotifyAuth.GetAuthURL(sessionID)
 	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
 }
@@ -62,21 +50,23 @@ func (h *AuthHandler) SpotifyCallback(w http.ResponseWriter, r *http.Request) {
 	state := r.URL.Query().Get("state")
 
 	if code == "" {
-		http.Error(w, "Authorization code missing", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Authorization code missing")
 		return
 	}
 
-	tokenResp, err := h.spotifyAuth.ExchangeCodeForToken(code)
+	tokenResp, err := h.spotifyAuth.ExchangeCodeForToken(r.Context(), code)
 	if err != nil {
-		http.Error(w, "Failed to exchange code for token", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("spotify token exchange failed", "error", err)
+		writeSpotifyError(w, r, err, "Failed to exchange code for token")
 		return
 	}
 
 	expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
-	_, err = h.db.Exec(`UPDATE user_sessions SET spotify_token = ?, expires_at = ? WHERE session_id = ?`,
+	_, err = h.db.Exec(`UPDATE user_sessions SET spotify_token = ?, token_expires_at = ? WHERE session_id = ?`,
 		tokenResp.AccessToken, expiresAt, state)
 	if err != nil {
-		http.Error(w, "Failed to store token", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to store spotify token", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to store token")
 		return
 	}
 
@@ -89,30 +79,17 @@ type UserInfoResponse struct {
 	Playlists     []PlaylistInfo `json:"playlists,omitempty"`
 }
 
-type PlaylistInfo struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-}
-
 func (h *AuthHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
-	sessionCookie, err := r.Cookie("session_id")
-	if err != nil {
+	session, ok := spotifySession(r, h.db)
+	if !ok {
 		json.NewEncoder(w).Encode(UserInfoResponse{Authenticated: false})
 		return
 	}
 
-	var session models.UserSession
-	err = h.db.QueryRow(`SELECT session_id, spotify_token, expires_at FROM user_sessions WHERE session_id = ?`,
-		sessionCookie.Value).Scan(&session.SessionID, &session.SpotifyToken, &session.ExpiresAt)
-
-	if err != nil || session.SpotifyToken == "" || time.Now().After(session.ExpiresAt) {
-		json.NewEncoder(w).Encode(UserInfoResponse{Authenticated: false})
-		return
-	}
-
-	client := spotify.NewClient(session.SpotifyToken)
-	playlists, err := client.GetUserPlaylists()
+	client := spotify.NewClient(session.SpotifyToken, spotify.WithAPIURL(h.spotifyAPIURL))
+	playlists, err := client.GetUserPlaylists(r.Context())
 	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to fetch user playlists", "error", err)
 		json.NewEncoder(w).Encode(UserInfoResponse{
 			Authenticated: true,
 			SessionID:     session.SessionID,
@@ -120,22 +97,79 @@ func (h *AuthHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var playlistInfos []PlaylistInfo
-	for _, playlist := range playlists {
-		playlistInfos = append(playlistInfos, PlaylistInfo{
-			ID:   playlist.ID,
-			Name: playlist.Name,
-		})
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(UserInfoResponse{
 		Authenticated: true,
 		SessionID:     session.SessionID,
-		Playlists:     playlistInfos,
+		Playlists:     toPlaylistInfos(playlists),
 	})
 }
 
+// spotifySession returns the caller's session if it holds a Spotify token
+// that hasn't expired.
+func spotifySession(r *http.Request, db *database.DB) (models.UserSession, bool) {
+	sessionCookie, err := r.Cookie("session_id")
+	if err != nil {
+		return models.UserSession{}, false
+	}
+
+	var session models.UserSession
+	err = db.QueryRow(`SELECT session_id, spotify_token, token_expires_at FROM user_sessions WHERE session_id = ?`,
+		sessionCookie.Value).Scan(&session.SessionID, &session.SpotifyToken, &session.TokenExpiresAt)
+	if err != nil || session.SpotifyToken == "" || time.Now().After(session.TokenExpiresAt) {
+		return models.UserSession{}, false
+	}
+	return session, true
+}
+
+// ensureSession returns the caller's session, starting an anonymous one if
+// there is none. A cookie whose session was logged out, as by a device
+// transfer, or cleaned up after expiring counts as none. An expired Spotify
+// token doesn't end the session.
+func ensureSession(w http.ResponseWriter, r *http.Request, db *database.DB) (string, bool) {
+	if cookie, err := r.Cookie("session_id"); err == nil {
+		var known int
+		err := db.QueryRow(`SELECT COUNT(*) FROM user_sessions WHERE session_id = ?`, cookie.Value).Scan(&known)
+		if err == nil && known > 0 {
+			return cookie.Value, true
+		}
+	}
+
+	sessionID, err := startSession(w, r, db)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to create session", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create session")
+		return "", false
+	}
+	return sessionID, true
+}
+
+// startSession creates a new anonymous session and sets its cookie.
+func startSession(w http.ResponseWriter, r *http.Request, db *database.DB) (string, error) {
+	session := models.UserSession{
+		SessionID: generateSessionID(),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	}
+
+	_, err := db.Exec(`INSERT INTO user_sessions (session_id, created_at, expires_at) VALUES (?, ?, ?)`,
+		session.SessionID, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    session.SessionID,
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Path:     "/",
+	})
+	middleware.SetSession(r.Context(), session.SessionID)
+
+	return session.SessionID, nil
+}
+
 func generateSessionID() string {
 	bytes := make([]byte, 16)
 	rand.Read(bytes)
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+)
+
+func TestLoginCallbackStoresToken(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party"})
+	h := NewAuthHandler(env.db, env.cfg)
+
+	rec := httptest.NewRecorder()
+	h.SpotifyLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/spotify", nil))
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("login status = %d", rec.Code)
+	}
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, env.spotify.URL+"/authorize?") {
+		t.Fatalf("login redirected to %q, want the fake accounts service", location)
+	}
+	authURL, _ := url.Parse(location)
+	state := authURL.Query().Get("state")
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != state {
+		t.Fatalf("session cookie %v does not match state %q", cookies, state)
+	}
+
+	rec = httptest.NewRecorder()
+	h.SpotifyCallback(rec, httptest.NewRequest(http.MethodGet, "/auth/callback?code="+spotifytest.AuthCode+"&state="+state, nil))
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("callback status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var token string
+	var tokenExpiresAt, expiresAt time.Time
+	err := env.db.QueryRow(`SELECT spotify_token, token_expires_at, expires_at FROM user_sessions WHERE session_id = ?`, state).
+		Scan(&token, &tokenExpiresAt, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != spotifytest.AccessToken {
+		t.Errorf("stored token = %q, want %q", token, spotifytest.AccessToken)
+	}
+	// The session outlives its token.
+	if !expiresAt.After(tokenExpiresAt) {
+		t.Errorf("session expires at %v, token at %v", expiresAt, tokenExpiresAt)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.UserInfo(rec, req)
+
+	var info UserInfoResponse
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Authenticated || len(info.Playlists) != 1 || info.Playlists[0].ID != "pl1" {
+		t.Errorf("user info = %+v, want authenticated with playlist pl1", info)
+	}
+}
+
+func TestLoginCallbackRejectedCode(t *testing.T) {
+	env := newTestEnv(t)
+	h := NewAuthHandler(env.db, env.cfg)
+
+	rec := httptest.NewRecorder()
+	h.SpotifyCallback(rec, httptest.NewRequest(http.MethodGet, "/auth/callback?code=wrong&state=abc", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	if resp := decodeError(t, rec); resp.Error.Code != ErrCodeSpotifyUnavailable {
+		t.Errorf("code = %q, want %q", resp.Error.Code, ErrCodeSpotifyUnavailable)
+	}
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/claims"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+type Call struct {
+	Number   int          `json:"number"`
+	Track    models.Track `json:"track"`
+	CalledAt time.Time    `json:"called_at"`
+}
+
+type CallRequest struct {
+	TrackID string `json:"track_id"`
+}
+
+type CallsResponse struct {
+	GameCode string `json:"game_code"`
+	Round    int    `json:"round"`
+	Calls    []Call `json:"calls"`
+}
+
+type ClaimRequest struct {
+	PlateNumber int `json:"plate_number"`
+}
+
+type ClaimResponse struct {
+	PlateNumber int    `json:"plate_number"`
+	Round       int    `json:"round"`
+	PrizeTier   string `json:"prize_tier"`
+	Calls       int    `json:"calls"`
+	// Bingo is set when the plate reaches the round's prize tier.
+	Bingo bool `json:"bingo"`
+	// Won lists the prizes of the round the plate holds. Missed lists the
+	// tiers it reached after their prize ran out.
+	Won    []Winner `json:"won"`
+	Missed []string `json:"missed,omitempty"`
+	claims.Result
+}
+
+// CallTrack records that the host played a track. Only the game creator can
+// call, and each track only once per round.
+func (h *GameHandler) CallTrack(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var req CallRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TrackID == "" {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "track_id is required",
+			map[string]any{"field": "track_id"})
+		return
+	}
+	if !h.requireCreator(w, r, gameCode, "Only game creator can call tracks") {
+		return
+	}
+
+	playlistData, err := h.db.GameTracks(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load game tracks", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid game data")
+		return
+	}
+	track, ok := findTrack(playlistData, req.TrackID)
+	if !ok {
+		writeErrorDetails(w, r, http.StatusNotFound, ErrCodeTrackNotFound, "Track is not part of this game",
+			map[string]any{"track_id": req.TrackID})
+		return
+	}
+
+	round, ok := h.activeRound(w, r, gameCode, "Failed to call track")
+	if !ok {
+		return
+	}
+	if h.isExcluded(r.Context(), gameCode, track.ID) {
+		writeErrorDetails(w, r, http.StatusConflict, ErrCodeTrackExcluded, "Track is excluded from the setlist",
+			map[string]any{"track_id": track.ID})
+		return
+	}
+
+	call, err := h.recordCall(r.Context(), gameCode, round.Number, track)
+	if errors.Is(err, errAlreadyCalled) {
+		writeErrorDetails(w, r, http.StatusConflict, ErrCodeAlreadyCalled, "Track has already been called",
+			map[string]any{"track_id": track.ID})
+		return
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to insert call", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to call track")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(call)
+}
+
+var errAlreadyCalled = errors.New("track already called")
+
+// recordCall stores a call of track and numbers it within the round by its
+// position among the round's calls. It returns errAlreadyCalled if the
+// track was called in the round before, possibly by a concurrent request.
+func (h *GameHandler) recordCall(ctx context.Context, gameCode string, round int, track models.Track) (Call, error) {
+	call := Call{Track: track, CalledAt: time.Now()}
+	var id int64
+	err := h.db.QueryRowContext(ctx, `INSERT OR IGNORE INTO calls (game_code, round, track_id, called_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		gameCode, round, track.ID, call.CalledAt).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Call{}, errAlreadyCalled
+	}
+	if err != nil {
+		return Call{}, err
+	}
+	err = h.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM calls WHERE game_code = ? AND round = ? AND id <= ?`, gameCode, round, id).Scan(&call.Number)
+	if err != nil {
+		return Call{}, err
+	}
+	names, err := h.nameOverrides(ctx, gameCode)
+	if err != nil {
+		return Call{}, err
+	}
+	if name, ok := names[track.ID]; ok {
+		call.Track.Name = name
+	}
+	return call, nil
+}
+
+// ListCalls returns the tracks called so far in the current round, or in
+// the round given by ?round=, in order.
+func (h *GameHandler) ListCalls(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var round int
+	if v := r.URL.Query().Get("round"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "round must be a positive number",
+				map[string]any{"field": "round"})
+			return
+		}
+		round = n
+	} else {
+		current, err := h.currentRound(r.Context(), gameCode)
+		if errors.Is(err, errGameNotFound) {
+			writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+			return
+		}
+		if err != nil {
+			middleware.Logger(r.Context()).Error("failed to load round", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch calls")
+			return
+		}
+		round = current.Number
+	}
+
+	calls, err := h.gameCalls(r.Context(), gameCode, round)
+	if errors.Is(err, errGameNotFound) {
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+		return
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load calls", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch calls")
+		return
+	}
+	names, err := h.nameOverrides(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load display names", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch calls")
+		return
+	}
+	for i, c := range calls {
+		if name, ok := names[c.Track.ID]; ok {
+			calls[i].Track.Name = name
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallsResponse{GameCode: gameCode, Round: round, Calls: calls})
+}
+
+// ClaimPlate checks one of the caller's plates against the calls of the
+// current round and awards the prizes it has reached. Marks made on the
+// plate are ignored; only called tracks count.
+func (h *GameHandler) ClaimPlate(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	sessionCookie, err := r.Cookie("session_id")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req ClaimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	round, ok := h.activeRound(w, r, gameCode, "Failed to check claim")
+	if !ok {
+		return
+	}
+	// Claims by team members are credited to the team.
+	owner, err := h.plateOwner(r.Context(), gameCode, sessionCookie.Value)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to check claim")
+		return
+	}
+
+	var fieldsJSON string
+	err = h.db.QueryRow(`SELECT fields FROM plates WHERE game_code = ? AND user_session_id = ? AND plate_number = ? AND `+currentPlates,
+		gameCode, owner, req.PlateNumber).Scan(&fieldsJSON)
+	if err != nil {
+		writeErrorDetails(w, r, http.StatusNotFound, ErrCodePlateNotFound, "You have no such plate in this game",
+			map[string]any{"plate_number": req.PlateNumber})
+		return
+	}
+	fields, err := models.PlateFieldsFromJSON(fieldsJSON)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to decode plate", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid plate data")
+		return
+	}
+
+	calls, err := h.gameCalls(r.Context(), gameCode, round.Number)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load calls", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to check claim")
+		return
+	}
+	// Field keys were built from cleaned names, so calls are cleaned the
+	// same way before matching.
+	called := make([]models.Track, len(calls))
+	for i, c := range calls {
+		called[i] = c.Track
+	}
+	called = h.cleanNames(models.PlaylistData{Tracks: called}).Tracks
+
+	result := h.claims.Check(fields, h.claims.Called(called))
+	for _, prize := range round.Prizes {
+		if !result.Reaches(prize.Tier) {
+			continue
+		}
+		callNumber := h.claims.WonAt(fields, called, prize.Tier)
+		if err := h.award(r.Context(), gameCode, round.Number, prize, owner, req.PlateNumber, callNumber); err != nil {
+			middleware.Logger(r.Context()).Error("failed to record winner", "tier", prize.Tier, "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to check claim")
+			return
+		}
+	}
+
+	resp := ClaimResponse{
+		PlateNumber: req.PlateNumber,
+		Round:       round.Number,
+		PrizeTier:   round.PrizeTier,
+		Calls:       len(calls),
+		Bingo:       result.Reaches(round.PrizeTier),
+		Won:         []Winner{},
+		Result:      result,
+	}
+	winners, err := h.listWinners(r.Context(), gameCode, round.Number)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load winners", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to check claim")
+		return
+	}
+	seats, err := h.seatNumbers(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load seats", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to check claim")
+		return
+	}
+	won := make(map[string]bool)
+	for _, win := range winners {
+		if win.Player == seats[owner] && win.PlateNumber == req.PlateNumber {
+			resp.Won = append(resp.Won, win)
+			won[win.Tier] = true
+		}
+	}
+	for _, prize := range round.Prizes {
+		if result.Reaches(prize.Tier) && !won[prize.Tier] {
+			resp.Missed = append(resp.Missed, prize.Tier)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+var errGameNotFound = errors.New("game not found")
+
+// gameCalls loads the calls of a round with their tracks.
+func (h *GameHandler) gameCalls(ctx context.Context, gameCode string, round int) ([]Call, error) {
+	var exists int
+	if err := h.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM games WHERE game_code = ?`, gameCode).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, errGameNotFound
+	}
+
+	playlistData, err := h.db.GameTracks(ctx, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make(map[string]models.Track, len(playlistData.Tracks))
+	for _, t := range playlistData.Tracks {
+		tracks[t.ID] = t
+	}
+
+	rows, err := h.db.QueryContext(ctx, `SELECT track_id, called_at FROM calls WHERE game_code = ? AND round = ? ORDER BY id`, gameCode, round)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calls := []Call{}
+	for rows.Next() {
+		var trackID string
+		var calledAt time.Time
+		if err := rows.Scan(&trackID, &calledAt); err != nil {
+			return nil, err
+		}
+		track, ok := tracks[trackID]
+		if !ok {
+			track = models.Track{ID: trackID}
+		}
+		calls = append(calls, Call{Number: len(calls) + 1, Track: track, CalledAt: calledAt})
+	}
+	return calls, rows.Err()
+}
+
+// requireCreator writes an error response and returns false unless the
+// caller created the game.
+func (h *GameHandler) requireCreator(w http.ResponseWriter, r *http.Request, gameCode, forbidden string) bool {
+	sessionCookie, err := r.Cookie("session_id")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Not authenticated")
+		return false
+	}
+
+	var creatorID string
+	err = h.db.QueryRow(`SELECT creator_session_id FROM games WHERE game_code = ?`, gameCode).Scan(&creatorID)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+		return false
+	}
+	if creatorID != sessionCookie.Value {
+		middleware.Logger(r.Context()).Warn("non-creator attempted a host action")
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, forbidden)
+		return false
+	}
+	return true
+}
+
+func findTrack(data models.PlaylistData, id string) (models.Track, bool) {
+	for _, t := range data.Tracks {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return models.Track{}, false
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func callTrack(t *testing.T, h *GameHandler, sessionID, gameCode, trackID string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.CallTrack, http.MethodPost, "/api/games/"+gameCode+"/calls", sessionID, `{"track_id":"`+trackID+`"}`, "code", gameCode)
+}
+
+func claimPlate(t *testing.T, h *GameHandler, sessionID, gameCode string, plateNumber int) ClaimResponse {
+	t.Helper()
+	body, _ := json.Marshal(ClaimRequest{PlateNumber: plateNumber})
+	rec := serve(t, h.ClaimPlate, http.MethodPost, "/api/games/"+gameCode+"/claims", sessionID, string(body), "code", gameCode)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("claim status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp ClaimResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return resp
+}
+
+func TestCallsAndClaims(t *testing.T) {
+	env := newTestEnv(t)
+	tracks := spotifytest.Tracks(30)
+	for i := range tracks {
+		tracks[i].Name += " - Radio Edit"
+	}
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: tracks})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"artists"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	plate := game.Plates[0]
+
+	if resp := claimPlate(t, h, creator, game.GameCode, plate.PlateNumber); resp.Rows != 0 || resp.Calls != 0 {
+		t.Errorf("claim before any call = %+v", resp)
+	}
+
+	// Artist fields carry no track ID; calling the artist's track must
+	// satisfy them.
+	artistTrack := make(map[string]string)
+	for _, tr := range tracks {
+		artistTrack[tr.Artists[0].Name] = tr.ID
+	}
+	for _, field := range plate.Fields.Grid[0] {
+		if field.Content == "" {
+			continue
+		}
+		if rec := callTrack(t, h, creator, game.GameCode, artistTrack[field.Content]); rec.Code != http.StatusOK {
+			t.Fatalf("call status = %d, body = %s", rec.Code, rec.Body)
+		}
+	}
+
+	resp := claimPlate(t, h, creator, game.GameCode, plate.PlateNumber)
+	if resp.Calls != 5 || resp.Rows != 1 || resp.Full {
+		t.Errorf("claim after first row called = %+v", resp)
+	}
+	for col, field := range plate.Fields.Grid[0] {
+		if resp.Matched[0][col] != (field.Content != "") {
+			t.Errorf("matched[0][%d] = %v for %q", col, resp.Matched[0][col], field.Content)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		sessionID string
+		trackID   string
+		status    int
+		code      ErrorCode
+	}{
+		{"non-creator", env.loggedInSession(t), "track1", http.StatusForbidden, ErrCodeForbidden},
+		{"unknown track", creator, "nope", http.StatusNotFound, ErrCodeTrackNotFound},
+	}
+	for _, tt := range tests {
+		rec := callTrack(t, h, tt.sessionID, game.GameCode, tt.trackID)
+		if rec.Code != tt.status || decodeError(t, rec).Error.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d %s", tt.name, rec.Code, tt.status, tt.code)
+		}
+	}
+
+	var called string
+	for _, field := range plate.Fields.Grid[0] {
+		if field.Content != "" {
+			called = artistTrack[field.Content]
+			break
+		}
+	}
+	if rec := callTrack(t, h, creator, game.GameCode, called); rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeAlreadyCalled {
+		t.Errorf("repeat call status = %d, want 409 %s", rec.Code, ErrCodeAlreadyCalled)
+	}
+
+	rec = serve(t, h.ListCalls, http.MethodGet, "/api/games/"+game.GameCode+"/calls", "", "", "code", game.GameCode)
+	var calls CallsResponse
+	json.NewDecoder(rec.Body).Decode(&calls)
+	if len(calls.Calls) != 5 || calls.Calls[4].Number != 5 || calls.Calls[0].Track.Name == "" {
+		t.Errorf("calls = %+v", calls.Calls)
+	}
+
+	// The ignored repeat doesn't take a number.
+	for i := 1; ; i++ {
+		rec := callTrack(t, h, creator, game.GameCode, fmt.Sprintf("track%d", i))
+		if rec.Code == http.StatusConflict {
+			continue
+		}
+		var call Call
+		json.NewDecoder(rec.Body).Decode(&call)
+		if rec.Code != http.StatusOK || call.Number != 6 {
+			t.Errorf("next call status = %d, number = %d, want 200 and 6", rec.Code, call.Number)
+		}
+		break
+	}
+}
+
+func TestDecadeFieldsMatchAnyCallFromThePeriod(t *testing.T) {
+	env := newTestEnv(t)
+	dates := []string{"1987-06-15", "1994", "2013-02"}
+	tracks := spotifytest.Tracks(30)
+	for i := range tracks {
+		tracks[i].Album = &spotify.Album{ID: "album", Name: "Album", ReleaseDate: dates[i%len(dates)]}
+	}
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Decades", Tracks: tracks})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"decades"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	decades := make(map[string][2]int)
+	for row, fields := range game.Plates[0].Fields.Grid {
+		for col, f := range fields {
+			if f.Type == "decade" {
+				decades[f.Content] = [2]int{row, col}
+			}
+		}
+	}
+	if len(decades) != 3 {
+		t.Fatalf("decade fields = %v, want 80s, 90s and 2010s", decades)
+	}
+	pos, ok := decades["80s"]
+	if !ok {
+		t.Fatalf("no 80s field in %v", decades)
+	}
+
+	// track4 is from 1987, like track1.
+	if rec := callTrack(t, h, creator, game.GameCode, "track4"); rec.Code != http.StatusOK {
+		t.Fatalf("call status = %d, body = %s", rec.Code, rec.Body)
+	}
+	resp := claimPlate(t, h, creator, game.GameCode, game.Plates[0].PlateNumber)
+	if !resp.Matched[pos[0]][pos[1]] {
+		t.Errorf("80s field not matched by a 1987 call")
+	}
+	if pos := decades["90s"]; resp.Matched[pos[0]][pos[1]] {
+		t.Errorf("90s field matched by a 1987 call")
+	}
+}
+
+func TestGenreFieldsFromBatchedArtistLookups(t *testing.T) {
+	env := newTestEnv(t)
+	genres := []string{"synthpop", "new wave", "house", "disco"}
+	tracks := spotifytest.Tracks(60)
+	for i := range tracks {
+		id := fmt.Sprintf("artist%d", i+1)
+		tracks[i].Artists[0].ID = id
+		tracks[i].Album = &spotify.Album{ID: "album", Name: fmt.Sprintf("Album %d", i%5)}
+		env.spotify.AddArtist(spotifytest.Artist{ID: id, Name: tracks[i].Artists[0].Name, Genres: []string{genres[i%len(genres)]}})
+	}
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Club", Tracks: tracks})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"genres"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if got := env.spotify.Requests(spotifytest.RouteArtists); got != 2 {
+		t.Errorf("artist batch requests = %d, want 2 for 60 artists", got)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	stored, err := env.db.GameTracks(context.Background(), game.GameCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stored.Tracks[2]; got.Album != "Album 2" || len(got.Genres) != 1 || got.Genres[0] != "house" {
+		t.Errorf("stored track = %+v, want album and genre", got)
+	}
+
+	positions := make(map[string][2]int)
+	for row, fields := range game.Plates[0].Fields.Grid {
+		for col, f := range fields {
+			if f.Type == "genre" {
+				positions[f.Content] = [2]int{row, col}
+			}
+		}
+	}
+	if len(positions) != len(genres) {
+		t.Fatalf("genre fields = %v, want one of each", positions)
+	}
+
+	// track3's artist plays house.
+	if rec := callTrack(t, h, creator, game.GameCode, "track3"); rec.Code != http.StatusOK {
+		t.Fatalf("call status = %d, body = %s", rec.Code, rec.Body)
+	}
+	resp := claimPlate(t, h, creator, game.GameCode, game.Plates[0].PlateNumber)
+	for genre, pos := range positions {
+		if got := resp.Matched[pos[0]][pos[1]]; got != (genre == "house") {
+			t.Errorf("%s field matched = %v after calling a house track", genre, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// PlateDifficulty is the difficulty score of one plate. Players are
+// numbered by seat rather than identified, since plate owners are sessions.
+type PlateDifficulty struct {
+	Player      int `json:"player"`
+	PlateNumber int `json:"plate_number"`
+	// Score runs from 0 for a plate of hits to 100 for one of deep cuts.
+	// It is null for plates without popularity data.
+	Score *float64 `json:"score"`
+}
+
+type DifficultyResponse struct {
+	GameCode   string            `json:"game_code"`
+	Difficulty string            `json:"difficulty"`
+	Plates     []PlateDifficulty `json:"plates"`
+	// Spread is the gap between the easiest and hardest scored plate.
+	Spread float64 `json:"spread"`
+	// Fairness is null for games created before plates were balanced.
+	Fairness *models.FairnessReport `json:"fairness"`
+}
+
+// GetDifficulty lets the host review how evenly difficult the plates in
+// play turned out, and how evenly they are expected to finish.
+func (h *GameHandler) GetDifficulty(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can review plate difficulty") {
+		return
+	}
+
+	resp := DifficultyResponse{GameCode: gameCode, Plates: []PlateDifficulty{}}
+	var fairnessJSON string
+	err := h.db.QueryRow(`SELECT difficulty, fairness FROM games WHERE game_code = ?`, gameCode).Scan(&resp.Difficulty, &fairnessJSON)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load game difficulty", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch plate difficulty")
+		return
+	}
+	if fairnessJSON != "" {
+		if report, err := models.FairnessReportFromJSON(fairnessJSON); err == nil {
+			resp.Fairness = &report
+		}
+	}
+
+	rows, err := h.db.Query(`SELECT user_session_id, plate_number, difficulty FROM plates WHERE game_code = ? AND `+currentPlates+` ORDER BY id`, gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to query plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch plate difficulty")
+		return
+	}
+	defer rows.Close()
+
+	seats := make(map[string]int)
+	var lo, hi *float64
+	for rows.Next() {
+		var owner string
+		var plate PlateDifficulty
+		var score sql.NullFloat64
+		if err := rows.Scan(&owner, &plate.PlateNumber, &score); err != nil {
+			middleware.Logger(r.Context()).Error("failed to scan plate", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch plate difficulty")
+			return
+		}
+		if _, ok := seats[owner]; !ok {
+			seats[owner] = len(seats) + 1
+		}
+		plate.Player = seats[owner]
+		if score.Valid {
+			s := score.Float64
+			plate.Score = &s
+			if lo == nil || s < *lo {
+				lo = &s
+			}
+			if hi == nil || s > *hi {
+				hi = &s
+			}
+		}
+		resp.Plates = append(resp.Plates, plate)
+	}
+	if lo != nil {
+		resp.Spread = *hi - *lo
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
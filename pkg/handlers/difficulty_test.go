@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func getDifficulty(t *testing.T, h *GameHandler, sessionID, gameCode string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.GetDifficulty, http.MethodGet, "/api/games/"+gameCode+"/difficulty", sessionID, "", "code", gameCode)
+}
+
+func TestDifficultyFollowsPopularity(t *testing.T) {
+	env := newTestEnv(t)
+	tracks := spotifytest.Tracks(120)
+	for i := range tracks {
+		tracks[i].Popularity = i%100 + 1
+	}
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: tracks})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	mean := make(map[string]float64)
+	for _, difficulty := range []string{"easy", "hard"} {
+		rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":4,"plates_per_player":1,"content_type":"tracks","difficulty":"`+difficulty+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var game CreateGameResponse
+		json.NewDecoder(rec.Body).Decode(&game)
+
+		rec = getDifficulty(t, h, creator, game.GameCode)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("difficulty status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var resp DifficultyResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if resp.Difficulty != difficulty || len(resp.Plates) != 4 {
+			t.Fatalf("%s: difficulty = %q with %d plates", difficulty, resp.Difficulty, len(resp.Plates))
+		}
+		for i, p := range resp.Plates {
+			if p.Player != i+1 || p.Score == nil {
+				t.Fatalf("%s: plate %d = %+v, want player %d with a score", difficulty, i, p, i+1)
+			}
+			mean[difficulty] += *p.Score / 4
+		}
+		if resp.Spread > 2*5 {
+			t.Errorf("%s: spread = %.1f, want plates within 10 points of each other", difficulty, resp.Spread)
+		}
+
+		if rec := getDifficulty(t, h, env.loggedInSession(t), game.GameCode); rec.Code != http.StatusForbidden {
+			t.Errorf("non-creator status = %d, want 403", rec.Code)
+		}
+	}
+	if mean["easy"]+20 > mean["hard"] {
+		t.Errorf("easy mean score %.1f not well below hard %.1f", mean["easy"], mean["hard"])
+	}
+
+	var code string
+	env.db.QueryRow(`SELECT game_code FROM games LIMIT 1`).Scan(&code)
+	stored, err := env.db.GameTracks(context.Background(), code)
+	if err != nil || stored.Tracks[9].Popularity != 10 {
+		t.Errorf("stored popularity = %+v, %v, want 10", stored.Tracks[9], err)
+	}
+}
+
+func TestDifficultyUnscoredWithoutPopularity(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(30)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"difficulty":"extreme"}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Error.Code != ErrCodeInvalidRequest {
+		t.Errorf("unknown difficulty status = %d, want 400", rec.Code)
+	}
+
+	rec = createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"difficulty":"hard"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	var resp DifficultyResponse
+	json.NewDecoder(getDifficulty(t, h, creator, game.GameCode).Body).Decode(&resp)
+	for _, p := range resp.Plates {
+		if p.Score != nil {
+			t.Errorf("plate %+v scored without popularity data", p)
+		}
+	}
+}
+
+func TestCreateGameWithPlayOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"play_order":["track3","nope"]}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Error.Code != ErrCodeInvalidRequest {
+		t.Errorf("unknown track in play order status = %d, want 400", rec.Code)
+	}
+
+	order := `"track40"`
+	for i := 39; i >= 1; i-- {
+		order += `,"track` + strconv.Itoa(i) + `"`
+	}
+	rec = createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"play_order":[`+order+`]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	var resp DifficultyResponse
+	json.NewDecoder(getDifficulty(t, h, creator, game.GameCode).Body).Decode(&resp)
+	if resp.Fairness == nil || resp.Fairness.PlayOrder != "planned" {
+		t.Fatalf("fairness = %+v, want a report for the planned order", resp.Fairness)
+	}
+	if resp.Fairness.After.Max() > resp.Fairness.Before.Max() {
+		t.Errorf("spread grew from %+v to %+v", resp.Fairness.Before, resp.Fairness.After)
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+)
+
+// ErrorCode is a stable, machine-readable identifier for an API error.
+// Clients should branch on the code, never on the message.
+type ErrorCode string
+
+const (
+	ErrCodeInvalidRequest     ErrorCode = "invalid_request"
+	ErrCodeUnauthorized       ErrorCode = "unauthorized"
+	ErrCodeSessionExpired     ErrorCode = "session_expired"
+	ErrCodeForbidden          ErrorCode = "forbidden"
+	ErrCodeGameNotFound       ErrorCode = "game_not_found"
+	ErrCodeGameFull           ErrorCode = "game_full"
+	ErrCodeTrackNotFound      ErrorCode = "track_not_found"
+	ErrCodeAlreadyCalled      ErrorCode = "already_called"
+	ErrCodePlateNotFound      ErrorCode = "plate_not_found"
+	ErrCodeTrackExcluded      ErrorCode = "track_excluded"
+	ErrCodeTrackOnPlate       ErrorCode = "track_on_plate"
+	ErrCodeSetlistLocked      ErrorCode = "setlist_locked"
+	ErrCodeSetlistFinished    ErrorCode = "setlist_finished"
+	ErrCodeRoundNotFound      ErrorCode = "round_not_found"
+	ErrCodeRoundFinished      ErrorCode = "round_finished"
+	ErrCodeTeamNotFound       ErrorCode = "team_not_found"
+	ErrCodeTeamNameTaken      ErrorCode = "team_name_taken"
+	ErrCodeAlreadySeated      ErrorCode = "already_seated"
+	ErrCodeSeatNotFound       ErrorCode = "seat_not_found"
+	ErrCodeSeatOpen           ErrorCode = "seat_open"
+	ErrCodeTransferNotFound   ErrorCode = "transfer_not_found"
+	ErrCodeInvalidPlaylist    ErrorCode = "invalid_playlist"
+	ErrCodePlaylistTooSmall   ErrorCode = "playlist_too_small"
+	ErrCodeSpotifyUnavailable ErrorCode = "spotify_unavailable"
+	ErrCodeInternal           ErrorCode = "internal_error"
+)
+
+// APIError is the body of every non-2xx JSON response.
+type APIError struct {
+	Code    ErrorCode      `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error     APIError `json:"error"`
+	RequestID string   `json:"request_id,omitempty"`
+}
+
+// writeError sends an error envelope. The request ID is included so a player
+// reporting a problem can quote something we can find in the logs.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	writeErrorDetails(w, r, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string, details map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: APIError{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+		RequestID: middleware.RequestIDFromContext(r.Context()),
+	})
+}
+
+// writeSpotifyError maps an error from the Spotify client onto the error
+// envelope. message is used when Spotify itself is at fault.
+func writeSpotifyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var statusErr *spotify.StatusError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The browser went away, so there is nobody left to answer.
+		return
+	case errors.Is(err, spotify.ErrUnauthorized):
+		writeError(w, r, http.StatusUnauthorized, ErrCodeSessionExpired, "Spotify session expired, please log in again")
+	case errors.Is(err, spotify.ErrForbidden):
+		writeError(w, r, http.StatusForbidden, ErrCodeSessionExpired, "Spotify denied access, please log in again to grant the required permissions")
+	case errors.Is(err, spotify.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, ErrCodeInvalidPlaylist, "Playlist, album or artist not found or not accessible")
+	case errors.Is(err, spotify.ErrRateLimited) && errors.As(err, &statusErr) && statusErr.RetryAfter > 0:
+		seconds := int(statusErr.RetryAfter.Round(time.Second) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeErrorDetails(w, r, http.StatusServiceUnavailable, ErrCodeSpotifyUnavailable, "Spotify is rate limiting requests, please try again shortly",
+			map[string]any{"retry_after_seconds": seconds})
+	default:
+		writeError(w, r, http.StatusBadGateway, ErrCodeSpotifyUnavailable, message)
+	}
+}
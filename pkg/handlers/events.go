@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/metrics"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+)
+
+const (
+	// eventBuffer is how many events a slow stream may fall behind before
+	// further events to it are dropped.
+	eventBuffer = 16
+
+	// eventKeepAlive is how often an idle stream sends a comment, so
+	// proxies don't close it.
+	eventKeepAlive = 25 * time.Second
+)
+
+// Event is a server-sent event.
+type Event struct {
+	Type string
+	Data any
+}
+
+// eventHub fans events out to the open streams of each plate owner, so
+// every member of a team sees the others' marks.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Event]bool
+
+	// done is closed when the server shuts down.
+	done    chan struct{}
+	closing sync.Once
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs: make(map[string]map[chan Event]bool),
+		done: make(chan struct{}),
+	}
+}
+
+func eventKey(gameCode, owner string) string {
+	return gameCode + "/" + owner
+}
+
+func (hub *eventHub) subscribe(key string) (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	hub.mu.Lock()
+	if hub.subs[key] == nil {
+		hub.subs[key] = make(map[chan Event]bool)
+	}
+	hub.subs[key][ch] = true
+	hub.mu.Unlock()
+
+	return ch, func() {
+		hub.mu.Lock()
+		delete(hub.subs[key], ch)
+		if len(hub.subs[key]) == 0 {
+			delete(hub.subs, key)
+		}
+		hub.mu.Unlock()
+	}
+}
+
+func (hub *eventHub) publish(key string, e Event) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for ch := range hub.subs[key] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (hub *eventHub) close() {
+	hub.closing.Do(func() { close(hub.done) })
+}
+
+// CloseStreams ends every open event stream and any opened later. Streams
+// only end on their own when the client goes away, so the server calls
+// this on shutdown rather than waiting for them.
+func (h *GameHandler) CloseStreams() {
+	h.events.close()
+}
+
+// StreamEvents sends the caller changes to the plates they play as
+// server-sent events, such as marks made by their teammates.
+func (h *GameHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	sessionCookie, err := r.Cookie("session_id")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Not authenticated")
+		return
+	}
+	if _, err := h.currentRound(r.Context(), gameCode); err != nil {
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+		return
+	}
+	owner, err := h.plateOwner(r.Context(), gameCode, sessionCookie.Value)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to open event stream")
+		return
+	}
+
+	// Streams outlive the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	events, unsubscribe := h.events.subscribe(eventKey(gameCode, owner))
+	defer unsubscribe()
+	metrics.SSEConnections.Inc()
+	defer metrics.SSEConnections.Dec()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.events.done:
+			return
+		case e := <-events:
+			data, _ := json.Marshal(e.Data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func TestStreamsEndOnShutdown(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("code", game.GameCode)
+		h.StreamEvents(w, r)
+	}))
+	srv.Config.RegisterOnShutdown(h.CloseStreams)
+	srv.Start()
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.AddCookie(sessionCookie(creator))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewReader(resp.Body)
+	if line, _ := stream.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("stream opened with %q", line)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown with an open stream: %v", err)
+	}
+	if _, err := io.ReadAll(stream); err != nil {
+		t.Errorf("stream didn't end cleanly: %v", err)
+	}
+}
@@ -1,39 +1,84 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/kirkegaard/go-spotify-bingo/pkg/claims"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/config"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/database"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/generator"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/metrics"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/normalize"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
 )
 
 type GameHandler struct {
-	db        *database.DB
-	generator *generator.Generator
+	db            *database.DB
+	generator     *generator.Generator
+	names         *trackname.Cleaner
+	claims        *claims.Checker
+	events        *eventHub
+	baseURL       string
+	spotifyAPIURL string
 }
 
-func NewGameHandler(db *database.DB) *GameHandler {
+func NewGameHandler(db *database.DB, cfg *config.Config, names *trackname.Cleaner) *GameHandler {
 	return &GameHandler{
-		db:        db,
-		generator: generator.New(),
+		db:            db,
+		generator:     generator.New(),
+		names:         names,
+		claims:        claims.New(normalize.Default()),
+		events:        newEventHub(),
+		baseURL:       cfg.BaseURL,
+		spotifyAPIURL: cfg.SpotifyAPIURL,
 	}
 }
 
 type CreateGameRequest struct {
-	PlaylistID      string `json:"playlist_id"`
-	PlaylistURL     string `json:"playlist_url"`
-	PlayerCount     int    `json:"player_count"`
-	PlatesPerPlayer int    `json:"plates_per_player"`
-	ContentType     string `json:"content_type"`
+	PlaylistID  string `json:"playlist_id"`
+	PlaylistURL string `json:"playlist_url"`
+	// Sources are merged with the playlist above, if any. Each one is
+	// either a type and ID or a Spotify link.
+	Sources         []SourceRequest `json:"sources"`
+	PlayerCount     int             `json:"player_count"`
+	PlatesPerPlayer int             `json:"plates_per_player"`
+	ContentType     string          `json:"content_type"`
+	// ContentRecipe takes precedence over ContentType when set.
+	ContentRecipe *models.ContentRecipe `json:"content_recipe"`
+	// Difficulty is easy, normal or hard; see models.DifficultyEasy.
+	Difficulty string `json:"difficulty"`
+	// PlayOrder is the planned order of calls as track IDs. Plates are
+	// balanced so they finish evenly in that order, or in random order
+	// when it is empty.
+	PlayOrder []string `json:"play_order"`
+	// Exclude lists track IDs to leave out of the setlist. They never
+	// appear on plates.
+	Exclude []string `json:"exclude"`
+	// PrizeTier is what wins the first round: one_row, two_rows or full.
+	// It defaults to the hardest tier of Prizes, or one_row.
+	PrizeTier string `json:"prize_tier"`
+	// Prizes are what the first round awards per tier.
+	Prizes []models.Prize `json:"prizes"`
 }
 
+type SourceRequest struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	URL  string `json:"url"`
+}
+
+const maxSources = 10
+
 type CreateGameResponse struct {
 	GameCode string         `json:"game_code"`
 	Plates   []models.Plate `json:"plates"`
@@ -42,215 +87,488 @@ type CreateGameResponse struct {
 func (h *GameHandler) CreateGame(w http.ResponseWriter, r *http.Request) {
 	sessionCookie, err := r.Cookie("session_id")
 	if err != nil {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Not authenticated")
 		return
 	}
 
 	var req CreateGameRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		middleware.Logger(r.Context()).Warn("invalid create game request body", "error", err)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
 		return
 	}
 
-	if req.PlayerCount <= 0 || req.PlayerCount > 20 {
-		http.Error(w, "Player count must be between 1 and 20", http.StatusBadRequest)
+	settings := gameSettings{
+		PlayerCount:     req.PlayerCount,
+		PlatesPerPlayer: req.PlatesPerPlayer,
+		ContentType:     req.ContentType,
+		Recipe:          req.ContentRecipe,
+		Difficulty:      req.Difficulty,
+		PlayOrder:       req.PlayOrder,
+		Exclude:         req.Exclude,
+		PrizeTier:       req.PrizeTier,
+		Prizes:          req.Prizes,
+	}
+	if !settings.validate(w, r) {
 		return
 	}
 
-	// Validate and set default plates per player
-	if req.PlatesPerPlayer <= 0 {
-		req.PlatesPerPlayer = 3
-	}
-	if req.PlatesPerPlayer > 10 {
-		http.Error(w, "Plates per player must be 10 or fewer", http.StatusBadRequest)
+	var session models.UserSession
+	err = h.db.QueryRow(`SELECT session_id, spotify_token, token_expires_at FROM user_sessions WHERE session_id = ?`,
+		sessionCookie.Value).Scan(&session.SessionID, &session.SpotifyToken, &session.TokenExpiresAt)
+
+	if err != nil || session.SpotifyToken == "" || time.Now().After(session.TokenExpiresAt) {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeSessionExpired, "Invalid or expired session")
 		return
 	}
 
-	// Validate content type
-	if req.ContentType == "" {
-		req.ContentType = models.ContentTypeMixed
-	}
-	if req.ContentType != models.ContentTypeMixed && req.ContentType != models.ContentTypeTracks && req.ContentType != models.ContentTypeArtists && req.ContentType != models.ContentTypeCombined {
-		http.Error(w, "Invalid content type", http.StatusBadRequest)
+	client := spotify.NewClient(session.SpotifyToken, spotify.WithAPIURL(h.spotifyAPIURL))
+
+	sources, ok := h.requestSources(w, r, client, req)
+	if !ok {
 		return
 	}
 
-	var session models.UserSession
-	err = h.db.QueryRow(`SELECT session_id, spotify_token, expires_at FROM user_sessions WHERE session_id = ?`,
-		sessionCookie.Value).Scan(&session.SessionID, &session.SpotifyToken, &session.ExpiresAt)
-
-	if err != nil || session.SpotifyToken == "" || time.Now().After(session.ExpiresAt) {
-		http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+	// Spotify calls use the request context so a closed browser tab stops
+	// paging through a large playlist. Unchanged playlists come from the
+	// snapshot cache.
+	playlistData, resolved, err := client.FetchSources(r.Context(), sources, h.db)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to fetch tracks", "sources", sources, "error", err)
+		writeSpotifyError(w, r, err, "Failed to fetch tracks from Spotify")
 		return
 	}
+	source := models.SourceDescriptor{Sources: resolved}
 
-	client := spotify.NewClient(session.SpotifyToken)
+	h.createGame(w, r, session.SessionID, settings, playlistData, source)
+}
 
-	var playlistID string
-	if req.PlaylistURL != "" {
-		playlistID = extractPlaylistIDFromURL(req.PlaylistURL)
-	} else {
-		playlistID = req.PlaylistID
+// gameSettings are the size and content options shared by every way of
+// creating a game.
+// maxPlayers bounds the seats of a game, including those the host adds
+// after creation.
+const maxPlayers = 20
+
+type gameSettings struct {
+	PlayerCount     int
+	PlatesPerPlayer int
+	ContentType     string
+	// Recipe is the caller's recipe, if any; validate replaces it with the
+	// one for ContentType otherwise.
+	Recipe     *models.ContentRecipe
+	Difficulty string
+	PlayOrder  []string
+	Exclude    []string
+	PrizeTier  string
+	Prizes     []models.Prize
+}
+
+// validate fills in defaults and writes an error response if the settings
+// are out of range.
+func (gs *gameSettings) validate(w http.ResponseWriter, r *http.Request) bool {
+	if gs.PlayerCount <= 0 || gs.PlayerCount > maxPlayers {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Player count must be between 1 and %d", maxPlayers),
+			map[string]any{"field": "player_count", "min": 1, "max": maxPlayers})
+		return false
 	}
 
-	if playlistID == "" {
-		http.Error(w, "Invalid playlist", http.StatusBadRequest)
-		return
+	// Validate and set default plates per player
+	if gs.PlatesPerPlayer <= 0 {
+		gs.PlatesPerPlayer = 3
+	}
+	if gs.PlatesPerPlayer > 10 {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Plates per player must be 10 or fewer",
+			map[string]any{"field": "plates_per_player", "max": 10})
+		return false
 	}
 
-	playlist, err := client.GetPlaylistByID(playlistID)
-	if err != nil {
-		log.Printf("Error fetching playlist by ID %s: %v", playlistID, err)
-		http.Error(w, "Failed to fetch playlist info", http.StatusInternalServerError)
-		return
+	if gs.Difficulty == "" {
+		gs.Difficulty = models.DifficultyNormal
+	}
+	switch gs.Difficulty {
+	case models.DifficultyEasy, models.DifficultyNormal, models.DifficultyHard:
+	default:
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Difficulty must be easy, normal or hard",
+			map[string]any{"field": "difficulty"})
+		return false
 	}
 
-	playlistData, err := client.GetPlaylistTracks(playlistID)
+	tier, prizes, err := resolvePrizes(gs.PrizeTier, gs.Prizes)
 	if err != nil {
-		log.Printf("Error fetching playlist tracks for ID %s: %v", playlistID, err)
-		http.Error(w, "Failed to fetch playlist tracks", http.StatusInternalServerError)
-		return
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid prizes",
+			map[string]any{"field": "prizes", "reason": err.Error()})
+		return false
+	}
+	gs.PrizeTier, gs.Prizes = tier, prizes
+
+	// An explicit recipe wins; the content type strings map to fixed
+	// recipes.
+	if gs.Recipe != nil {
+		if err := gs.Recipe.Validate(); err != nil {
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid content recipe",
+				map[string]any{"field": "content_recipe", "reason": err.Error()})
+			return false
+		}
+		gs.ContentType = models.ContentTypeCustom
+		return true
 	}
 
-	playlistData.PlaylistName = playlist.Name
+	if gs.ContentType == "" {
+		gs.ContentType = models.ContentTypeMixed
+	}
+	recipe, ok := models.RecipeForContentType(gs.ContentType)
+	if !ok {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid content type",
+			map[string]any{"field": "content_type"})
+		return false
+	}
+	gs.Recipe = &recipe
+
+	return true
+}
+
+// createGame stores a game built from playlistData, generates every plate
+// and responds with the creator's plates. All creation paths end here.
+// Every source must already be saved in the sources tables.
+func (h *GameHandler) createGame(w http.ResponseWriter, r *http.Request, creatorID string, settings gameSettings, playlistData models.PlaylistData, source models.SourceDescriptor) {
+	if !checkTrackIDs(w, r, playlistData, "play_order", settings.PlayOrder) ||
+		!checkTrackIDs(w, r, playlistData, "exclude", settings.Exclude) {
+		return
+	}
+	// Plates are generated from the setlist, so excluded tracks can't end
+	// up on them.
+	setlist := arrangeSetlist(playlistData.Tracks, settings.PlayOrder, settings.Exclude)
+	playable := playlistData
+	playable.Tracks = setlist.playable()
 
-	requiredTracks := req.PlayerCount * req.PlatesPerPlayer * 5
-	totalPlates := req.PlayerCount * req.PlatesPerPlayer
-	if len(playlistData.Tracks) < requiredTracks {
-		http.Error(w, fmt.Sprintf("Playlist must have at least %d tracks for %d players (%d plates total)", requiredTracks, req.PlayerCount, totalPlates), http.StatusBadRequest)
+	totalPlates := settings.PlayerCount * settings.PlatesPerPlayer
+	recipe := *settings.Recipe
+	if err := h.generator.CheckCapacity(playable, totalPlates, recipe); err != nil {
+		writeCapacityError(w, r, err, settings.PlayerCount, settings.PlatesPerPlayer)
 		return
 	}
 
 	gameCode := generator.GenerateGameCode()
-	playlistJSON, _ := playlistData.ToJSON()
+	middleware.SetGameCode(r.Context(), gameCode)
+	// The tracks live in the sources tables, shared between games built
+	// from the same snapshot; the game row only keeps the name.
+	playlistJSON, _ := models.PlaylistData{
+		PlaylistID:   playlistData.PlaylistID,
+		PlaylistName: playlistData.PlaylistName,
+		Tracks:       []models.Track{},
+	}.ToJSON()
+	sourceJSON, _ := source.ToJSON()
+	recipeJSON, _ := recipe.ToJSON()
 
 	game := models.Game{
 		GameCode:        gameCode,
-		CreatorID:       session.SessionID,
-		PlayerCount:     req.PlayerCount,
-		PlatesPerPlayer: req.PlatesPerPlayer,
-		ContentType:     req.ContentType,
+		CreatorID:       creatorID,
+		PlayerCount:     settings.PlayerCount,
+		PlatesPerPlayer: settings.PlatesPerPlayer,
+		ContentType:     settings.ContentType,
+		ContentRecipe:   recipe,
+		Difficulty:      settings.Difficulty,
 		PlaylistData:    playlistData,
+		Source:          source,
 		CreatedAt:       time.Now(),
 	}
 
-	_, err = h.db.Exec(`INSERT INTO games (game_code, creator_session_id, player_count, plates_per_player, content_type, playlist_data, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		game.GameCode, game.CreatorID, game.PlayerCount, game.PlatesPerPlayer, game.ContentType, playlistJSON, game.CreatedAt)
+	// The game is stored whole or not at all.
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create game")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO games (game_code, creator_session_id, player_count, plates_per_player, content_type, content_recipe, difficulty, playlist_data, source, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		game.GameCode, game.CreatorID, game.PlayerCount, game.PlatesPerPlayer, game.ContentType, recipeJSON, game.Difficulty, playlistJSON, sourceJSON, game.CreatedAt)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to insert game", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create game")
+		return
+	}
+	if err := database.LinkGameSources(r.Context(), tx, gameCode, source.Sources); err != nil {
+		middleware.Logger(r.Context()).Error("failed to link game sources", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create game")
+		return
+	}
+	if err := saveSetlist(r.Context(), tx, gameCode, setlist); err != nil {
+		middleware.Logger(r.Context()).Error("failed to save setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create game")
+		return
+	}
+
+	prizesJSON, _ := models.PrizesToJSON(settings.Prizes)
+	_, err = tx.Exec(`INSERT INTO rounds (game_code, number, prize_tier, prizes, status, started_at) VALUES (?, 1, ?, ?, ?, ?)`,
+		gameCode, settings.PrizeTier, prizesJSON, models.RoundActive, game.CreatedAt)
 	if err != nil {
-		log.Printf("Error creating game in database: %v", err)
-		http.Error(w, "Failed to create game", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to insert round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create game")
 		return
 	}
 
-	// Generate plates for all players (reuse totalPlates from validation above)
-	var plateFields []models.PlateFields
-	plateFields, err = h.generator.GeneratePlates(playlistData, totalPlates, req.ContentType)
+	// The first player is the creator; the other seats hold placeholders
+	// until someone joins.
+	seats := []string{creatorID}
+	for playerNum := 2; playerNum <= settings.PlayerCount; playerNum++ {
+		seats = append(seats, fmt.Sprintf("PLAYER_%d", playerNum))
+	}
+	plates, err := h.dealPlates(r.Context(), tx, gameCode, 1, seats, deal{
+		tracks: playable,
+		options: generator.Options{
+			Recipe:     recipe,
+			Difficulty: settings.Difficulty,
+			PlayOrder:  settings.PlayOrder,
+		},
+		platesPerPlayer: settings.PlatesPerPlayer,
+	})
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		http.Error(w, "Failed to generate plates", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to deal plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to generate plates")
 		return
 	}
+	creatorPlates := plates[:settings.PlatesPerPlayer]
 
-	var creatorPlates []models.Plate
-	plateNumber := 1
+	metrics.GamesCreated.Inc(settings.ContentType)
 
-	for playerNum := 1; playerNum <= req.PlayerCount; playerNum++ {
-		for plateInSet := 1; plateInSet <= req.PlatesPerPlayer; plateInSet++ {
-			fields := plateFields[plateNumber-1]
-			fieldsJSON, _ := fields.ToJSON()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CreateGameResponse{
+		GameCode: gameCode,
+		Plates:   creatorPlates,
+	})
+}
 
-			var userSessionID string
-			if playerNum == 1 {
-				// First player is the creator
-				userSessionID = session.SessionID
-			} else {
-				// Use placeholder for unassigned players
-				userSessionID = fmt.Sprintf("PLAYER_%d", playerNum)
-			}
+// deal is what a game's plates are generated from.
+type deal struct {
+	// tracks are the tracks of the setlist that weren't excluded.
+	tracks          models.PlaylistData
+	options         generator.Options
+	platesPerPlayer int
+	// partial deals add seats to a game. They keep the fairness report of
+	// the plates already in play, which they were not balanced against.
+	partial bool
+}
 
-			plate := models.Plate{
-				GameCode:      gameCode,
-				UserSessionID: userSessionID,
-				PlateNumber:   plateInSet,
-				Fields:        fields,
+// dealPlates generates plates for every seat in a round and stores them in
+// tx, along with how fairly they are expected to finish. The plates are
+// returned seat by seat.
+func (h *GameHandler) dealPlates(ctx context.Context, tx *sql.Tx, gameCode string, round int, seats []string, d deal) ([]models.Plate, error) {
+	generateStart := time.Now()
+	generated, fairness, err := h.generator.GeneratePlates(h.cleanNames(d.tracks), len(seats)*d.platesPerPlayer, d.options)
+	metrics.PlateGenerationDuration.ObserveSince(generateStart)
+	if err != nil {
+		return nil, err
+	}
+	names, err := h.nameOverrides(ctx, gameCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load display names: %w", err)
+	}
+	if len(names) > 0 {
+		tracks := make(map[string]models.Track, len(d.tracks.Tracks))
+		for _, t := range d.tracks.Tracks {
+			tracks[t.ID] = t
+		}
+		for i := range generated {
+			renameFields(&generated[i].Fields, tracks, names)
+		}
+	}
+	middleware.Logger(ctx).Info("balanced plates", "round", round, "play_order", fairness.PlayOrder,
+		"spread_before", fairness.Before.Max(), "spread_after", fairness.After.Max())
+	if !d.partial {
+		fairnessJSON, _ := fairness.ToJSON()
+		if _, err := tx.ExecContext(ctx, `UPDATE games SET fairness = ? WHERE game_code = ?`, fairnessJSON, gameCode); err != nil {
+			return nil, fmt.Errorf("failed to save fairness report: %w", err)
+		}
+	}
+
+	// Scores are meaningless without popularity, as for imported tracks.
+	rated := hasPopularity(d.tracks.Tracks)
+
+	var plates []models.Plate
+	for i, seat := range seats {
+		for n := 1; n <= d.platesPerPlayer; n++ {
+			g := generated[i*d.platesPerPlayer+n-1]
+			fieldsJSON, _ := g.Fields.ToJSON()
+			var difficulty any
+			if rated {
+				difficulty = g.Difficulty
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO plates (game_code, user_session_id, round, plate_number, fields, difficulty) VALUES (?, ?, ?, ?, ?, ?)`,
+				gameCode, seat, round, n, fieldsJSON, difficulty)
+			if err != nil {
+				return nil, fmt.Errorf("failed to insert plate: %w", err)
 			}
+			plates = append(plates, models.Plate{
+				GameCode:      gameCode,
+				UserSessionID: seat,
+				Round:         round,
+				PlateNumber:   n,
+				Fields:        g.Fields,
+			})
+		}
+	}
+	return plates, nil
+}
+
+// checkTrackIDs writes an error response and returns false if any of ids
+// isn't a track of the playlist.
+func checkTrackIDs(w http.ResponseWriter, r *http.Request, data models.PlaylistData, field string, ids []string) bool {
+	for _, id := range ids {
+		if _, ok := findTrack(data, id); !ok {
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Track "+id+" isn't in the playlist",
+				map[string]any{"field": field, "track_id": id})
+			return false
+		}
+	}
+	return true
+}
 
-			_, err = h.db.Exec(`INSERT INTO plates (game_code, user_session_id, plate_number, fields) VALUES (?, ?, ?, ?)`,
-				plate.GameCode, plate.UserSessionID, plate.PlateNumber, fieldsJSON)
+func hasPopularity(tracks []models.Track) bool {
+	for _, t := range tracks {
+		if t.Popularity > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCapacityError explains why the tracks can't fill the plates.
+func writeCapacityError(w http.ResponseWriter, r *http.Request, err error, playerCount, platesPerPlayer int) {
+	totalPlates := playerCount * platesPerPlayer
+	var capErr *generator.CapacityError
+	if !errors.As(err, &capErr) {
+		middleware.Logger(r.Context()).Error("failed to check capacity", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create game")
+		return
+	}
+	if capErr.Kind == generator.KindSong {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodePlaylistTooSmall,
+			fmt.Sprintf("Playlist must have at least %d different songs for %d players (%d plates total)", capErr.Required, playerCount, totalPlates),
+			map[string]any{
+				"required_tracks":  capErr.Required,
+				"available_tracks": capErr.Available,
+				"player_count":     playerCount,
+				"total_plates":     totalPlates,
+			})
+		return
+	}
+	writeErrorDetails(w, r, http.StatusBadRequest, ErrCodePlaylistTooSmall,
+		fmt.Sprintf("The tracks have %d different %s values, the content recipe needs %d", capErr.Available, capErr.Kind, capErr.Required),
+		map[string]any{
+			"field_kind": capErr.Kind,
+			"required":   capErr.Required,
+			"available":  capErr.Available,
+		})
+}
+
+// requestSources turns the playlist fields and sources of a create request
+// into source descriptors, resolving links along the way. It writes the
+// error response itself and returns false if the request is invalid.
+func (h *GameHandler) requestSources(w http.ResponseWriter, r *http.Request, client *spotify.Client, req CreateGameRequest) ([]models.Source, bool) {
+	requested := req.Sources
+	if req.PlaylistURL != "" {
+		requested = append([]SourceRequest{{URL: req.PlaylistURL}}, requested...)
+	} else if req.PlaylistID != "" {
+		requested = append([]SourceRequest{{Type: models.SourceTypePlaylist, ID: req.PlaylistID}}, requested...)
+	}
+
+	if len(requested) == 0 {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidPlaylist, "Invalid playlist")
+		return nil, false
+	}
+	if len(requested) > maxSources {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("A game can combine at most %d sources", maxSources),
+			map[string]any{"field": "sources", "max": maxSources})
+		return nil, false
+	}
+
+	var sources []models.Source
+	for _, sr := range requested {
+		src := models.Source{Type: sr.Type, ID: sr.ID}
+
+		if sr.URL != "" {
+			// Accepts web links, spotify: URIs and spotify.link short links.
+			resource, err := client.ResolveShortLink(r.Context(), sr.URL)
 			if err != nil {
-				http.Error(w, "Failed to save plates", http.StatusInternalServerError)
-				return
+				middleware.Logger(r.Context()).Info("unparseable source link", "link", sr.URL, "error", err)
+				writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidPlaylist, "Not a valid Spotify link",
+					map[string]any{"link": sr.URL})
+				return nil, false
+			}
+			src.ID = resource.ID
+			switch resource.Type {
+			case spotify.ResourcePlaylist:
+				src.Type = models.SourceTypePlaylist
+			case spotify.ResourceAlbum:
+				src.Type = models.SourceTypeAlbum
+			case spotify.ResourceArtist:
+				// Artist links default to the whole discography, unless
+				// the caller asked for top tracks only.
+				if sr.Type != models.SourceTypeArtistTopTracks {
+					src.Type = models.SourceTypeArtist
+				}
+			default:
+				writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidPlaylist, "Only playlist, album and artist links can be used to create a game",
+					map[string]any{"link": sr.URL, "type": resource.Type})
+				return nil, false
 			}
+		}
 
-			// Only add creator's plates to the response
-			if playerNum == 1 {
-				creatorPlates = append(creatorPlates, plate)
+		switch src.Type {
+		case models.SourceTypeSavedTracks:
+			src.ID = ""
+		case models.SourceTypePlaylist, models.SourceTypeAlbum, models.SourceTypeArtist, models.SourceTypeArtistTopTracks:
+			if src.ID == "" {
+				writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidPlaylist, "Source is missing an ID",
+					map[string]any{"type": src.Type})
+				return nil, false
 			}
-			plateNumber++
+		default:
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Unknown source type",
+				map[string]any{"field": "sources", "type": src.Type})
+			return nil, false
 		}
+
+		sources = append(sources, src)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CreateGameResponse{
-		GameCode: gameCode,
-		Plates:   creatorPlates,
-	})
+	return sources, true
 }
 
 type JoinGameResponse struct {
-	GameCode     string         `json:"game_code"`
-	PlaylistName string         `json:"playlist_name"`
-	Plates       []models.Plate `json:"plates"`
+	GameCode     string `json:"game_code"`
+	PlaylistName string `json:"playlist_name"`
+	// Team is set when the plates belong to the player's team.
+	Team   string         `json:"team,omitempty"`
+	Plates []models.Plate `json:"plates"`
 }
 
 func (h *GameHandler) JoinGame(w http.ResponseWriter, r *http.Request) {
 	gameCode := r.URL.Query().Get("code")
 	if gameCode == "" {
-		http.Error(w, "Game code required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Game code required")
 		return
 	}
+	middleware.SetGameCode(r.Context(), gameCode)
 
-	sessionCookie, err := r.Cookie("session_id")
-	if err != nil {
-		sessionID := generateSessionID()
-		session := models.UserSession{
-			SessionID: sessionID,
-			CreatedAt: time.Now(),
-			ExpiresAt: time.Now().Add(24 * time.Hour),
-		}
-
-		_, err = h.db.Exec(`INSERT INTO user_sessions (session_id, created_at, expires_at) VALUES (?, ?, ?)`,
-			session.SessionID, session.CreatedAt, session.ExpiresAt)
-		if err != nil {
-			http.Error(w, "Failed to create session", http.StatusInternalServerError)
-			return
-		}
-
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_id",
-			Value:    sessionID,
-			Expires:  session.ExpiresAt,
-			HttpOnly: true,
-			Path:     "/",
-		})
-		sessionCookie = &http.Cookie{Value: sessionID}
+	sessionID, ok := ensureSession(w, r, h.db)
+	if !ok {
+		return
 	}
 
-	var existingPlates []models.Plate
-	rows, err := h.db.Query(`SELECT id, game_code, user_session_id, plate_number, fields FROM plates WHERE game_code = ? AND user_session_id = ?`,
-		gameCode, sessionCookie.Value)
-	if err == nil {
-		for rows.Next() {
-			var plate models.Plate
-			var fieldsJSON string
-			err := rows.Scan(&plate.ID, &plate.GameCode, &plate.UserSessionID, &plate.PlateNumber, &fieldsJSON)
-			if err == nil {
-				plate.Fields, _ = models.PlateFieldsFromJSON(fieldsJSON)
-				existingPlates = append(existingPlates, plate)
-			}
-		}
-		rows.Close()
+	// Team members play their team's plates.
+	owner, err := h.plateOwner(r.Context(), gameCode, sessionID)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch plates")
+		return
 	}
+	existingPlates, _ := h.ownedPlates(r.Context(), gameCode, owner)
 
 	if len(existingPlates) > 0 {
 		var game models.Game
@@ -258,16 +576,18 @@ func (h *GameHandler) JoinGame(w http.ResponseWriter, r *http.Request) {
 		err = h.db.QueryRow(`SELECT game_code, playlist_data FROM games WHERE game_code = ?`, gameCode).
 			Scan(&game.GameCode, &playlistJSON)
 		if err != nil {
-			http.Error(w, "Game not found", http.StatusNotFound)
+			writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
 			return
 		}
 
 		playlistData, _ := models.PlaylistDataFromJSON(playlistJSON)
+		teams, _ := h.teamNames(r.Context(), gameCode)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(JoinGameResponse{
 			GameCode:     gameCode,
 			PlaylistName: playlistData.PlaylistName,
+			Team:         teams[owner],
 			Plates:       existingPlates,
 		})
 		return
@@ -280,31 +600,68 @@ func (h *GameHandler) JoinGame(w http.ResponseWriter, r *http.Request) {
 	err = h.db.QueryRow(`SELECT game_code, content_type, plates_per_player, playlist_data FROM games WHERE game_code = ?`, gameCode).
 		Scan(&game.GameCode, &contentType, &platesPerPlayer, &playlistJSON)
 	if err != nil {
-		http.Error(w, "Game not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
 		return
 	}
 
 	playlistData, err := models.PlaylistDataFromJSON(playlistJSON)
 	if err != nil {
-		http.Error(w, "Invalid game data", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to decode playlist data", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid game data")
 		return
 	}
 
-	// Find the first available unassigned player slot
-	var assignedPlates []models.Plate
-	rows, err = h.db.Query(`SELECT user_session_id, plate_number, fields FROM plates WHERE game_code = ? AND user_session_id LIKE 'PLAYER_%' ORDER BY user_session_id LIMIT ?`, gameCode, platesPerPlayer)
+	tx, err := h.db.BeginTx(r.Context(), nil)
 	if err != nil {
-		http.Error(w, "Failed to find available plates", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to assign plates")
 		return
 	}
+	defer tx.Rollback()
+	assignedPlates, err := takeSeat(r.Context(), tx, gameCode, sessionID, platesPerPlayer)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if errors.Is(err, errGameFull) {
+		middleware.Logger(r.Context()).Info("join rejected, game is full")
+		metrics.GameFullRejections.Inc()
+		writeError(w, r, http.StatusConflict, ErrCodeGameFull, "Game is full - no available player slots")
+		return
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to assign plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to assign plates")
+		return
+	}
+
+	metrics.GameJoins.Inc()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(JoinGameResponse{
+		GameCode:     gameCode,
+		PlaylistName: playlistData.PlaylistName,
+		Plates:       assignedPlates,
+	})
+}
+
+var errGameFull = errors.New("game is full")
+
+// takeSeat gives owner the plates of the first open player slot, along
+// with that slot's plates from earlier rounds, and returns the plates in
+// play. The caller commits tx.
+func takeSeat(ctx context.Context, tx *sql.Tx, gameCode, owner string, platesPerPlayer int) ([]models.Plate, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT user_session_id, round, plate_number, fields FROM plates WHERE game_code = ? AND user_session_id LIKE 'PLAYER_%' AND `+currentPlates+` ORDER BY user_session_id LIMIT ?`, gameCode, platesPerPlayer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query open plates: %w", err)
+	}
 	defer rows.Close()
 
 	var platesToAssign []models.Plate
 	for rows.Next() {
 		var userID string
-		var plateNumber int
+		var round, plateNumber int
 		var fieldsJSON string
-		err := rows.Scan(&userID, &plateNumber, &fieldsJSON)
+		err := rows.Scan(&userID, &round, &plateNumber, &fieldsJSON)
 		if err != nil {
 			continue
 		}
@@ -317,6 +674,7 @@ func (h *GameHandler) JoinGame(w http.ResponseWriter, r *http.Request) {
 		plate := models.Plate{
 			GameCode:      gameCode,
 			UserSessionID: userID, // Keep original placeholder for now
+			Round:         round,
 			PlateNumber:   plateNumber,
 			Fields:        fields,
 		}
@@ -326,53 +684,33 @@ func (h *GameHandler) JoinGame(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	// SQLite won't let the plates be reassigned while they are being read.
+	rows.Close()
 
 	if len(platesToAssign) < platesPerPlayer {
-		http.Error(w, "Game is full - no available player slots", http.StatusBadRequest)
-		return
+		return nil, errGameFull
 	}
 
-	// Assign the plates to the joining player
-	for i, plate := range platesToAssign {
-		_, err = h.db.Exec(`UPDATE plates SET user_session_id = ? WHERE game_code = ? AND user_session_id = ? AND plate_number = ?`,
-			sessionCookie.Value, gameCode, plate.UserSessionID, plate.PlateNumber)
-		if err != nil {
-			http.Error(w, "Failed to assign plates", http.StatusInternalServerError)
-			return
+	// The seat's plates of earlier rounds and the prizes they won come
+	// along, as when the host transfers a seat.
+	moved := make(map[string]bool)
+	for _, plate := range platesToAssign {
+		if moved[plate.UserSessionID] {
+			continue
 		}
-
-		// Update the plate for response
-		plate.UserSessionID = sessionCookie.Value
-		plate.PlateNumber = i + 1 // Renumber for this player (1, 2, 3, etc.)
-		assignedPlates = append(assignedPlates, plate)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(JoinGameResponse{
-		GameCode:     gameCode,
-		PlaylistName: playlistData.PlaylistName,
-		Plates:       assignedPlates,
-	})
-}
-
-func extractPlaylistIDFromURL(url string) string {
-	if len(url) > 31 && url[:31] == "https://open.spotify.com/playlist/" {
-		end := len(url)
-		if qIndex := findChar(url, '?'); qIndex != -1 {
-			end = qIndex
+		if err := reassignPlates(tx, gameCode, plate.UserSessionID, owner); err != nil {
+			return nil, fmt.Errorf("failed to assign %s: %w", plate.UserSessionID, err)
 		}
-		return url[34:end]
+		moved[plate.UserSessionID] = true
 	}
-	return ""
-}
 
-func findChar(s string, char rune) int {
-	for i, c := range s {
-		if c == char {
-			return i
-		}
+	var assignedPlates []models.Plate
+	for i, plate := range platesToAssign {
+		plate.UserSessionID = owner
+		plate.PlateNumber = i + 1 // Renumber for this player (1, 2, 3, etc.)
+		assignedPlates = append(assignedPlates, plate)
 	}
-	return -1
+	return assignedPlates, nil
 }
 
 type AllPlatesResponse struct {
@@ -390,13 +728,14 @@ type PlayerPlates struct {
 func (h *GameHandler) GetAllPlates(w http.ResponseWriter, r *http.Request) {
 	gameCode := r.URL.Query().Get("code")
 	if gameCode == "" {
-		http.Error(w, "Game code required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Game code required")
 		return
 	}
+	middleware.SetGameCode(r.Context(), gameCode)
 
 	sessionCookie, err := r.Cookie("session_id")
 	if err != nil {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Not authenticated")
 		return
 	}
 
@@ -407,25 +746,28 @@ func (h *GameHandler) GetAllPlates(w http.ResponseWriter, r *http.Request) {
 	err = h.db.QueryRow(`SELECT game_code, creator_session_id, playlist_data FROM games WHERE game_code = ?`, gameCode).
 		Scan(&game.GameCode, &creatorID, &playlistJSON)
 	if err != nil {
-		http.Error(w, "Game not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
 		return
 	}
 
 	if creatorID != sessionCookie.Value {
-		http.Error(w, "Only game creator can view all plates", http.StatusForbidden)
+		middleware.Logger(r.Context()).Warn("non-creator requested all plates")
+		writeError(w, r, http.StatusForbidden, ErrCodeForbidden, "Only game creator can view all plates")
 		return
 	}
 
 	playlistData, err := models.PlaylistDataFromJSON(playlistJSON)
 	if err != nil {
-		http.Error(w, "Invalid game data", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to decode playlist data", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid game data")
 		return
 	}
 
 	// Get all plates for this game
-	rows, err := h.db.Query(`SELECT user_session_id, plate_number, fields FROM plates WHERE game_code = ? ORDER BY user_session_id, plate_number`, gameCode)
+	rows, err := h.db.Query(`SELECT user_session_id, round, plate_number, fields FROM plates WHERE game_code = ? AND `+currentPlates+` ORDER BY user_session_id, plate_number`, gameCode)
 	if err != nil {
-		http.Error(w, "Failed to fetch plates", http.StatusInternalServerError)
+		middleware.Logger(r.Context()).Error("failed to query plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch plates")
 		return
 	}
 	defer rows.Close()
@@ -433,9 +775,9 @@ func (h *GameHandler) GetAllPlates(w http.ResponseWriter, r *http.Request) {
 	playerPlatesMap := make(map[string][]models.Plate)
 	for rows.Next() {
 		var userID string
-		var plateNumber int
+		var round, plateNumber int
 		var fieldsJSON string
-		err := rows.Scan(&userID, &plateNumber, &fieldsJSON)
+		err := rows.Scan(&userID, &round, &plateNumber, &fieldsJSON)
 		if err != nil {
 			continue
 		}
@@ -448,6 +790,7 @@ func (h *GameHandler) GetAllPlates(w http.ResponseWriter, r *http.Request) {
 		plate := models.Plate{
 			GameCode:      gameCode,
 			UserSessionID: userID,
+			Round:         round,
 			PlateNumber:   plateNumber,
 			Fields:        fields,
 		}
@@ -455,6 +798,13 @@ func (h *GameHandler) GetAllPlates(w http.ResponseWriter, r *http.Request) {
 		playerPlatesMap[userID] = append(playerPlatesMap[userID], plate)
 	}
 
+	teams, err := h.teamNames(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load teams", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch plates")
+		return
+	}
+
 	// Convert to response format
 	var allPlates []PlayerPlates
 	for userID, plates := range playerPlatesMap {
@@ -462,6 +812,8 @@ func (h *GameHandler) GetAllPlates(w http.ResponseWriter, r *http.Request) {
 		var playerName string
 		if userID == creatorID {
 			playerName = "Game Creator"
+		} else if name, ok := teams[userID]; ok {
+			playerName = fmt.Sprintf("Team %s", name)
 		} else if strings.HasPrefix(userID, "PLAYER_") {
 			// Extract player number from placeholder
 			playerNum := strings.TrimPrefix(userID, "PLAYER_")
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func createGame(t *testing.T, h *GameHandler, sessionID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.CreateGame, http.MethodPost, "/api/games", sessionID, body)
+}
+
+func TestCreateGamePaginatesPlaylist(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.PageSize = 7
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(30)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+	rec := createGame(t, h, env.loggedInSession(t), `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var resp CreateGameResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GameCode) != 6 {
+		t.Errorf("game code = %q, want 6 digits", resp.GameCode)
+	}
+	if len(resp.Plates) != 1 {
+		t.Fatalf("creator plates = %d, want 1", len(resp.Plates))
+	}
+	if got := env.spotify.Requests(spotifytest.RoutePlaylistTracks); got != 5 {
+		t.Errorf("track page requests = %d, want 5", got)
+	}
+
+	stored, err := env.db.GameTracks(context.Background(), resp.GameCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.PlaylistName != "Party" || len(stored.Tracks) != 30 || stored.Tracks[29].Name != "Track 30" {
+		t.Errorf("stored playlist missing name or last page: %+v", stored)
+	}
+}
+
+func TestCreateGameReusesPlaylistSnapshot(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(30)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	session := env.loggedInSession(t)
+	body := `{"playlist_id":"pl1","player_count":2,"plates_per_player":1}`
+
+	var codes []string
+	for range 3 {
+		rec := createGame(t, h, session, body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var resp CreateGameResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		codes = append(codes, resp.GameCode)
+	}
+	if got := env.spotify.Requests(spotifytest.RoutePlaylistTracks); got != 1 {
+		t.Errorf("track requests for three games = %d, want 1", got)
+	}
+
+	var sources, tracks int
+	env.db.QueryRow(`SELECT COUNT(*) FROM sources`).Scan(&sources)
+	env.db.QueryRow(`SELECT COUNT(*) FROM source_tracks`).Scan(&tracks)
+	if sources != 1 || tracks != 30 {
+		t.Errorf("stored sources = %d, tracks = %d, want 1 and 30", sources, tracks)
+	}
+	for _, code := range codes {
+		data, err := env.db.GameTracks(context.Background(), code)
+		if err != nil || len(data.Tracks) != 30 {
+			t.Errorf("game %s tracks = %d, %v", code, len(data.Tracks), err)
+		}
+	}
+
+	// Editing the playlist changes its snapshot, so the next game refetches
+	// while existing games keep the tracks they were built from.
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(35)})
+	rec := createGame(t, h, session, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if got := env.spotify.Requests(spotifytest.RoutePlaylistTracks); got != 2 {
+		t.Errorf("track requests after edit = %d, want 2", got)
+	}
+	if data, _ := env.db.GameTracks(context.Background(), resp.GameCode); len(data.Tracks) != 35 {
+		t.Errorf("new game tracks = %d, want 35", len(data.Tracks))
+	}
+	if data, _ := env.db.GameTracks(context.Background(), codes[0]); len(data.Tracks) != 30 {
+		t.Errorf("old game tracks = %d, want 30", len(data.Tracks))
+	}
+}
+
+func TestCreateGameRetriesSpotifyErrors(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(30)})
+	env.spotify.Fail(spotifytest.RoutePlaylistTracks, spotifytest.Fault{Status: http.StatusTooManyRequests, RetryAfter: "0"})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+	rec := createGame(t, h, env.loggedInSession(t), `{"playlist_id":"pl1","player_count":2,"plates_per_player":1}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if got := env.spotify.Requests(spotifytest.RoutePlaylistTracks); got != 2 {
+		t.Errorf("track requests = %d, want 2 (one retry)", got)
+	}
+}
+
+func TestCreateGameErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		faults []spotifytest.Fault
+		status int
+		code   ErrorCode
+	}{
+		{
+			name:   "playlist too small",
+			body:   `{"playlist_id":"small","player_count":2,"plates_per_player":3}`,
+			status: http.StatusBadRequest,
+			code:   ErrCodePlaylistTooSmall,
+		},
+		{
+			name:   "unknown playlist",
+			body:   `{"playlist_id":"missing","player_count":1}`,
+			status: http.StatusNotFound,
+			code:   ErrCodeInvalidPlaylist,
+		},
+		{
+			name:   "token rejected",
+			body:   `{"playlist_id":"small","player_count":1}`,
+			faults: []spotifytest.Fault{{Status: http.StatusUnauthorized}},
+			status: http.StatusUnauthorized,
+			code:   ErrCodeSessionExpired,
+		},
+		{
+			name:   "bad player count",
+			body:   `{"playlist_id":"small","player_count":0}`,
+			status: http.StatusBadRequest,
+			code:   ErrCodeInvalidRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.spotify.AddPlaylist(spotifytest.Playlist{ID: "small", Name: "Small", Tracks: spotifytest.Tracks(20)})
+			env.spotify.Fail(spotifytest.RoutePlaylist, tt.faults...)
+			h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+			rec := createGame(t, h, env.loggedInSession(t), tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body = %s", rec.Code, tt.status, rec.Body)
+			}
+			if resp := decodeError(t, rec); resp.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", resp.Error.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestCreateGameFromLink(t *testing.T) {
+	const id = "37i9dQZF1DXcBWIGoYBM5M"
+
+	tests := []struct {
+		link   string
+		status int
+	}{
+		{"https://open.spotify.com/intl-de/playlist/" + id + "?si=abc", http.StatusOK},
+		{"spotify:playlist:" + id, http.StatusOK},
+		{"https://open.spotify.com/track/" + id, http.StatusBadRequest},
+		{"https://example.com/playlist/" + id, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		env := newTestEnv(t)
+		env.spotify.AddPlaylist(spotifytest.Playlist{ID: id, Name: "Linked", Tracks: spotifytest.Tracks(30)})
+		h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+		body := `{"playlist_url":"` + tt.link + `","player_count":1,"plates_per_player":1}`
+		rec := createGame(t, h, env.loggedInSession(t), body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.link, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestCreateGameFromMergedSources(t *testing.T) {
+	const (
+		albumID  = "1DFixLWuPkv3KT3TnV35m3"
+		artistID = "0OdUWJ0sBjDrqHygGUXeCF"
+	)
+
+	env := newTestEnv(t)
+	env.spotify.PageSize = 4
+	env.spotify.AddAlbum(spotifytest.Album{ID: albumID, Name: "Like a Prayer", Tracks: spotifytest.NamedTracks("Madonna", 10)})
+	env.spotify.AddAlbum(spotifytest.Album{ID: "purple", Name: "Purple Rain", Tracks: spotifytest.NamedTracks("Prince", 9)})
+	env.spotify.AddAlbum(spotifytest.Album{ID: "sotimes", Name: "Sign o' the Times", Tracks: spotifytest.NamedTracks("Times", 6)})
+	env.spotify.AddArtist(spotifytest.Artist{ID: artistID, Name: "Prince", AlbumIDs: []string{"purple", "sotimes"}})
+	// The first liked song is also on the album and must only count once.
+	env.spotify.SetSavedTracks(append(spotifytest.NamedTracks("Madonna", 1), spotifytest.NamedTracks("Liked", 5)...))
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+	body := `{
+		"sources": [
+			{"url": "https://open.spotify.com/album/` + albumID + `"},
+			{"url": "spotify:artist:` + artistID + `"},
+			{"type": "saved_tracks"}
+		],
+		"player_count": 2,
+		"plates_per_player": 1,
+		"content_type": "tracks"
+	}`
+	rec := createGame(t, h, env.loggedInSession(t), body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var resp CreateGameResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	var sourceJSON string
+	if err := env.db.QueryRow(`SELECT source FROM games WHERE game_code = ?`, resp.GameCode).Scan(&sourceJSON); err != nil {
+		t.Fatal(err)
+	}
+	data, err := env.db.GameTracks(context.Background(), resp.GameCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(data.Tracks), 10+9+6+5; got != want {
+		t.Errorf("merged tracks = %d, want %d", got, want)
+	}
+	if want := "Like a Prayer + Prince + Liked Songs"; data.PlaylistName != want {
+		t.Errorf("name = %q, want %q", data.PlaylistName, want)
+	}
+
+	source, _ := models.SourceDescriptorFromJSON(sourceJSON)
+	wantTypes := []string{models.SourceTypeAlbum, models.SourceTypeArtist, models.SourceTypeSavedTracks}
+	if len(source.Sources) != len(wantTypes) {
+		t.Fatalf("sources = %+v", source.Sources)
+	}
+	for i, src := range source.Sources {
+		if src.Type != wantTypes[i] {
+			t.Errorf("source %d type = %q, want %q", i, src.Type, wantTypes[i])
+		}
+	}
+}
+
+func TestCreateGameWithContentRecipe(t *testing.T) {
+	env := newTestEnv(t)
+	dates := []string{"1987", "1994", "2013"}
+	tracks := spotifytest.Tracks(40)
+	for i := range tracks {
+		tracks[i].Album = &spotify.Album{ID: "album", Name: "Album", ReleaseDate: dates[i%len(dates)]}
+	}
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: tracks})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	session := env.loggedInSession(t)
+
+	body := `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,
+		"content_recipe":{"weights":{"track":7,"artist":3},"minimums":{"year":2}}}`
+	rec := createGame(t, h, session, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	counts := make(map[string]int)
+	for _, row := range resp.Plates[0].Fields.Grid {
+		for _, f := range row {
+			if f.Content != "" {
+				counts[f.Type]++
+			}
+		}
+	}
+	if counts["year"] != 2 || counts["track"]+counts["artist"] != 13 {
+		t.Errorf("field kinds = %v, want 2 years and 13 tracks or artists", counts)
+	}
+
+	var contentType, recipeJSON string
+	env.db.QueryRow(`SELECT content_type, content_recipe FROM games WHERE game_code = ?`, resp.GameCode).Scan(&contentType, &recipeJSON)
+	recipe, err := models.ContentRecipeFromJSON(recipeJSON)
+	if err != nil || contentType != models.ContentTypeCustom || recipe.Weights["track"] != 7 || recipe.Minimums["year"] != 2 {
+		t.Errorf("stored content type %q, recipe %q", contentType, recipeJSON)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code ErrorCode
+	}{
+		{"unknown kind", `{"weights":{"mood":1}}`, ErrCodeInvalidRequest},
+		{"no weights", `{"weights":{}}`, ErrCodeInvalidRequest},
+		{"minimums over plate size", `{"weights":{"track":1},"minimums":{"year":16}}`, ErrCodeInvalidRequest},
+		{"too few distinct values", `{"weights":{"track":1},"minimums":{"year":4}}`, ErrCodePlaylistTooSmall},
+		{"kind without values", `{"weights":{"track":1,"genre":1}}`, ErrCodePlaylistTooSmall},
+	}
+	for _, tt := range tests {
+		rec := createGame(t, h, session, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_recipe":`+tt.body+`}`)
+		if rec.Code != http.StatusBadRequest || decodeError(t, rec).Error.Code != tt.code {
+			t.Errorf("%s: status = %d, body = %s, want 400 %s", tt.name, rec.Code, rec.Body, tt.code)
+		}
+	}
+}
+
+func TestHostKeepsGameAfterTokenExpires(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	// An hour on, the Spotify token has run out and expired sessions have
+	// been cleaned up.
+	if _, err := env.db.Exec(`UPDATE user_sessions SET token_expires_at = ? WHERE session_id = ?`, time.Now().Add(-time.Minute), creator); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.db.DeleteExpiredSessions(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serve(t, h.JoinGame, http.MethodGet, "/api/games/join?code="+game.GameCode, creator, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rejoin status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("rejoin set cookies %v, want the host's session kept", cookies)
+	}
+	var joined JoinGameResponse
+	json.NewDecoder(rec.Body).Decode(&joined)
+	if len(joined.Plates) != 1 || joined.Plates[0].Fields != game.Plates[0].Fields {
+		t.Errorf("host plates after rejoin = %+v", joined.Plates)
+	}
+	if rec := seatRequest(t, h.ListSeats, creator, game.GameCode, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("host list seats status = %d, want 200", rec.Code)
+	}
+	if rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("create with an expired token status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/config"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/database"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+)
+
+type testEnv struct {
+	db      *database.DB
+	cfg     *config.Config
+	spotify *spotifytest.Server
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "bingo.db"))
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	fake := spotifytest.NewServer(t)
+	cfg := &config.Config{
+		SpotifyID:          spotifytest.ClientID,
+		SpotifySecret:      spotifytest.ClientSecret,
+		SpotifyAPIURL:      fake.URL,
+		SpotifyAccountsURL: fake.URL,
+		BaseURL:            "http://bingo.test",
+	}
+	return &testEnv{db: db, cfg: cfg, spotify: fake}
+}
+
+// loggedInSession inserts a session holding a valid token for the fake.
+func (e *testEnv) loggedInSession(t *testing.T) string {
+	t.Helper()
+	sessionID := generateSessionID()
+	_, err := e.db.Exec(`INSERT INTO user_sessions (session_id, spotify_token, token_expires_at, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		sessionID, spotifytest.AccessToken, time.Now().Add(time.Hour), time.Now(), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("insert session: %v", err)
+	}
+	return sessionID
+}
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	return resp
+}
+
+func sessionCookie(sessionID string) *http.Cookie {
+	return &http.Cookie{Name: "session_id", Value: sessionID}
+}
+
+// serve calls handler with a request the way the mux would route it.
+// pathValues alternate names and values, such as "code", gameCode. An
+// empty sessionID sends no cookie and an empty body no body.
+func serve(t *testing.T, handler http.HandlerFunc, method, target, sessionID, body string, pathValues ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		req.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	if sessionID != "" {
+		req.AddCookie(sessionCookie(sessionID))
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
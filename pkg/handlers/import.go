@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/importer"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+const maxImportSize = 2 << 20
+
+// ImportGame creates a game from an uploaded track list instead of a
+// Spotify source. The multipart form carries the file in "file" along with
+// player_count, plates_per_player and content_type, or a content_recipe as
+// JSON. An optional "format" field (csv, m3u or json) overrides detection
+// from the file name.
+func (h *GameHandler) ImportGame(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+64<<10)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, ErrCodeInvalidRequest, "Upload must be 2 MB or smaller")
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid upload")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "A track list file is required",
+			map[string]any{"field": "file"})
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Failed to read upload")
+		return
+	}
+
+	playerCount, _ := strconv.Atoi(r.FormValue("player_count"))
+	platesPerPlayer, _ := strconv.Atoi(r.FormValue("plates_per_player"))
+	settings := gameSettings{
+		PlayerCount:     playerCount,
+		PlatesPerPlayer: platesPerPlayer,
+		ContentType:     r.FormValue("content_type"),
+	}
+	if v := r.FormValue("content_recipe"); v != "" {
+		recipe, err := models.ContentRecipeFromJSON(v)
+		if err != nil {
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid content recipe",
+				map[string]any{"field": "content_recipe", "reason": err.Error()})
+			return
+		}
+		settings.Recipe = &recipe
+	}
+	if !settings.validate(w, r) {
+		return
+	}
+
+	format := importer.Format(r.FormValue("format"))
+	if format == "" {
+		format, err = importer.DetectFormat(header.Filename, content)
+		if err != nil {
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Unrecognised file format, upload CSV, M3U or JSON",
+				map[string]any{"field": "format"})
+			return
+		}
+	}
+
+	playlistData, err := importer.Parse(format, header.Filename, bytes.NewReader(content))
+	if err != nil {
+		middleware.Logger(r.Context()).Warn("failed to parse import", "format", format, "error", err)
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidPlaylist, "Could not read tracks from the uploaded file",
+			map[string]any{"format": format, "reason": err.Error()})
+		return
+	}
+
+	creatorID, ok := ensureSession(w, r, h.db)
+	if !ok {
+		return
+	}
+
+	src := models.Source{
+		Type:       models.SourceTypeImport,
+		ID:         string(format),
+		Name:       playlistData.PlaylistName,
+		SnapshotID: models.ContentSnapshotID(playlistData.Tracks),
+	}
+	if err := h.db.SaveSource(r.Context(), src, playlistData); err != nil {
+		middleware.Logger(r.Context()).Error("failed to save imported tracks", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to save tracks")
+		return
+	}
+	h.createGame(w, r, creatorID, settings, playlistData, models.SourceDescriptor{Sources: []models.Source{src}})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func importGame(t *testing.T, h *GameHandler, filename, content string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/games/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ImportGame(rec, req)
+	return rec
+}
+
+func TestImportGameFromCSV(t *testing.T) {
+	env := newTestEnv(t)
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+	var csv strings.Builder
+	csv.WriteString("title,artist,year\n")
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&csv, "Song %d,Band %d,19%02d\n", i, i%7, 60+i)
+	}
+
+	rec := importGame(t, h, "quiz night.csv", csv.String(), map[string]string{
+		"player_count": "2", "plates_per_player": "1", "content_type": "tracks",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var resp CreateGameResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Plates) != 1 {
+		t.Fatalf("creator plates = %d, want 1", len(resp.Plates))
+	}
+
+	// An anonymous creator gets a session, which owns the plates.
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != resp.Plates[0].UserSessionID {
+		t.Errorf("session cookie = %v, creator = %q", cookies, resp.Plates[0].UserSessionID)
+	}
+
+	var source string
+	if err := env.db.QueryRow(`SELECT source FROM games WHERE game_code = ?`, resp.GameCode).Scan(&source); err != nil {
+		t.Fatal(err)
+	}
+	playlist, err := env.db.GameTracks(context.Background(), resp.GameCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if playlist.PlaylistName != "quiz night" || len(playlist.Tracks) != 30 || playlist.Tracks[29].Year != 1990 {
+		t.Errorf("stored playlist = %+v", playlist)
+	}
+	if !strings.Contains(source, `"type":"import"`) {
+		t.Errorf("stored source = %s", source)
+	}
+}
+
+func TestImportKeepsOtherGamesTracks(t *testing.T) {
+	env := newTestEnv(t)
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	settings := map[string]string{"player_count": "2", "plates_per_player": "1", "content_type": "tracks"}
+
+	// Both lists have the same titles and artists, and so the same track
+	// IDs, but different years.
+	var codes []string
+	for _, century := range []int{1900, 2000} {
+		var csv strings.Builder
+		csv.WriteString("title,artist,year\n")
+		for i := 1; i <= 30; i++ {
+			fmt.Fprintf(&csv, "Song %d,Band %d,%d\n", i, i%7, century+i)
+		}
+		rec := importGame(t, h, "list.csv", csv.String(), settings)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var resp CreateGameResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		codes = append(codes, resp.GameCode)
+	}
+
+	first, err := env.db.GameTracks(context.Background(), codes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := env.db.GameTracks(context.Background(), codes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Tracks[0].ID != second.Tracks[0].ID {
+		t.Fatalf("track IDs = %q and %q, want the same", first.Tracks[0].ID, second.Tracks[0].ID)
+	}
+	if first.Tracks[0].Year != 1901 || second.Tracks[0].Year != 2001 {
+		t.Errorf("years = %d and %d, want 1901 and 2001", first.Tracks[0].Year, second.Tracks[0].Year)
+	}
+}
+
+func TestImportGameErrors(t *testing.T) {
+	env := newTestEnv(t)
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	settings := map[string]string{"player_count": "2"}
+
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		fields   map[string]string
+		status   int
+		code     ErrorCode
+	}{
+		{"bad player count", "a.csv", "Song,Band\n", map[string]string{"player_count": "0"}, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"unknown format", "notes.txt", "hello", settings, http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"no tracks", "a.m3u", "#EXTM3U\n", settings, http.StatusBadRequest, ErrCodeInvalidPlaylist},
+		{"too few tracks", "a.csv", "Song,Band\n", settings, http.StatusBadRequest, ErrCodePlaylistTooSmall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := importGame(t, h, tt.filename, tt.content, tt.fields)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+			if got := decodeError(t, rec).Error.Code; got != tt.code {
+				t.Errorf("code = %q, want %q", got, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+type MarkRequest struct {
+	Row    int  `json:"row"`
+	Col    int  `json:"col"`
+	Marked bool `json:"marked"`
+}
+
+// MarkEvent is sent to everyone playing a plate when one of its fields is
+// marked or unmarked.
+type MarkEvent struct {
+	PlateNumber int  `json:"plate_number"`
+	Row         int  `json:"row"`
+	Col         int  `json:"col"`
+	Marked      bool `json:"marked"`
+}
+
+// MarkPlate marks or unmarks a field on one of the caller's plates and
+// passes the change on to the other members of their team. Marks are only
+// for the players; claims go by the calls.
+func (h *GameHandler) MarkPlate(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	sessionCookie, err := r.Cookie("session_id")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Not authenticated")
+		return
+	}
+	plateNumber, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Plate number must be a number")
+		return
+	}
+	var req MarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	var grid [3][9]models.BingoField
+	if req.Row < 0 || req.Row >= len(grid) || req.Col < 0 || req.Col >= len(grid[0]) {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Field is outside the plate",
+			map[string]any{"row": req.Row, "col": req.Col})
+		return
+	}
+
+	owner, err := h.plateOwner(r.Context(), gameCode, sessionCookie.Value)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to mark field")
+		return
+	}
+
+	var fieldsJSON string
+	err = h.db.QueryRow(`SELECT fields FROM plates WHERE game_code = ? AND user_session_id = ? AND plate_number = ? AND `+currentPlates,
+		gameCode, owner, plateNumber).Scan(&fieldsJSON)
+	if err != nil {
+		writeErrorDetails(w, r, http.StatusNotFound, ErrCodePlateNotFound, "You have no such plate in this game",
+			map[string]any{"plate_number": plateNumber})
+		return
+	}
+	fields, err := models.PlateFieldsFromJSON(fieldsJSON)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to decode plate", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid plate data")
+		return
+	}
+	if fields.Grid[req.Row][req.Col].Content == "" {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Field is empty",
+			map[string]any{"row": req.Row, "col": req.Col})
+		return
+	}
+
+	// Teammates mark at the same time, so only the one field is written
+	// rather than the whole plate.
+	_, err = h.db.Exec(`UPDATE plates SET fields = json_set(fields, ?, json(?)) WHERE game_code = ? AND user_session_id = ? AND plate_number = ? AND `+currentPlates,
+		fmt.Sprintf("$.grid[%d][%d].marked", req.Row, req.Col), strconv.FormatBool(req.Marked), gameCode, owner, plateNumber)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to mark field", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to mark field")
+		return
+	}
+
+	event := MarkEvent{PlateNumber: plateNumber, Row: req.Row, Col: req.Col, Marked: req.Marked}
+	h.events.publish(eventKey(gameCode, owner), Event{Type: "mark", Data: event})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/generator"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+const maxDisplayNameLength = 200
+
+type TrackNamePreview struct {
+	ID       string              `json:"id"`
+	Artists  []string            `json:"artists"`
+	Original string              `json:"original"`
+	Cleaned  string              `json:"cleaned"`
+	Removals []trackname.Removal `json:"removals,omitempty"`
+}
+
+type TrackNamesResponse struct {
+	PlaylistID   string             `json:"playlist_id"`
+	PlaylistName string             `json:"playlist_name"`
+	Changed      int                `json:"changed"`
+	Tracks       []TrackNamePreview `json:"tracks"`
+}
+
+// PreviewTrackNames shows how the cleaning rules will rename every track of
+// a playlist, and which rule made each removal.
+func (h *GameHandler) PreviewTrackNames(w http.ResponseWriter, r *http.Request) {
+	session, ok := spotifySession(r, h.db)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeSessionExpired, "Invalid or expired session")
+		return
+	}
+
+	client := spotify.NewClient(session.SpotifyToken, spotify.WithAPIURL(h.spotifyAPIURL))
+	sources := []models.Source{{Type: models.SourceTypePlaylist, ID: r.PathValue("id")}}
+	playlistData, _, err := client.FetchSources(r.Context(), sources, h.db)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to fetch tracks", "sources", sources, "error", err)
+		writeSpotifyError(w, r, err, "Failed to fetch tracks from Spotify")
+		return
+	}
+
+	resp := TrackNamesResponse{
+		PlaylistID:   playlistData.PlaylistID,
+		PlaylistName: playlistData.PlaylistName,
+		Tracks:       make([]TrackNamePreview, 0, len(playlistData.Tracks)),
+	}
+	for _, track := range playlistData.Tracks {
+		result := h.names.Clean(track.Name)
+		if result.Cleaned != result.Original {
+			resp.Changed++
+		}
+		resp.Tracks = append(resp.Tracks, TrackNamePreview{
+			ID:       track.ID,
+			Artists:  track.Artists,
+			Original: result.Original,
+			Cleaned:  result.Cleaned,
+			Removals: result.Removals,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type SetDisplayNameRequest struct {
+	DisplayName string `json:"display_name"`
+}
+
+type SetDisplayNameResponse struct {
+	TrackID       string `json:"track_id"`
+	DisplayName   string `json:"display_name"`
+	FieldsUpdated int    `json:"fields_updated"`
+}
+
+// SetDisplayName lets the game creator replace the cleaned name of one
+// track. Every plate field showing the track is rewritten. An empty
+// display_name removes the override and restores the cleaned name.
+func (h *GameHandler) SetDisplayName(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	trackID := r.PathValue("trackID")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var req SetDisplayNameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	displayName := strings.Join(strings.Fields(req.DisplayName), " ")
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Display name is too long",
+			map[string]any{"field": "display_name", "max": maxDisplayNameLength})
+		return
+	}
+
+	if !h.requireCreator(w, r, gameCode, "Only game creator can rename tracks") {
+		return
+	}
+
+	playlistData, err := h.db.GameTracks(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load game tracks", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid game data")
+		return
+	}
+	track, ok := findTrack(playlistData, trackID)
+	if !ok {
+		writeErrorDetails(w, r, http.StatusNotFound, ErrCodeTrackNotFound, "Track is not part of this game",
+			map[string]any{"track_id": trackID})
+		return
+	}
+
+	name := displayName
+	if name == "" {
+		name = h.names.CleanName(track.Name)
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to rename track")
+		return
+	}
+	defer tx.Rollback()
+
+	if displayName == "" {
+		_, err = tx.Exec(`DELETE FROM track_name_overrides WHERE game_code = ? AND track_id = ?`, gameCode, trackID)
+	} else {
+		_, err = tx.Exec(`INSERT INTO track_name_overrides (game_code, track_id, display_name, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(game_code, track_id) DO UPDATE SET display_name = excluded.display_name, updated_at = excluded.updated_at`,
+			gameCode, trackID, displayName, time.Now())
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to save display name", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to rename track")
+		return
+	}
+
+	updated, err := renamePlateFields(tx, gameCode, track, name)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to rewrite plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to rename track")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		middleware.Logger(r.Context()).Error("failed to commit display name", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to rename track")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SetDisplayNameResponse{
+		TrackID:       trackID,
+		DisplayName:   name,
+		FieldsUpdated: updated,
+	})
+}
+
+// renamePlateFields rewrites every track and combined field of the game that
+// shows track, keeping marks, and returns how many fields changed.
+func renamePlateFields(tx *sql.Tx, gameCode string, track models.Track, name string) (int, error) {
+	rows, err := tx.Query(`SELECT id, fields FROM plates WHERE game_code = ?`, gameCode)
+	if err != nil {
+		return 0, err
+	}
+	tracks := map[string]models.Track{track.ID: track}
+	names := map[string]string{track.ID: name}
+	changed := make(map[int64]models.PlateFields)
+	updated := 0
+	for rows.Next() {
+		var id int64
+		var fieldsJSON string
+		if err := rows.Scan(&id, &fieldsJSON); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		fields, err := models.PlateFieldsFromJSON(fieldsJSON)
+		if err != nil {
+			continue
+		}
+		if n := renameFields(&fields, tracks, names); n > 0 {
+			changed[id] = fields
+			updated += n
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, fields := range changed {
+		fieldsJSON, _ := fields.ToJSON()
+		if _, err := tx.Exec(`UPDATE plates SET fields = ? WHERE id = ?`, fieldsJSON, id); err != nil {
+			return 0, err
+		}
+	}
+	return updated, nil
+}
+
+// renameFields shows the display names in names, by track ID, on the track
+// and combined fields of a plate and returns how many fields changed. Keys
+// are left alone, so renamed fields match calls as before.
+func renameFields(fields *models.PlateFields, tracks map[string]models.Track, names map[string]string) int {
+	n := 0
+	for row := range fields.Grid {
+		for col := range fields.Grid[row] {
+			field := &fields.Grid[row][col]
+			name, ok := names[field.TrackID]
+			if !ok {
+				continue
+			}
+			switch field.Type {
+			case "track":
+				field.Content = name
+			case "combined":
+				field.Content = generator.CombinedContent(name, tracks[field.TrackID].Artists)
+			default:
+				continue
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// nameOverrides returns the display names the host gave tracks of a game,
+// by track ID.
+func (h *GameHandler) nameOverrides(ctx context.Context, gameCode string) (map[string]string, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT track_id, display_name FROM track_name_overrides WHERE game_code = ?`, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// cleanNames returns a copy of data with every track name run through the
+// cleaning rules.
+func (h *GameHandler) cleanNames(data models.PlaylistData) models.PlaylistData {
+	tracks := make([]models.Track, len(data.Tracks))
+	for i, track := range data.Tracks {
+		track.Name = h.names.CleanName(track.Name)
+		tracks[i] = track
+	}
+	data.Tracks = tracks
+	return data
+}
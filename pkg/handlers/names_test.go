@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func remasteredPlaylist(n int) spotifytest.Playlist {
+	tracks := spotifytest.Tracks(n)
+	for i := range tracks {
+		tracks[i].Name += " - 2011 Remaster"
+	}
+	return spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: tracks}
+}
+
+func TestPreviewTrackNames(t *testing.T) {
+	env := newTestEnv(t)
+	pl := remasteredPlaylist(3)
+	pl.Tracks[2].Name = "Mix Tape"
+	env.spotify.AddPlaylist(pl)
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+	rec := serve(t, h.PreviewTrackNames, http.MethodGet, "/api/playlists/pl1/names", env.loggedInSession(t), "", "id", "pl1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var resp TrackNamesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Changed != 2 || len(resp.Tracks) != 3 {
+		t.Fatalf("changed = %d of %d tracks, want 2 of 3", resp.Changed, len(resp.Tracks))
+	}
+	first := resp.Tracks[0]
+	if first.Original != "Track 1 - 2011 Remaster" || first.Cleaned != "Track 1" {
+		t.Errorf("first track = %q -> %q", first.Original, first.Cleaned)
+	}
+	if len(first.Removals) != 1 || first.Removals[0].Rule != "version-dash" {
+		t.Errorf("removals = %+v, want one by version-dash", first.Removals)
+	}
+	if last := resp.Tracks[2]; last.Cleaned != "Mix Tape" || last.Removals != nil {
+		t.Errorf("Mix Tape was cleaned: %+v", last)
+	}
+}
+
+func setDisplayName(t *testing.T, h *GameHandler, sessionID, gameCode, trackID, name string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(SetDisplayNameRequest{DisplayName: name})
+	return serve(t, h.SetDisplayName, http.MethodPut, "/api/games/"+gameCode+"/tracks/"+trackID+"/name", sessionID, string(body),
+		"code", gameCode, "trackID", trackID)
+}
+
+func TestSetDisplayName(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(remasteredPlaylist(30))
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	var field models.BingoField
+	for _, row := range game.Plates[0].Fields.Grid {
+		for _, f := range row {
+			if f.TrackID != "" {
+				field = f
+			}
+		}
+	}
+	if strings.Contains(field.Content, "Remaster") {
+		t.Fatalf("plate field was not cleaned: %q", field.Content)
+	}
+
+	plateContents := func() []string {
+		var contents []string
+		rows, err := env.db.Query(`SELECT fields FROM plates WHERE game_code = ?`, game.GameCode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var fieldsJSON string
+			rows.Scan(&fieldsJSON)
+			fields, _ := models.PlateFieldsFromJSON(fieldsJSON)
+			for _, row := range fields.Grid {
+				for _, f := range row {
+					if f.TrackID == field.TrackID {
+						contents = append(contents, f.Content)
+					}
+				}
+			}
+		}
+		return contents
+	}
+
+	if rec := setDisplayName(t, h, env.loggedInSession(t), game.GameCode, field.TrackID, "Nope"); rec.Code != http.StatusForbidden {
+		t.Errorf("non-creator status = %d, want 403", rec.Code)
+	}
+	if rec := setDisplayName(t, h, creator, game.GameCode, "missing", "Nope"); rec.Code != http.StatusNotFound || decodeError(t, rec).Error.Code != ErrCodeTrackNotFound {
+		t.Errorf("unknown track status = %d, want 404 track_not_found", rec.Code)
+	}
+
+	rec = setDisplayName(t, h, creator, game.GameCode, field.TrackID, "  The Real  Name ")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp SetDisplayNameResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.DisplayName != "The Real Name" || resp.FieldsUpdated == 0 {
+		t.Errorf("response = %+v", resp)
+	}
+	for _, content := range plateContents() {
+		if content != "The Real Name" {
+			t.Errorf("plate field = %q after override", content)
+		}
+	}
+
+	rec = setDisplayName(t, h, creator, game.GameCode, field.TrackID, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, body = %s", rec.Code, rec.Body)
+	}
+	for _, content := range plateContents() {
+		if content != field.Content {
+			t.Errorf("plate field = %q after reset, want %q", content, field.Content)
+		}
+	}
+	var overrides int
+	env.db.QueryRow(`SELECT COUNT(*) FROM track_name_overrides`).Scan(&overrides)
+	if overrides != 0 {
+		t.Errorf("overrides = %d after reset, want 0", overrides)
+	}
+}
+
+func TestDisplayNameOnNewPlatesAndCalls(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(remasteredPlaylist(30))
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+
+	// Every track is renamed, so whatever the new deal picks shows an
+	// override.
+	for i := 1; i <= 30; i++ {
+		id := fmt.Sprintf("track%d", i)
+		if rec := setDisplayName(t, h, creator, code, id, "Renamed "+id); rec.Code != http.StatusOK {
+			t.Fatalf("rename status = %d, body = %s", rec.Code, rec.Body)
+		}
+	}
+	if rec := startRound(t, h, creator, code, `{"new_plates":true}`); rec.Code != http.StatusCreated {
+		t.Fatalf("start with new plates status = %d, body = %s", rec.Code, rec.Body)
+	}
+	plates := joinGame(t, h, creator, code).Plates
+	fields := 0
+	for _, row := range plates[0].Fields.Grid {
+		for _, f := range row {
+			if f.TrackID == "" {
+				continue
+			}
+			fields++
+			if f.Content != "Renamed "+f.TrackID {
+				t.Errorf("new plate field = %q, want the display name of %s", f.Content, f.TrackID)
+			}
+		}
+	}
+	if fields == 0 {
+		t.Fatal("new plate has no track fields")
+	}
+
+	rec = callTrack(t, h, creator, code, "track3")
+	var call Call
+	json.NewDecoder(rec.Body).Decode(&call)
+	if call.Track.Name != "Renamed track3" {
+		t.Errorf("called track = %q, want the display name", call.Track.Name)
+	}
+	rec = serve(t, h.ListCalls, http.MethodGet, "/api/games/"+code+"/calls", "", "", "code", code)
+	var calls CallsResponse
+	json.NewDecoder(rec.Body).Decode(&calls)
+	if len(calls.Calls) != 1 || calls.Calls[0].Track.Name != "Renamed track3" {
+		t.Errorf("calls = %+v", calls.Calls)
+	}
+	rec = setlistRequest(t, h.GetSetlist, http.MethodGet, creator, code, "")
+	var setlist SetlistResponse
+	json.NewDecoder(rec.Body).Decode(&setlist)
+	if len(setlist.Entries) == 0 || setlist.Entries[0].Track.Name != "Renamed "+setlist.Entries[0].Track.ID {
+		t.Errorf("setlist = %+v", setlist.Entries)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+)
+
+type PlaylistInfo struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	TrackCount int    `json:"track_count"`
+	Owner      string `json:"owner,omitempty"`
+	ImageURL   string `json:"image_url,omitempty"`
+}
+
+type PlaylistsResponse struct {
+	Playlists []PlaylistInfo `json:"playlists"`
+	Total     int            `json:"total"`
+}
+
+// SearchPlaylists lists the user's playlists, optionally filtered by a
+// case-insensitive match on name or owner (q) and a minimum track count
+// (min_tracks).
+func (h *AuthHandler) SearchPlaylists(w http.ResponseWriter, r *http.Request) {
+	session, ok := spotifySession(r, h.db)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeSessionExpired, "Invalid or expired session")
+		return
+	}
+
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	minTracks := 0
+	if v := r.URL.Query().Get("min_tracks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "min_tracks must be a non-negative number",
+				map[string]any{"field": "min_tracks"})
+			return
+		}
+		minTracks = n
+	}
+
+	client := spotify.NewClient(session.SpotifyToken, spotify.WithAPIURL(h.spotifyAPIURL))
+	playlists, err := client.GetUserPlaylists(r.Context())
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to fetch user playlists", "error", err)
+		writeSpotifyError(w, r, err, "Failed to fetch playlists")
+		return
+	}
+
+	matches := []PlaylistInfo{}
+	for _, info := range toPlaylistInfos(playlists) {
+		if info.TrackCount < minTracks {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(info.Name), query) && !strings.Contains(strings.ToLower(info.Owner), query) {
+			continue
+		}
+		matches = append(matches, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlaylistsResponse{
+		Playlists: matches,
+		Total:     len(playlists),
+	})
+}
+
+func toPlaylistInfos(playlists []spotify.PlaylistItem) []PlaylistInfo {
+	infos := make([]PlaylistInfo, 0, len(playlists))
+	for _, playlist := range playlists {
+		infos = append(infos, PlaylistInfo{
+			ID:         playlist.ID,
+			Name:       playlist.Name,
+			TrackCount: playlist.Tracks.Total,
+			Owner:      playlist.Owner.DisplayName,
+			ImageURL:   thumbnailURL(playlist.Images),
+		})
+	}
+	return infos
+}
+
+// thumbnailURL picks the smallest cover that is still large enough for the
+// picker. Spotify lists images largest first and sometimes omits sizes.
+func thumbnailURL(images []spotify.Image) string {
+	const minSize = 60
+	best := ""
+	bestWidth := 0
+	for _, img := range images {
+		if best == "" {
+			best, bestWidth = img.URL, img.Width
+			continue
+		}
+		if img.Width >= minSize && (bestWidth == 0 || img.Width < bestWidth) {
+			best, bestWidth = img.URL, img.Width
+		}
+	}
+	return best
+}
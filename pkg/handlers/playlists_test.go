@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+)
+
+func TestSearchPlaylistsFollowsPagination(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.PageSize = 50
+	for i := 1; i <= 120; i++ {
+		env.spotify.AddPlaylist(spotifytest.Playlist{
+			ID:     fmt.Sprintf("pl%d", i),
+			Name:   fmt.Sprintf("Playlist %d", i),
+			Owner:  "dj",
+			Tracks: spotifytest.Tracks(i),
+		})
+	}
+	h := NewAuthHandler(env.db, env.cfg)
+	sessionID := env.loggedInSession(t)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 120},
+		{"?q=playlist+11", 11},      // 11, 110-119
+		{"?min_tracks=100", 21},     // 100-120
+		{"?q=DJ&min_tracks=115", 6}, // owner match, 115-120
+		{"?q=playlist+12&min_tracks=120", 1},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h.SearchPlaylists, http.MethodGet, "/api/playlists"+tt.query, sessionID, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body = %s", tt.query, rec.Code, rec.Body)
+		}
+
+		var resp PlaylistsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Playlists) != tt.want || resp.Total != 120 {
+			t.Errorf("%s: got %d of %d playlists, want %d of 120", tt.query, len(resp.Playlists), resp.Total, tt.want)
+		}
+	}
+
+	if got := env.spotify.Requests(spotifytest.RouteUserPlaylists); got != 3*len(tests) {
+		t.Errorf("playlist page requests = %d, want %d", got, 3*len(tests))
+	}
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// currentPlates restricts a plates query to the plates in play, those of
+// the latest round that dealt any.
+const currentPlates = `round = (SELECT MAX(p.round) FROM plates p WHERE p.game_code = plates.game_code)`
+
+type RoundSummary struct {
+	models.Round
+	Calls int `json:"calls"`
+}
+
+type RoundsResponse struct {
+	GameCode string         `json:"game_code"`
+	Rounds   []RoundSummary `json:"rounds"`
+}
+
+// NewRoundRequest starts the next round. Without PrizeTier or Prizes the
+// round awards the same prizes as the one before; NewPlates deals every
+// seat fresh plates.
+type NewRoundRequest struct {
+	PrizeTier string         `json:"prize_tier"`
+	Prizes    []models.Prize `json:"prizes"`
+	NewPlates bool           `json:"new_plates"`
+}
+
+var errRoundNotFound = errors.New("round not found")
+
+// scanRound scans the roundColumns of a row, followed by extra.
+func scanRound(row interface{ Scan(...any) error }, extra ...any) (models.Round, error) {
+	var round models.Round
+	var prizesJSON string
+	var finishedAt sql.NullTime
+	dest := append([]any{&round.Number, &round.PrizeTier, &prizesJSON, &round.Status, &round.NewPlates, &round.StartedAt, &finishedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return round, err
+	}
+	if finishedAt.Valid {
+		round.FinishedAt = &finishedAt.Time
+	}
+	// Rounds from before prizes award their tier without a cap.
+	round.Prizes = []models.Prize{{Tier: round.PrizeTier}}
+	if prizesJSON != "" {
+		prizes, err := models.PrizesFromJSON(prizesJSON)
+		if err != nil {
+			return round, fmt.Errorf("invalid prizes: %w", err)
+		}
+		round.Prizes = prizes
+	}
+	return round, nil
+}
+
+const roundColumns = `number, prize_tier, prizes, status, new_plates, started_at, finished_at`
+
+// resolvePrizes fills in a round's prize tier and prizes from each other.
+// A tier alone gets one prize without a cap, and prizes alone make their
+// hardest tier the one that wins the round.
+func resolvePrizes(tier string, prizes []models.Prize) (string, []models.Prize, error) {
+	if err := models.ValidatePrizes(prizes); err != nil {
+		return "", nil, err
+	}
+	if tier == "" {
+		tier = models.TopTier(prizes)
+	}
+	if tier == "" {
+		tier = models.PrizeTierOneRow
+	}
+	if !models.ValidPrizeTier(tier) {
+		return "", nil, errors.New("prize tier must be one_row, two_rows or full")
+	}
+	if len(prizes) == 0 {
+		prizes = []models.Prize{{Tier: tier}}
+	}
+	return tier, prizes, nil
+}
+
+// currentRound returns the latest round of a game, which may be finished.
+func (h *GameHandler) currentRound(ctx context.Context, gameCode string) (models.Round, error) {
+	round, err := scanRound(h.db.QueryRowContext(ctx,
+		`SELECT `+roundColumns+` FROM rounds WHERE game_code = ? ORDER BY number DESC LIMIT 1`, gameCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Round{}, errGameNotFound
+	}
+	return round, err
+}
+
+// activeRound writes an error response and returns false unless the game
+// has a round in progress.
+func (h *GameHandler) activeRound(w http.ResponseWriter, r *http.Request, gameCode, failure string) (models.Round, bool) {
+	round, err := h.currentRound(r.Context(), gameCode)
+	if errors.Is(err, errGameNotFound) {
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+		return round, false
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, failure)
+		return round, false
+	}
+	if round.Status != models.RoundActive {
+		writeErrorDetails(w, r, http.StatusConflict, ErrCodeRoundFinished, "The round is finished; start a new one to continue",
+			map[string]any{"round": round.Number})
+		return round, false
+	}
+	return round, true
+}
+
+// ListRounds returns every round of a game with its number of calls.
+func (h *GameHandler) ListRounds(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	rows, err := h.db.Query(`SELECT `+roundColumns+`,
+		(SELECT COUNT(*) FROM calls WHERE calls.game_code = rounds.game_code AND calls.round = rounds.number)
+		FROM rounds WHERE game_code = ? ORDER BY number`, gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to query rounds", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch rounds")
+		return
+	}
+	defer rows.Close()
+
+	resp := RoundsResponse{GameCode: gameCode, Rounds: []RoundSummary{}}
+	for rows.Next() {
+		var s RoundSummary
+		var err error
+		if s.Round, err = scanRound(rows, &s.Calls); err != nil {
+			middleware.Logger(r.Context()).Error("failed to scan round", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch rounds")
+			return
+		}
+		resp.Rounds = append(resp.Rounds, s)
+	}
+	if len(resp.Rounds) == 0 {
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// StartRound finishes the current round, if it is still going, and starts
+// the next one. Players keep their plates unless new ones are asked for.
+func (h *GameHandler) StartRound(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var req NewRoundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can start rounds") {
+		return
+	}
+
+	prev, err := h.currentRound(r.Context(), gameCode)
+	if errors.Is(err, errGameNotFound) {
+		writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+		return
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to start round")
+		return
+	}
+	if req.PrizeTier == "" && len(req.Prizes) == 0 {
+		req.PrizeTier, req.Prizes = prev.PrizeTier, prev.Prizes
+	}
+	tier, prizes, err := resolvePrizes(req.PrizeTier, req.Prizes)
+	if err != nil {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid prizes",
+			map[string]any{"field": "prizes", "reason": err.Error()})
+		return
+	}
+
+	round := models.Round{
+		Number:    prev.Number + 1,
+		PrizeTier: tier,
+		Prizes:    prizes,
+		Status:    models.RoundActive,
+		NewPlates: req.NewPlates,
+		StartedAt: time.Now(),
+	}
+
+	// The round and its plates are stored together; plates of a round
+	// that failed to start would otherwise become the plates in play.
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to start round")
+		return
+	}
+	defer tx.Rollback()
+
+	if req.NewPlates {
+		d, err := h.loadDeal(r.Context(), gameCode)
+		if err != nil {
+			middleware.Logger(r.Context()).Error("failed to load game settings", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to start round")
+			return
+		}
+		seats, err := h.seats(r.Context(), gameCode)
+		if err != nil {
+			middleware.Logger(r.Context()).Error("failed to load seats", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to start round")
+			return
+		}
+		// Exclusions made since the game started can leave too few tracks.
+		if err := h.generator.CheckCapacity(d.tracks, len(seats)*d.platesPerPlayer, d.options.Recipe); err != nil {
+			writeCapacityError(w, r, err, len(seats), d.platesPerPlayer)
+			return
+		}
+		if _, err := h.dealPlates(r.Context(), tx, gameCode, round.Number, seats, d); err != nil {
+			middleware.Logger(r.Context()).Error("failed to deal plates", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to generate plates")
+			return
+		}
+	}
+
+	prizesJSON, _ := models.PrizesToJSON(round.Prizes)
+	_, err = tx.Exec(`UPDATE rounds SET status = ?, finished_at = ? WHERE game_code = ? AND status = ?`,
+		models.RoundFinished, round.StartedAt, gameCode, models.RoundActive)
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO rounds (game_code, number, prize_tier, prizes, status, new_plates, started_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameCode, round.Number, round.PrizeTier, prizesJSON, round.Status, round.NewPlates, round.StartedAt)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to start round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to start round")
+		return
+	}
+	middleware.Logger(r.Context()).Info("round started", "round", round.Number, "prize_tier", round.PrizeTier, "new_plates", round.NewPlates)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(round)
+}
+
+// FinishRound ends a round. Calls and claims are refused until the next
+// round starts. Finishing a finished round changes nothing.
+func (h *GameHandler) FinishRound(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Round number must be a number")
+		return
+	}
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can finish rounds") {
+		return
+	}
+
+	_, err = h.db.Exec(`UPDATE rounds SET status = ?, finished_at = ? WHERE game_code = ? AND number = ? AND status = ?`,
+		models.RoundFinished, time.Now(), gameCode, number, models.RoundActive)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to finish round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to finish round")
+		return
+	}
+	round, err := h.round(r.Context(), gameCode, number)
+	if errors.Is(err, errRoundNotFound) {
+		writeErrorDetails(w, r, http.StatusNotFound, ErrCodeRoundNotFound, "Round not found",
+			map[string]any{"round": number})
+		return
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to finish round")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(round)
+}
+
+func (h *GameHandler) round(ctx context.Context, gameCode string, number int) (models.Round, error) {
+	round, err := scanRound(h.db.QueryRowContext(ctx,
+		`SELECT `+roundColumns+` FROM rounds WHERE game_code = ? AND number = ?`, gameCode, number))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Round{}, errRoundNotFound
+	}
+	return round, err
+}
+
+// seats returns the owners of the plates in play in the order they were
+// dealt, open seats included.
+func (h *GameHandler) seats(ctx context.Context, gameCode string) ([]string, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT user_session_id FROM plates WHERE game_code = ? AND `+currentPlates+`
+		GROUP BY user_session_id ORDER BY MIN(id)`, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seats []string
+	for rows.Next() {
+		var seat string
+		if err := rows.Scan(&seat); err != nil {
+			return nil, err
+		}
+		seats = append(seats, seat)
+	}
+	return seats, rows.Err()
+}
+
+// loadDeal rebuilds what a game's plates were generated from. A locked
+// setlist is the order of play, so plates are balanced against it.
+func (h *GameHandler) loadDeal(ctx context.Context, gameCode string) (deal, error) {
+	var d deal
+	var contentType, recipeJSON string
+	err := h.db.QueryRowContext(ctx, `SELECT content_type, content_recipe, difficulty, plates_per_player FROM games WHERE game_code = ?`, gameCode).
+		Scan(&contentType, &recipeJSON, &d.options.Difficulty, &d.platesPerPlayer)
+	if err != nil {
+		return deal{}, err
+	}
+	if recipeJSON != "" {
+		d.options.Recipe, err = models.ContentRecipeFromJSON(recipeJSON)
+		if err != nil {
+			return deal{}, fmt.Errorf("invalid content recipe: %w", err)
+		}
+	} else {
+		var ok bool
+		if d.options.Recipe, ok = models.RecipeForContentType(contentType); !ok {
+			return deal{}, fmt.Errorf("unknown content type %q", contentType)
+		}
+	}
+
+	s, locked, err := h.loadSetlist(ctx, gameCode)
+	if err != nil {
+		return deal{}, err
+	}
+	d.tracks.Tracks = s.playable()
+	if locked {
+		for _, t := range d.tracks.Tracks {
+			d.options.PlayOrder = append(d.options.PlayOrder, t.ID)
+		}
+	}
+	return d, nil
+}
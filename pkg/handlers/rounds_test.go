@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func startRound(t *testing.T, h *GameHandler, sessionID, gameCode, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.StartRound, http.MethodPost, "/api/games/"+gameCode+"/rounds", sessionID, body, "code", gameCode)
+}
+
+func joinGame(t *testing.T, h *GameHandler, sessionID, gameCode string) JoinGameResponse {
+	t.Helper()
+	rec := serve(t, h.JoinGame, http.MethodGet, "/api/games/join?code="+gameCode, sessionID, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("join status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp JoinGameResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return resp
+}
+
+func TestRounds(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+	player := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+	first := game.Plates[0]
+	joined := joinGame(t, h, player, code).Plates[0]
+
+	var firstRow []string
+	for _, field := range first.Fields.Grid[0] {
+		if field.TrackID != "" {
+			firstRow = append(firstRow, field.TrackID)
+		}
+	}
+	for _, id := range firstRow {
+		if rec := callTrack(t, h, creator, code, id); rec.Code != http.StatusOK {
+			t.Fatalf("call status = %d, body = %s", rec.Code, rec.Body)
+		}
+	}
+	if resp := claimPlate(t, h, creator, code, 1); !resp.Bingo || resp.Round != 1 || resp.PrizeTier != models.PrizeTierOneRow {
+		t.Errorf("round 1 claim = %+v, want a one_row bingo", resp)
+	}
+
+	// Round 2 keeps the plates but starts the calls afresh.
+	if rec := startRound(t, h, player, code, `{}`); rec.Code != http.StatusForbidden {
+		t.Errorf("non-creator start status = %d, want 403", rec.Code)
+	}
+	if rec := startRound(t, h, creator, code, `{"prize_tier":"everything"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad prize tier status = %d, want 400", rec.Code)
+	}
+	rec = startRound(t, h, creator, code, `{"prize_tier":"full"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("start status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if resp := claimPlate(t, h, creator, code, 1); resp.Bingo || resp.Rows != 0 || resp.Calls != 0 || resp.Round != 2 {
+		t.Errorf("round 2 claim before calls = %+v", resp)
+	}
+	if rec := callTrack(t, h, creator, code, firstRow[0]); rec.Code != http.StatusOK {
+		t.Errorf("recall in a new round status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, h.FinishRound, http.MethodPost, "/api/games/"+code+"/rounds/2/finish", creator, "", "code", code, "number", "2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("finish status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := callTrack(t, h, creator, code, "track39"); rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeRoundFinished {
+		t.Errorf("call after finish status = %d, want 409 %s", rec.Code, ErrCodeRoundFinished)
+	}
+
+	// Round 3 deals new plates to every seat.
+	if rec := startRound(t, h, creator, code, `{"new_plates":true}`); rec.Code != http.StatusCreated {
+		t.Fatalf("start with new plates status = %d, body = %s", rec.Code, rec.Body)
+	}
+	for _, tt := range []struct {
+		session string
+		old     models.Plate
+	}{{creator, first}, {player, joined}} {
+		plates := joinGame(t, h, tt.session, code).Plates
+		if len(plates) != 1 || plates[0].Round != 3 || plates[0].Fields == tt.old.Fields {
+			t.Errorf("plates after new deal = %+v", plates)
+		}
+	}
+
+	rec = serve(t, h.ListRounds, http.MethodGet, "/api/games/"+code+"/rounds", "", "", "code", code)
+	var rounds RoundsResponse
+	json.NewDecoder(rec.Body).Decode(&rounds)
+	if len(rounds.Rounds) != 3 || rounds.Rounds[0].Calls != 5 || rounds.Rounds[1].Calls != 1 ||
+		rounds.Rounds[1].Status != models.RoundFinished || rounds.Rounds[2].Status != models.RoundActive || !rounds.Rounds[2].NewPlates ||
+		rounds.Rounds[2].PrizeTier != models.PrizeTierFull {
+		t.Errorf("rounds = %+v", rounds.Rounds)
+	}
+
+	rec = serve(t, h.ListCalls, http.MethodGet, "/api/games/"+code+"/calls?round=1", "", "", "code", code)
+	var calls CallsResponse
+	json.NewDecoder(rec.Body).Decode(&calls)
+	if calls.Round != 1 || len(calls.Calls) != 5 {
+		t.Errorf("round 1 calls = %+v", calls)
+	}
+
+	if _, err := env.db.Exec(`DELETE FROM rounds WHERE game_code = ?`, code); err != nil {
+		t.Fatal(err)
+	}
+	if rec := startRound(t, h, creator, code, `{}`); rec.Code != http.StatusNotFound || decodeError(t, rec).Error.Code != ErrCodeGameNotFound {
+		t.Errorf("start in a game without rounds status = %d, want 404 %s", rec.Code, ErrCodeGameNotFound)
+	}
+}
+
+func TestFailedRoundDealsNoPlates(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+
+	// The round can't be stored once its plates are dealt.
+	if _, err := env.db.Exec(`CREATE TRIGGER fail_round BEFORE INSERT ON rounds BEGIN SELECT RAISE(FAIL, 'no more rounds'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if rec := startRound(t, h, creator, code, `{"new_plates":true}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("start status = %d, want 500", rec.Code)
+	}
+	var orphans int
+	env.db.QueryRow(`SELECT COUNT(*) FROM plates WHERE game_code = ? AND round > 1`, code).Scan(&orphans)
+	if orphans != 0 {
+		t.Errorf("plates of the failed round = %d, want 0", orphans)
+	}
+	if plates := joinGame(t, h, creator, code).Plates; len(plates) != 1 || plates[0].Fields != game.Plates[0].Fields {
+		t.Errorf("plates in play after a failed round = %+v", plates)
+	}
+
+	// Nor is a game whose first round can't be stored.
+	if rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("create status = %d, want 500", rec.Code)
+	}
+	for _, table := range []string{"games", "game_sources", "setlist_entries", "plates"} {
+		var games int
+		env.db.QueryRow(`SELECT COUNT(DISTINCT game_code) FROM ` + table).Scan(&games)
+		if games != 1 {
+			t.Errorf("games in %s after a failed create = %d, want 1", table, games)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// Seat statuses.
+const (
+	SeatOpen   = "open"
+	SeatHost   = "host"
+	SeatPlayer = "player"
+	SeatTeam   = "team"
+)
+
+// Seat is a player slot of a game. Seats are numbered in the order their
+// plates were dealt, and an open seat's plates wait for the next player to
+// join.
+type Seat struct {
+	Number int    `json:"number"`
+	Status string `json:"status"`
+	Team   string `json:"team,omitempty"`
+}
+
+type SeatsResponse struct {
+	GameCode    string `json:"game_code"`
+	PlayerCount int    `json:"player_count"`
+	Seats       []Seat `json:"seats"`
+}
+
+type AddSeatsRequest struct {
+	Count int `json:"count"`
+}
+
+type TransferSeatRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+func openSeat(number int) string {
+	return fmt.Sprintf("PLAYER_%d", number)
+}
+
+// seatList describes the seats of a game for its host.
+func (h *GameHandler) seatList(ctx context.Context, gameCode, creatorID string) (SeatsResponse, error) {
+	resp := SeatsResponse{GameCode: gameCode, Seats: []Seat{}}
+	if err := h.db.QueryRowContext(ctx, `SELECT player_count FROM games WHERE game_code = ?`, gameCode).Scan(&resp.PlayerCount); err != nil {
+		return resp, err
+	}
+	owners, err := h.seats(ctx, gameCode)
+	if err != nil {
+		return resp, err
+	}
+	teams, err := h.teamNames(ctx, gameCode)
+	if err != nil {
+		return resp, err
+	}
+	for i, owner := range owners {
+		seat := Seat{Number: i + 1, Status: SeatPlayer}
+		switch {
+		case owner == creatorID:
+			seat.Status = SeatHost
+		case strings.HasPrefix(owner, "PLAYER_"):
+			seat.Status = SeatOpen
+		case strings.HasPrefix(owner, teamOwnerPrefix):
+			seat.Status = SeatTeam
+			seat.Team = teams[owner]
+		}
+		resp.Seats = append(resp.Seats, seat)
+	}
+	return resp, nil
+}
+
+func (h *GameHandler) writeSeats(w http.ResponseWriter, r *http.Request, gameCode string, status int, failure string) {
+	sessionCookie, _ := r.Cookie("session_id")
+	resp, err := h.seatList(r.Context(), gameCode, sessionCookie.Value)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load seats", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, failure)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListSeats returns the seats of a game and who holds them.
+func (h *GameHandler) ListSeats(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can view seats") {
+		return
+	}
+	h.writeSeats(w, r, gameCode, http.StatusOK, "Failed to fetch seats")
+}
+
+// AddSeats opens more seats in a game, dealing their plates in the current
+// round. The player count grows with them; plates already dealt stay as
+// they are.
+func (h *GameHandler) AddSeats(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var req AddSeatsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if req.Count < 1 {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Count must be at least 1",
+			map[string]any{"field": "count"})
+		return
+	}
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can add seats") {
+		return
+	}
+
+	seats, err := h.seats(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load seats", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to add seats")
+		return
+	}
+	playerCount := len(seats) + req.Count
+	if playerCount > maxPlayers {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("A game can have at most %d players", maxPlayers),
+			map[string]any{"field": "count", "max": maxPlayers - len(seats)})
+		return
+	}
+
+	d, err := h.loadDeal(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load game settings", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to add seats")
+		return
+	}
+	if err := h.generator.CheckCapacity(d.tracks, playerCount*d.platesPerPlayer, d.options.Recipe); err != nil {
+		writeCapacityError(w, r, err, playerCount, d.platesPerPlayer)
+		return
+	}
+	var round int
+	if err := h.db.QueryRow(`SELECT MAX(round) FROM plates WHERE game_code = ?`, gameCode).Scan(&round); err != nil {
+		middleware.Logger(r.Context()).Error("failed to load round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to add seats")
+		return
+	}
+
+	var added []string
+	for n := len(seats) + 1; n <= playerCount; n++ {
+		added = append(added, openSeat(n))
+	}
+	d.partial = true
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to add seats")
+		return
+	}
+	defer tx.Rollback()
+	if _, err := h.dealPlates(r.Context(), tx, gameCode, round, added, d); err != nil {
+		middleware.Logger(r.Context()).Error("failed to deal plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to generate plates")
+		return
+	}
+	_, err = tx.Exec(`UPDATE games SET player_count = ? WHERE game_code = ?`, playerCount, gameCode)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to update player count", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to add seats")
+		return
+	}
+	middleware.Logger(r.Context()).Info("seats added", "count", req.Count, "player_count", playerCount)
+
+	h.writeSeats(w, r, gameCode, http.StatusCreated, "Failed to add seats")
+}
+
+// seatOwner resolves the {seat} of a host request to who holds it. Team
+// seats belong to their members, so the host can't hand them over.
+func (h *GameHandler) seatOwner(w http.ResponseWriter, r *http.Request, gameCode, forbidden string) (int, string, bool) {
+	number, err := strconv.Atoi(r.PathValue("seat"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Seat must be a number")
+		return 0, "", false
+	}
+	if !h.requireCreator(w, r, gameCode, forbidden) {
+		return 0, "", false
+	}
+	seats, err := h.seats(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load seats", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to load seats")
+		return 0, "", false
+	}
+	if number < 1 || number > len(seats) {
+		writeErrorDetails(w, r, http.StatusNotFound, ErrCodeSeatNotFound, "Seat not found",
+			map[string]any{"seat": number})
+		return 0, "", false
+	}
+	owner := seats[number-1]
+	if strings.HasPrefix(owner, teamOwnerPrefix) {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Team seats can't be released or transferred",
+			map[string]any{"seat": number})
+		return 0, "", false
+	}
+	return number, owner, true
+}
+
+// moveSeat gives the plates of a seat in every round, and the prizes they
+// won, to a new owner. With clearMarks the new owner starts from unmarked
+// plates.
+func (h *GameHandler) moveSeat(ctx context.Context, gameCode, from, to string, clearMarks bool) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := reassignPlates(tx, gameCode, from, to); err != nil {
+		return err
+	}
+	if clearMarks {
+		if err := unmarkPlates(tx, gameCode, to); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// unmarkPlates clears every mark on an owner's plates.
+func unmarkPlates(tx *sql.Tx, gameCode, owner string) error {
+	rows, err := tx.Query(`SELECT id, fields FROM plates WHERE game_code = ? AND user_session_id = ?`, gameCode, owner)
+	if err != nil {
+		return err
+	}
+	changed := make(map[int64]models.PlateFields)
+	for rows.Next() {
+		var id int64
+		var fieldsJSON string
+		if err := rows.Scan(&id, &fieldsJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		fields, err := models.PlateFieldsFromJSON(fieldsJSON)
+		if err != nil {
+			continue
+		}
+		marked := false
+		for row := range fields.Grid {
+			for col := range fields.Grid[row] {
+				marked = marked || fields.Grid[row][col].Marked
+				fields.Grid[row][col].Marked = false
+			}
+		}
+		if marked {
+			changed[id] = fields
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, fields := range changed {
+		fieldsJSON, _ := fields.ToJSON()
+		if _, err := tx.Exec(`UPDATE plates SET fields = ? WHERE id = ?`, fieldsJSON, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// playsIn reports whether a session holds plates in a game, of its own or
+// through a team.
+func (h *GameHandler) playsIn(ctx context.Context, gameCode, sessionID string) (bool, error) {
+	var n int
+	err := h.db.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM plates WHERE game_code = ? AND user_session_id = ?)
+		+ (SELECT COUNT(*) FROM team_members WHERE game_code = ? AND user_session_id = ?)`,
+		gameCode, sessionID, gameCode, sessionID).Scan(&n)
+	return n > 0, err
+}
+
+func reassignPlates(tx *sql.Tx, gameCode, from, to string) error {
+	if _, err := tx.Exec(`UPDATE plates SET user_session_id = ? WHERE game_code = ? AND user_session_id = ?`, to, gameCode, from); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE winners SET user_session_id = ? WHERE game_code = ? AND user_session_id = ?`, to, gameCode, from)
+	return err
+}
+
+// ReleaseSeat takes a seat's plates away from the player holding it, such
+// as one who left, so the next player to join gets them.
+func (h *GameHandler) ReleaseSeat(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	number, owner, ok := h.seatOwner(w, r, gameCode, "Only the game creator can release seats")
+	if !ok {
+		return
+	}
+	if strings.HasPrefix(owner, "PLAYER_") {
+		writeErrorDetails(w, r, http.StatusConflict, ErrCodeSeatOpen, "Seat is already open",
+			map[string]any{"seat": number})
+		return
+	}
+
+	if err := h.moveSeat(r.Context(), gameCode, owner, openSeat(number), true); err != nil {
+		middleware.Logger(r.Context()).Error("failed to release seat", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to release seat")
+		return
+	}
+	middleware.Logger(r.Context()).Info("seat released", "seat", number)
+
+	h.writeSeats(w, r, gameCode, http.StatusOK, "Failed to release seat")
+}
+
+// TransferSeat gives a seat's plates to another session, such as a player
+// who lost their cookie. The session must not hold plates in the game yet.
+func (h *GameHandler) TransferSeat(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var req TransferSeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if req.SessionID == "" {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "session_id is required",
+			map[string]any{"field": "session_id"})
+		return
+	}
+	number, owner, ok := h.seatOwner(w, r, gameCode, "Only the game creator can transfer seats")
+	if !ok {
+		return
+	}
+
+	var known int
+	h.db.QueryRow(`SELECT COUNT(*) FROM user_sessions WHERE session_id = ?`, req.SessionID).Scan(&known)
+	if known == 0 {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Unknown session",
+			map[string]any{"field": "session_id"})
+		return
+	}
+	seated, err := h.playsIn(r.Context(), gameCode, req.SessionID)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to transfer seat")
+		return
+	}
+	if seated {
+		writeError(w, r, http.StatusConflict, ErrCodeAlreadySeated, "That session already plays in this game")
+		return
+	}
+
+	if err := h.moveSeat(r.Context(), gameCode, owner, req.SessionID, false); err != nil {
+		middleware.Logger(r.Context()).Error("failed to transfer seat", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to transfer seat")
+		return
+	}
+	middleware.Logger(r.Context()).Info("seat transferred", "seat", number)
+
+	h.writeSeats(w, r, gameCode, http.StatusOK, "Failed to transfer seat")
+}
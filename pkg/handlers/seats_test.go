@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func seatRequest(t *testing.T, handler http.HandlerFunc, sessionID, gameCode, seat, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, handler, http.MethodPost, "/api/games/"+gameCode+"/seats", sessionID, body, "code", gameCode, "seat", seat)
+}
+
+func TestSeats(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(60)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+	alice := env.loggedInSession(t)
+	bob := env.loggedInSession(t)
+	carol := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+	alicePlate := joinGame(t, h, alice, code).Plates[0]
+
+	if rec := seatRequest(t, h.ListSeats, alice, code, "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("non-creator list status = %d, want 403", rec.Code)
+	}
+	if rec := seatRequest(t, h.ReleaseSeat, alice, code, "2", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("non-creator release status = %d, want 403", rec.Code)
+	}
+
+	// The game is full until the host adds a seat.
+	if full := serve(t, h.JoinGame, http.MethodGet, "/api/games/join?code="+code, bob, ""); full.Code != http.StatusConflict {
+		t.Fatalf("join full game status = %d, want 409", full.Code)
+	}
+	rec = seatRequest(t, h.AddSeats, creator, code, "", `{"count":1}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("add seats status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var seats SeatsResponse
+	json.NewDecoder(rec.Body).Decode(&seats)
+	if seats.PlayerCount != 3 || len(seats.Seats) != 3 || seats.Seats[0].Status != SeatHost || seats.Seats[2].Status != SeatOpen {
+		t.Errorf("seats after adding = %+v", seats)
+	}
+	if rec := seatRequest(t, h.AddSeats, creator, code, "", `{"count":18}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("adding past the maximum status = %d, want 400", rec.Code)
+	}
+	joinGame(t, h, bob, code)
+	if plates := joinGame(t, h, alice, code).Plates; plates[0].Fields != alicePlate.Fields {
+		t.Error("adding a seat changed alice's plate")
+	}
+
+	// Alice leaves; her plate goes, unmarked, to the next player to join.
+	col := 0
+	for alicePlate.Fields.Grid[0][col].Content == "" {
+		col++
+	}
+	if rec := markField(t, h, alice, code, alicePlate.PlateNumber, `{"row":0,"col":`+strconv.Itoa(col)+`,"marked":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("mark status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := seatRequest(t, h.ReleaseSeat, creator, code, "2", ""); rec.Code != http.StatusOK {
+		t.Fatalf("release status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := seatRequest(t, h.ReleaseSeat, creator, code, "2", ""); rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeSeatOpen {
+		t.Errorf("release open seat status = %d, want 409 %s", rec.Code, ErrCodeSeatOpen)
+	}
+	if rec := seatRequest(t, h.ReleaseSeat, creator, code, "9", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("release unknown seat status = %d, want 404", rec.Code)
+	}
+	carolPlate := joinGame(t, h, carol, code).Plates[0]
+	if carolPlate.Fields != alicePlate.Fields {
+		t.Errorf("carol got %+v, want alice's released plate unmarked", carolPlate.Fields)
+	}
+
+	// Carol's plate moves to alice's new session.
+	if rec := seatRequest(t, h.TransferSeat, creator, code, "2", `{"session_id":"`+bob+`"}`); rec.Code != http.StatusConflict {
+		t.Errorf("transfer to a seated session status = %d, want 409", rec.Code)
+	}
+	if rec := seatRequest(t, h.TransferSeat, creator, code, "2", `{"session_id":"nobody"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("transfer to an unknown session status = %d, want 400", rec.Code)
+	}
+	if rec := seatRequest(t, h.TransferSeat, creator, code, "2", `{"session_id":"`+alice+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("transfer status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if plates := joinGame(t, h, alice, code).Plates; len(plates) != 1 || plates[0].Fields != alicePlate.Fields {
+		t.Error("alice didn't get the transferred plate")
+	}
+}
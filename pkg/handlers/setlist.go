@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// SetlistEntry is one track of a game's setlist.
+type SetlistEntry struct {
+	Position int          `json:"position"`
+	Track    models.Track `json:"track"`
+	Excluded bool         `json:"excluded"`
+	Called   bool         `json:"called"`
+}
+
+type SetlistResponse struct {
+	GameCode string `json:"game_code"`
+	// Locked setlists can't be reordered; stepping through with next
+	// locks them.
+	Locked  bool           `json:"locked"`
+	Entries []SetlistEntry `json:"entries"`
+	// Remaining counts the tracks next has yet to call.
+	Remaining int `json:"remaining"`
+}
+
+// SetlistRequest rearranges a setlist. Tracks listed in Order move to the
+// front in that order; the rest keep their order after them. Exclude
+// replaces the excluded tracks when present.
+type SetlistRequest struct {
+	Order   []string `json:"order"`
+	Exclude []string `json:"exclude"`
+}
+
+type setlistItem struct {
+	Track    models.Track
+	Excluded bool
+}
+
+// setlist is the planned order of play for a game, including the tracks
+// the host left out.
+type setlist []setlistItem
+
+// arrangeSetlist puts the tracks listed in order first, in that order, and
+// the others after them in their current order, flagging excluded ones.
+// Unknown IDs are ignored.
+func arrangeSetlist(tracks []models.Track, order, exclude []string) setlist {
+	byID := make(map[string]models.Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+	excluded := make(map[string]bool, len(exclude))
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+
+	placed := make(map[string]bool, len(tracks))
+	s := make(setlist, 0, len(tracks))
+	add := func(t models.Track) {
+		if placed[t.ID] {
+			return
+		}
+		placed[t.ID] = true
+		s = append(s, setlistItem{Track: t, Excluded: excluded[t.ID]})
+	}
+	for _, id := range order {
+		if t, ok := byID[id]; ok {
+			add(t)
+		}
+	}
+	for _, t := range tracks {
+		add(t)
+	}
+	return s
+}
+
+func (s setlist) tracks() []models.Track {
+	tracks := make([]models.Track, len(s))
+	for i, item := range s {
+		tracks[i] = item.Track
+	}
+	return tracks
+}
+
+// playable returns the tracks that weren't excluded, in setlist order.
+func (s setlist) playable() []models.Track {
+	var tracks []models.Track
+	for _, item := range s {
+		if !item.Excluded {
+			tracks = append(tracks, item.Track)
+		}
+	}
+	return tracks
+}
+
+func (s setlist) excluded() []string {
+	var ids []string
+	for _, item := range s {
+		if item.Excluded {
+			ids = append(ids, item.Track.ID)
+		}
+	}
+	return ids
+}
+
+func saveSetlist(ctx context.Context, tx *sql.Tx, gameCode string, s setlist) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM setlist_entries WHERE game_code = ?`, gameCode); err != nil {
+		return err
+	}
+	for i, item := range s {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO setlist_entries (game_code, position, track_id, excluded) VALUES (?, ?, ?, ?)`,
+			gameCode, i, item.Track.ID, item.Excluded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSetlist returns a game's setlist and whether it is locked. Games
+// created before setlists existed get one in playlist order.
+func (h *GameHandler) loadSetlist(ctx context.Context, gameCode string) (setlist, bool, error) {
+	var locked bool
+	if err := h.db.QueryRowContext(ctx, `SELECT setlist_locked FROM games WHERE game_code = ?`, gameCode).Scan(&locked); err != nil {
+		return nil, false, err
+	}
+	data, err := h.db.GameTracks(ctx, gameCode)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rows, err := h.db.QueryContext(ctx, `SELECT track_id, excluded FROM setlist_entries WHERE game_code = ? ORDER BY position`, gameCode)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var order, exclude []string
+	for rows.Next() {
+		var id string
+		var excluded bool
+		if err := rows.Scan(&id, &excluded); err != nil {
+			return nil, false, err
+		}
+		order = append(order, id)
+		if excluded {
+			exclude = append(exclude, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return arrangeSetlist(data.Tracks, order, exclude), locked, nil
+}
+
+// isExcluded reports whether the host left a track out of the setlist.
+func (h *GameHandler) isExcluded(ctx context.Context, gameCode, trackID string) bool {
+	var excluded bool
+	h.db.QueryRowContext(ctx, `SELECT excluded FROM setlist_entries WHERE game_code = ? AND track_id = ?`, gameCode, trackID).Scan(&excluded)
+	return excluded
+}
+
+// calledTracks returns the IDs of the tracks called in the current round.
+func (h *GameHandler) calledTracks(ctx context.Context, gameCode string) (map[string]bool, error) {
+	round, err := h.currentRound(ctx, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := h.db.QueryContext(ctx, `SELECT track_id FROM calls WHERE game_code = ? AND round = ?`, gameCode, round.Number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	called := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		called[id] = true
+	}
+	return called, rows.Err()
+}
+
+// writeSetlist responds with the setlist and which of its tracks have been
+// called this round.
+func (h *GameHandler) writeSetlist(w http.ResponseWriter, r *http.Request, gameCode string, s setlist, locked bool) {
+	called, err := h.calledTracks(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load calls", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch setlist")
+		return
+	}
+	names, err := h.nameOverrides(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load display names", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch setlist")
+		return
+	}
+
+	resp := SetlistResponse{GameCode: gameCode, Locked: locked, Entries: []SetlistEntry{}}
+	for i, item := range s {
+		entry := SetlistEntry{Position: i + 1, Track: item.Track, Excluded: item.Excluded, Called: called[item.Track.ID]}
+		if name, ok := names[item.Track.ID]; ok {
+			entry.Track.Name = name
+		}
+		if !entry.Excluded && !entry.Called {
+			resp.Remaining++
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetSetlist returns the planned order of play. Only the host sees it,
+// since it gives away what is coming.
+func (h *GameHandler) GetSetlist(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can see the setlist") {
+		return
+	}
+
+	s, locked, err := h.loadSetlist(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch setlist")
+		return
+	}
+	h.writeSetlist(w, r, gameCode, s, locked)
+}
+
+// UpdateSetlist reorders the setlist and changes which tracks are left
+// out. Plates already exist, so a track can't be excluded if some plate
+// field would be left with no track to match it, and plates were balanced
+// for the order known when they were generated.
+func (h *GameHandler) UpdateSetlist(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var req SetlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can change the setlist") {
+		return
+	}
+
+	s, locked, err := h.loadSetlist(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to update setlist")
+		return
+	}
+	if locked {
+		writeError(w, r, http.StatusConflict, ErrCodeSetlistLocked, "The setlist is locked")
+		return
+	}
+	data := models.PlaylistData{Tracks: s.tracks()}
+	if !checkTrackIDs(w, r, data, "order", req.Order) || !checkTrackIDs(w, r, data, "exclude", req.Exclude) {
+		return
+	}
+
+	exclude := s.excluded()
+	if req.Exclude != nil {
+		fields, err := h.plateFields(r.Context(), gameCode)
+		if err != nil {
+			middleware.Logger(r.Context()).Error("failed to load plates", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to update setlist")
+			return
+		}
+		if id, field, found := h.unplayableField(arrangeSetlist(s.tracks(), nil, req.Exclude), fields); found {
+			writeErrorDetails(w, r, http.StatusConflict, ErrCodeTrackOnPlate, "Track is the only one left for a plate field and can't be excluded",
+				map[string]any{"track_id": id, "field": field.Content})
+			return
+		}
+		exclude = req.Exclude
+	}
+
+	s = arrangeSetlist(s.tracks(), req.Order, exclude)
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to update setlist")
+		return
+	}
+	defer tx.Rollback()
+	err = saveSetlist(r.Context(), tx, gameCode, s)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to save setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to update setlist")
+		return
+	}
+	h.writeSetlist(w, r, gameCode, s, false)
+}
+
+// LockSetlist freezes the setlist so it can no longer be changed.
+func (h *GameHandler) LockSetlist(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can lock the setlist") {
+		return
+	}
+	if _, err := h.db.Exec(`UPDATE games SET setlist_locked = 1 WHERE game_code = ?`, gameCode); err != nil {
+		middleware.Logger(r.Context()).Error("failed to lock setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to lock setlist")
+		return
+	}
+
+	s, _, err := h.loadSetlist(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch setlist")
+		return
+	}
+	h.writeSetlist(w, r, gameCode, s, true)
+}
+
+// NextTrack calls the first track of the setlist that is neither excluded
+// nor already called, locking the setlist if it wasn't yet. Tracks called
+// out of order by hand are skipped.
+func (h *GameHandler) NextTrack(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	if !h.requireCreator(w, r, gameCode, "Only game creator can call tracks") {
+		return
+	}
+	round, ok := h.activeRound(w, r, gameCode, "Failed to call track")
+	if !ok {
+		return
+	}
+	if _, err := h.db.Exec(`UPDATE games SET setlist_locked = 1 WHERE game_code = ?`, gameCode); err != nil {
+		middleware.Logger(r.Context()).Error("failed to lock setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to call track")
+		return
+	}
+
+	s, _, err := h.loadSetlist(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load setlist", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to call track")
+		return
+	}
+	called, err := h.calledTracks(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load calls", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to call track")
+		return
+	}
+
+	for _, item := range s {
+		if item.Excluded || called[item.Track.ID] {
+			continue
+		}
+		call, err := h.recordCall(r.Context(), gameCode, round.Number, item.Track)
+		if errors.Is(err, errAlreadyCalled) {
+			writeErrorDetails(w, r, http.StatusConflict, ErrCodeAlreadyCalled, "Track has already been called",
+				map[string]any{"track_id": item.Track.ID})
+			return
+		}
+		if err != nil {
+			middleware.Logger(r.Context()).Error("failed to insert call", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to call track")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(call)
+		return
+	}
+	writeError(w, r, http.StatusConflict, ErrCodeSetlistFinished, "Every track of the setlist has been called")
+}
+
+// plateFields returns the filled fields of the game's plates.
+func (h *GameHandler) plateFields(ctx context.Context, gameCode string) ([]models.BingoField, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT fields FROM plates WHERE game_code = ? AND `+currentPlates, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []models.BingoField
+	for rows.Next() {
+		var fieldsJSON string
+		if err := rows.Scan(&fieldsJSON); err != nil {
+			return nil, err
+		}
+		plate, err := models.PlateFieldsFromJSON(fieldsJSON)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range plate.Grid {
+			for _, f := range row {
+				if f.Content != "" {
+					fields = append(fields, f)
+				}
+			}
+		}
+	}
+	return fields, rows.Err()
+}
+
+// unplayableField looks for a plate field that no track the setlist plays
+// can match, and returns the excluded track that would have matched it.
+func (h *GameHandler) unplayableField(s setlist, fields []models.BingoField) (string, models.BingoField, bool) {
+	// Field keys were built from cleaned names.
+	var played, excluded []models.Track
+	for _, item := range s {
+		if item.Excluded {
+			excluded = append(excluded, item.Track)
+		} else {
+			played = append(played, item.Track)
+		}
+	}
+	playable := h.claims.Called(h.cleanNames(models.PlaylistData{Tracks: played}).Tracks)
+	excluded = h.cleanNames(models.PlaylistData{Tracks: excluded}).Tracks
+
+	for _, field := range fields {
+		keys := h.claims.FieldKeys(field)
+		if len(keys) == 0 || slices.ContainsFunc(keys, func(k string) bool { return playable[k] }) {
+			continue
+		}
+		for _, t := range excluded {
+			matches := h.claims.Called([]models.Track{t})
+			if slices.ContainsFunc(keys, func(k string) bool { return matches[k] }) {
+				return t.ID, field, true
+			}
+		}
+	}
+	return "", models.BingoField{}, false
+}
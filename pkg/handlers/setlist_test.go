@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func setlistRequest(t *testing.T, handler http.HandlerFunc, method, sessionID, gameCode, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, handler, method, "/api/games/"+gameCode+"/setlist", sessionID, body, "code", gameCode)
+}
+
+func TestSetlist(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks",
+		"play_order":["track10","track9"],"exclude":["track1","track2","track3","track4","track5"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+
+	fields, err := h.plateFields(context.Background(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onPlates := make(map[string]bool)
+	for _, f := range fields {
+		onPlates[f.TrackID] = true
+	}
+	for _, id := range []string{"track1", "track2", "track3", "track4", "track5"} {
+		if onPlates[id] {
+			t.Errorf("excluded %s is on a plate", id)
+		}
+	}
+
+	var setlist SetlistResponse
+	json.NewDecoder(setlistRequest(t, h.GetSetlist, http.MethodGet, creator, code, "").Body).Decode(&setlist)
+	if len(setlist.Entries) != 40 || setlist.Entries[0].Track.ID != "track10" || setlist.Entries[1].Track.ID != "track9" ||
+		!setlist.Entries[2].Excluded || setlist.Remaining != 35 || setlist.Locked {
+		t.Fatalf("setlist = %+v", setlist)
+	}
+	if rec := setlistRequest(t, h.GetSetlist, http.MethodGet, env.loggedInSession(t), code, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("non-creator setlist status = %d, want 403", rec.Code)
+	}
+
+	var onPlate string
+	for id := range onPlates {
+		onPlate = id
+		break
+	}
+	rec = setlistRequest(t, h.UpdateSetlist, http.MethodPut, creator, code, `{"exclude":["`+onPlate+`"]}`)
+	if rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeTrackOnPlate {
+		t.Errorf("excluding a plate track status = %d, want 409 %s", rec.Code, ErrCodeTrackOnPlate)
+	}
+
+	rec = setlistRequest(t, h.UpdateSetlist, http.MethodPut, creator, code, `{"order":["track20"]}`)
+	json.NewDecoder(rec.Body).Decode(&setlist)
+	if rec.Code != http.StatusOK || setlist.Entries[0].Track.ID != "track20" || setlist.Entries[1].Track.ID != "track10" || setlist.Remaining != 35 {
+		t.Fatalf("reordered setlist status = %d: %+v", rec.Code, setlist)
+	}
+
+	// Calling by hand still works, but not for excluded tracks.
+	if rec := callTrack(t, h, creator, code, "track10"); rec.Code != http.StatusOK {
+		t.Fatalf("call status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := callTrack(t, h, creator, code, "track1"); rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeTrackExcluded {
+		t.Errorf("excluded call status = %d, want 409 %s", rec.Code, ErrCodeTrackExcluded)
+	}
+
+	var played []string
+	for {
+		rec := setlistRequest(t, h.NextTrack, http.MethodPost, creator, code, "")
+		if rec.Code == http.StatusConflict {
+			if got := decodeError(t, rec).Error.Code; got != ErrCodeSetlistFinished {
+				t.Fatalf("next code = %s", got)
+			}
+			break
+		}
+		var call Call
+		json.NewDecoder(rec.Body).Decode(&call)
+		played = append(played, call.Track.ID)
+		if len(played) > 40 {
+			t.Fatal("next never finished")
+		}
+	}
+	if len(played) != 34 || played[0] != "track20" || played[1] != "track9" {
+		t.Errorf("played %d tracks starting %v, want 34 starting track20, track9", len(played), played[:2])
+	}
+
+	rec = setlistRequest(t, h.UpdateSetlist, http.MethodPut, creator, code, `{"order":["track30"]}`)
+	if rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeSetlistLocked {
+		t.Errorf("update after next status = %d, want 409 %s", rec.Code, ErrCodeSetlistLocked)
+	}
+}
+
+func TestSetlistKeepsEveryFieldPlayable(t *testing.T) {
+	env := newTestEnv(t)
+	// Tracks come in pairs by the same artist: tracks 1 and 2 are by
+	// Track Artist 1, tracks 3 and 4 by Track Artist 3 and so on.
+	tracks := spotifytest.Tracks(60)
+	for i := 1; i < len(tracks); i += 2 {
+		tracks[i].Artists = tracks[i-1].Artists
+	}
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: tracks})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"artists"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+
+	fields, err := h.plateFields(context.Background(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) == 0 || fields[0].TrackID != "" {
+		t.Fatalf("plate fields = %+v, want artist fields", fields)
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(fields[0].Content, "Track Artist "))
+	if err != nil {
+		t.Fatalf("artist field = %q", fields[0].Content)
+	}
+	first, second := fmt.Sprintf("track%d", n), fmt.Sprintf("track%d", n+1)
+
+	if rec := setlistRequest(t, h.UpdateSetlist, http.MethodPut, creator, code, `{"exclude":["`+first+`"]}`); rec.Code != http.StatusOK {
+		t.Errorf("excluding one of two songs of an artist status = %d, body = %s", rec.Code, rec.Body)
+	}
+	rec = setlistRequest(t, h.UpdateSetlist, http.MethodPut, creator, code, `{"exclude":["`+first+`","`+second+`"]}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("excluding both songs of an artist status = %d, want 409", rec.Code)
+	}
+	if e := decodeError(t, rec).Error; e.Code != ErrCodeTrackOnPlate || (e.Details["track_id"] != first && e.Details["track_id"] != second) {
+		t.Errorf("error = %+v, want %s for %s or %s", e, ErrCodeTrackOnPlate, first, second)
+	}
+}
+
+func TestSetlistExclusionsCountAgainstCapacity(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(32)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+	rec := createGame(t, h, env.loggedInSession(t), `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"exclude":["track1","track2","track3"]}`)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Error.Code != ErrCodePlaylistTooSmall {
+		t.Errorf("status = %d, body = %s, want 400 %s", rec.Code, rec.Body, ErrCodePlaylistTooSmall)
+	}
+}
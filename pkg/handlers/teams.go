@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/metrics"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// Team plates are owned by "TEAM_<id>" rather than a session, the way open
+// seats are owned by "PLAYER_<n>" placeholders.
+const teamOwnerPrefix = "TEAM_"
+
+const maxTeamNameLength = 50
+
+type Team struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+}
+
+type TeamsResponse struct {
+	GameCode string `json:"game_code"`
+	Teams    []Team `json:"teams"`
+}
+
+type CreateTeamRequest struct {
+	Name string `json:"name"`
+}
+
+func teamOwner(id int64) string {
+	return teamOwnerPrefix + strconv.FormatInt(id, 10)
+}
+
+// plateOwner returns who owns the plates a session plays: its team, if it
+// joined one, or the session itself.
+func (h *GameHandler) plateOwner(ctx context.Context, gameCode, sessionID string) (string, error) {
+	var teamID int64
+	err := h.db.QueryRowContext(ctx, `SELECT team_id FROM team_members WHERE game_code = ? AND user_session_id = ?`, gameCode, sessionID).Scan(&teamID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sessionID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return teamOwner(teamID), nil
+}
+
+// teamNames maps the plate owners of a game's teams to the team names.
+func (h *GameHandler) teamNames(ctx context.Context, gameCode string) (map[string]string, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT id, name FROM teams WHERE game_code = ?`, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[teamOwner(id)] = name
+	}
+	return names, rows.Err()
+}
+
+// CreateTeam adds a team to a game. The team takes an open player slot, so
+// its plates are dealt like any player's.
+func (h *GameHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var req CreateTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxTeamNameLength {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest,
+			fmt.Sprintf("Team name must be 1 to %d characters", maxTeamNameLength),
+			map[string]any{"field": "name"})
+		return
+	}
+	if !h.requireCreator(w, r, gameCode, "Only the game creator can create teams") {
+		return
+	}
+
+	var taken int
+	h.db.QueryRow(`SELECT COUNT(*) FROM teams WHERE game_code = ? AND name = ?`, gameCode, req.Name).Scan(&taken)
+	if taken > 0 {
+		writeErrorDetails(w, r, http.StatusConflict, ErrCodeTeamNameTaken, "A team with that name already exists",
+			map[string]any{"name": req.Name})
+		return
+	}
+
+	var platesPerPlayer int
+	if err := h.db.QueryRow(`SELECT plates_per_player FROM games WHERE game_code = ?`, gameCode).Scan(&platesPerPlayer); err != nil {
+		middleware.Logger(r.Context()).Error("failed to load game", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create team")
+		return
+	}
+	// The team only exists once it has a seat.
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create team")
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO teams (game_code, name) VALUES (?, ?)`, gameCode, req.Name)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to insert team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create team")
+		return
+	}
+	team := Team{Name: req.Name}
+	team.ID, _ = res.LastInsertId()
+
+	_, err = takeSeat(r.Context(), tx, gameCode, teamOwner(team.ID), platesPerPlayer)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if errors.Is(err, errGameFull) {
+		metrics.GameFullRejections.Inc()
+		writeError(w, r, http.StatusConflict, ErrCodeGameFull, "Game is full - no available player slots")
+		return
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to seat team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to create team")
+		return
+	}
+	middleware.Logger(r.Context()).Info("team created", "team_id", team.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(team)
+}
+
+// ListTeams returns the teams of a game with their number of members.
+func (h *GameHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	if _, err := h.currentRound(r.Context(), gameCode); err != nil {
+		if errors.Is(err, errGameNotFound) {
+			writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+			return
+		}
+		middleware.Logger(r.Context()).Error("failed to load round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch teams")
+		return
+	}
+
+	rows, err := h.db.Query(`SELECT id, name, (SELECT COUNT(*) FROM team_members WHERE team_members.team_id = teams.id)
+		FROM teams WHERE game_code = ? ORDER BY id`, gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to query teams", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch teams")
+		return
+	}
+	defer rows.Close()
+
+	resp := TeamsResponse{GameCode: gameCode, Teams: []Team{}}
+	for rows.Next() {
+		var team Team
+		if err := rows.Scan(&team.ID, &team.Name, &team.Members); err != nil {
+			middleware.Logger(r.Context()).Error("failed to scan team", "error", err)
+			writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch teams")
+			return
+		}
+		resp.Teams = append(resp.Teams, team)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// JoinTeam makes the caller a member of a team, switching teams if they
+// were in another. Players holding plates of their own can't join one.
+func (h *GameHandler) JoinTeam(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	teamID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Team ID must be a number")
+		return
+	}
+	var team Team
+	err = h.db.QueryRow(`SELECT id, name FROM teams WHERE id = ? AND game_code = ?`, teamID, gameCode).Scan(&team.ID, &team.Name)
+	if err != nil {
+		writeErrorDetails(w, r, http.StatusNotFound, ErrCodeTeamNotFound, "Team not found",
+			map[string]any{"team_id": teamID})
+		return
+	}
+
+	sessionID, ok := ensureSession(w, r, h.db)
+	if !ok {
+		return
+	}
+
+	var own int
+	h.db.QueryRow(`SELECT COUNT(*) FROM plates WHERE game_code = ? AND user_session_id = ?`, gameCode, sessionID).Scan(&own)
+	if own > 0 {
+		writeError(w, r, http.StatusConflict, ErrCodeAlreadySeated, "You already have plates of your own in this game")
+		return
+	}
+
+	_, err = h.db.Exec(`INSERT INTO team_members (game_code, user_session_id, team_id) VALUES (?, ?, ?)
+		ON CONFLICT (game_code, user_session_id) DO UPDATE SET team_id = excluded.team_id, joined_at = CURRENT_TIMESTAMP`,
+		gameCode, sessionID, team.ID)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to join team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to join team")
+		return
+	}
+
+	plates, err := h.ownedPlates(r.Context(), gameCode, teamOwner(team.ID))
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load team plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to join team")
+		return
+	}
+	playlistData, err := h.db.GameTracks(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load game tracks", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid game data")
+		return
+	}
+	metrics.GameJoins.Inc()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(JoinGameResponse{
+		GameCode:     gameCode,
+		PlaylistName: playlistData.PlaylistName,
+		Team:         team.Name,
+		Plates:       plates,
+	})
+}
+
+// ownedPlates returns the plates in play owned by owner.
+func (h *GameHandler) ownedPlates(ctx context.Context, gameCode, owner string) ([]models.Plate, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT id, game_code, user_session_id, round, plate_number, fields FROM plates WHERE game_code = ? AND user_session_id = ? AND `+currentPlates+` ORDER BY plate_number`,
+		gameCode, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plates []models.Plate
+	for rows.Next() {
+		var plate models.Plate
+		var fieldsJSON string
+		if err := rows.Scan(&plate.ID, &plate.GameCode, &plate.UserSessionID, &plate.Round, &plate.PlateNumber, &fieldsJSON); err != nil {
+			return nil, err
+		}
+		if plate.Fields, err = models.PlateFieldsFromJSON(fieldsJSON); err != nil {
+			return nil, err
+		}
+		plates = append(plates, plate)
+	}
+	return plates, rows.Err()
+}
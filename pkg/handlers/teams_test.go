@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func createTeam(t *testing.T, h *GameHandler, sessionID, gameCode, name string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.CreateTeam, http.MethodPost, "/api/games/"+gameCode+"/teams", sessionID, `{"name":"`+name+`"}`, "code", gameCode)
+}
+
+func joinTeam(t *testing.T, h *GameHandler, sessionID, gameCode string, teamID int64) *httptest.ResponseRecorder {
+	t.Helper()
+	id := strconv.FormatInt(teamID, 10)
+	return serve(t, h.JoinTeam, http.MethodPost, "/api/games/"+gameCode+"/teams/"+id+"/join", sessionID, "", "code", gameCode, "id", id)
+}
+
+func markField(t *testing.T, h *GameHandler, sessionID, gameCode string, plateNumber int, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	n := strconv.Itoa(plateNumber)
+	return serve(t, h.MarkPlate, http.MethodPut, "/api/games/"+gameCode+"/plates/"+n+"/marks", sessionID, body, "code", gameCode, "number", n)
+}
+
+func TestTeams(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(50)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+	alice := env.loggedInSession(t)
+	bob := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":3,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+
+	if rec := createTeam(t, h, alice, code, "Red"); rec.Code != http.StatusForbidden {
+		t.Errorf("non-creator create status = %d, want 403", rec.Code)
+	}
+	rec = createTeam(t, h, creator, code, "Red")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create team status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var red Team
+	json.NewDecoder(rec.Body).Decode(&red)
+	if rec := createTeam(t, h, creator, code, "Red"); rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeTeamNameTaken {
+		t.Errorf("duplicate team status = %d, want 409 %s", rec.Code, ErrCodeTeamNameTaken)
+	}
+
+	var joined JoinGameResponse
+	json.NewDecoder(joinTeam(t, h, alice, code, red.ID).Body).Decode(&joined)
+	if joined.Team != "Red" || len(joined.Plates) != 1 {
+		t.Fatalf("alice joined %+v", joined)
+	}
+	plate := joined.Plates[0]
+	if rec := joinTeam(t, h, bob, code, red.ID); rec.Code != http.StatusOK {
+		t.Fatalf("bob join status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := joinTeam(t, h, creator, code, red.ID); rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeAlreadySeated {
+		t.Errorf("creator join status = %d, want 409 %s", rec.Code, ErrCodeAlreadySeated)
+	}
+	if rec := joinTeam(t, h, bob, code, 999); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown team status = %d, want 404", rec.Code)
+	}
+
+	// Bob's stream sees Alice's mark.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("code", code)
+		h.StreamEvents(w, r)
+	}))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.AddCookie(sessionCookie(bob))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewReader(resp.Body)
+	if line, _ := stream.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("stream opened with %q", line)
+	}
+
+	row, col := 0, 0
+	for plate.Fields.Grid[row][col].Content == "" {
+		col++
+	}
+	body := `{"row":0,"col":` + strconv.Itoa(col) + `,"marked":true}`
+	if rec := markField(t, h, alice, code, plate.PlateNumber, body); rec.Code != http.StatusOK {
+		t.Fatalf("mark status = %d, body = %s", rec.Code, rec.Body)
+	}
+	stream.ReadString('\n')
+	if line, _ := stream.ReadString('\n'); line != "event: mark\n" {
+		t.Errorf("event line = %q", line)
+	}
+	line, _ := stream.ReadString('\n')
+	var event MarkEvent
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+	if event.Col != col || !event.Marked || event.PlateNumber != plate.PlateNumber {
+		t.Errorf("event = %+v", event)
+	}
+	if plates := joinGame(t, h, bob, code).Plates; !plates[0].Fields.Grid[row][col].Marked {
+		t.Error("bob's plate isn't marked")
+	}
+	if rec := markField(t, h, creator, code, 5, body); rec.Code != http.StatusNotFound {
+		t.Errorf("marking another's plate status = %d, want 404", rec.Code)
+	}
+
+	// Claims by any member are credited to the team.
+	for _, field := range plate.Fields.Grid[0] {
+		if field.TrackID != "" {
+			callTrack(t, h, creator, code, field.TrackID)
+		}
+	}
+	claim := claimPlate(t, h, bob, code, plate.PlateNumber)
+	if !claim.Bingo || len(claim.Won) != 1 || claim.Won[0].Team != "Red" {
+		t.Errorf("team claim = %+v", claim)
+	}
+
+	if rec := createTeam(t, h, creator, code, "Blue"); rec.Code != http.StatusCreated {
+		t.Errorf("second team status = %d", rec.Code)
+	}
+	if rec := createTeam(t, h, creator, code, "Green"); rec.Code != http.StatusConflict || decodeError(t, rec).Error.Code != ErrCodeGameFull {
+		t.Errorf("team in a full game status = %d, want 409 %s", rec.Code, ErrCodeGameFull)
+	}
+
+	list := serve(t, h.ListTeams, http.MethodGet, "/api/games/"+code+"/teams", "", "", "code", code)
+	var teams TeamsResponse
+	json.NewDecoder(list.Body).Decode(&teams)
+	if len(teams.Teams) != 2 || teams.Teams[0].Members != 2 || teams.Teams[1].Members != 0 {
+		t.Errorf("teams = %+v", teams.Teams)
+	}
+}
+
+func TestFailedSeatCreatesNoTeam(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+
+	// The open seat can't be handed over after the team is stored, and
+	// stored teams can't be deleted again.
+	for _, trigger := range []string{
+		`CREATE TRIGGER fail_seat BEFORE UPDATE ON plates BEGIN SELECT RAISE(FAIL, 'no seats'); END`,
+		`CREATE TRIGGER keep_teams BEFORE DELETE ON teams BEGIN SELECT RAISE(FAIL, 'teams stay'); END`,
+	} {
+		if _, err := env.db.Exec(trigger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rec := createTeam(t, h, creator, code, "Red"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("create team status = %d, want 500", rec.Code)
+	}
+	var teams int
+	env.db.QueryRow(`SELECT COUNT(*) FROM teams WHERE game_code = ?`, code).Scan(&teams)
+	if teams != 0 {
+		t.Errorf("teams after a failed seat = %d, want 0", teams)
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+)
+
+const (
+	// deviceTransferTTL is how long a player has to open a transfer on
+	// their other device.
+	deviceTransferTTL = 10 * time.Minute
+
+	// transferCodeAlphabet leaves out letters and digits that are easily
+	// mistaken for each other when typed.
+	transferCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	transferCodeLength   = 8
+)
+
+type DeviceTransferRequest struct {
+	// InvalidateOldSession logs the current device out once the transfer
+	// is redeemed.
+	InvalidateOldSession bool `json:"invalidate_old_session"`
+}
+
+// DeviceTransferResponse is a one-time transfer, to be opened as URL or
+// typed in as Code on the other device.
+type DeviceTransferResponse struct {
+	Code      string    `json:"code"`
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RedeemTransferRequest struct {
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+func generateTransferCode() string {
+	b := make([]byte, transferCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = transferCodeAlphabet[int(b[i])%len(transferCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// StartDeviceTransfer issues a one-time transfer of the caller's plates in
+// a game to another device. A new transfer replaces any earlier one.
+func (h *GameHandler) StartDeviceTransfer(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	sessionCookie, err := r.Cookie("session_id")
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Not authenticated")
+		return
+	}
+	var req DeviceTransferRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+			return
+		}
+	}
+
+	seated, err := h.playsIn(r.Context(), gameCode, sessionCookie.Value)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to start transfer")
+		return
+	}
+	if !seated {
+		writeError(w, r, http.StatusNotFound, ErrCodePlateNotFound, "You have no plates in this game")
+		return
+	}
+
+	now := time.Now()
+	h.db.Exec(`DELETE FROM device_transfers WHERE expires_at < ? OR (game_code = ? AND user_session_id = ?)`,
+		now, gameCode, sessionCookie.Value)
+
+	resp := DeviceTransferResponse{
+		Token:     generateSessionID(),
+		ExpiresAt: now.Add(deviceTransferTTL),
+	}
+	// Codes are short enough to collide now and then.
+	for attempt := 0; ; attempt++ {
+		resp.Code = generateTransferCode()
+		_, err = h.db.Exec(`INSERT INTO device_transfers (token, code, game_code, user_session_id, invalidate_old, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			resp.Token, resp.Code, gameCode, sessionCookie.Value, req.InvalidateOldSession, resp.ExpiresAt)
+		if err == nil || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to insert device transfer", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to start transfer")
+		return
+	}
+	resp.URL = h.baseURL + "/game-view.html?" + url.Values{"code": {gameCode}, "transfer": {resp.Token}}.Encode()
+	middleware.Logger(r.Context()).Info("device transfer started", "invalidate_old_session", req.InvalidateOldSession)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RedeemDeviceTransfer moves the plates of a transfer, by token or code,
+// to the caller's session, along with their team membership, prizes and
+// the hosting of the game. A transfer can be redeemed once.
+func (h *GameHandler) RedeemDeviceTransfer(w http.ResponseWriter, r *http.Request) {
+	var req RedeemTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
+		return
+	}
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+	if req.Token == "" && req.Code == "" {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "token or code is required",
+			map[string]any{"field": "token"})
+		return
+	}
+
+	var token, gameCode, oldSession string
+	var invalidateOld bool
+	err := h.db.QueryRow(`SELECT token, game_code, user_session_id, invalidate_old FROM device_transfers
+		WHERE (token = ? OR code = ?) AND expires_at > ?`, req.Token, req.Code, time.Now()).
+		Scan(&token, &gameCode, &oldSession, &invalidateOld)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, ErrCodeTransferNotFound, "Transfer not found, already used or expired")
+		return
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load device transfer", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to redeem transfer")
+		return
+	}
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	newSession, ok := ensureSession(w, r, h.db)
+	if !ok {
+		return
+	}
+	if newSession == oldSession {
+		writeError(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "Open the transfer on your other device")
+		return
+	}
+	seated, err := h.playsIn(r.Context(), gameCode, newSession)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to redeem transfer")
+		return
+	}
+	if seated {
+		writeError(w, r, http.StatusConflict, ErrCodeAlreadySeated, "This device already plays in that game")
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to begin transaction", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to redeem transfer")
+		return
+	}
+	defer tx.Rollback()
+	// Whoever deletes the transfer redeems it.
+	res, err := tx.Exec(`DELETE FROM device_transfers WHERE token = ?`, token)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeError(w, r, http.StatusNotFound, ErrCodeTransferNotFound, "Transfer not found, already used or expired")
+			return
+		}
+		err = reassignPlates(tx, gameCode, oldSession, newSession)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE team_members SET user_session_id = ? WHERE game_code = ? AND user_session_id = ?`, newSession, gameCode, oldSession)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE games SET creator_session_id = ? WHERE game_code = ? AND creator_session_id = ?`, newSession, gameCode, oldSession)
+	}
+	if err == nil && invalidateOld {
+		_, err = tx.Exec(`DELETE FROM user_sessions WHERE session_id = ?`, oldSession)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to redeem device transfer", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to redeem transfer")
+		return
+	}
+	middleware.Logger(r.Context()).Info("device transfer redeemed", "invalidated_old_session", invalidateOld)
+
+	owner, err := h.plateOwner(r.Context(), gameCode, newSession)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to look up team", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to load plates")
+		return
+	}
+	plates, err := h.ownedPlates(r.Context(), gameCode, owner)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load plates", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to load plates")
+		return
+	}
+	teams, err := h.teamNames(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load teams", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to load plates")
+		return
+	}
+	playlistData, err := h.db.GameTracks(r.Context(), gameCode)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load game tracks", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Invalid game data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(JoinGameResponse{
+		GameCode:     gameCode,
+		PlaylistName: playlistData.PlaylistName,
+		Team:         teams[owner],
+		Plates:       plates,
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func startTransfer(t *testing.T, h *GameHandler, sessionID, gameCode, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.StartDeviceTransfer, http.MethodPost, "/api/games/"+gameCode+"/device-transfer", sessionID, body, "code", gameCode)
+}
+
+func redeemTransfer(t *testing.T, h *GameHandler, sessionID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.RedeemDeviceTransfer, http.MethodPost, "/api/device-transfer/redeem", sessionID, body)
+}
+
+func TestDeviceTransfer(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(60)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+	laptop := env.loggedInSession(t)
+	phone := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":3,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+	plate := joinGame(t, h, laptop, code).Plates[0]
+
+	if rec := startTransfer(t, h, phone, code, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("transfer without plates status = %d, want 404", rec.Code)
+	}
+	rec = startTransfer(t, h, laptop, code, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("start status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var transfer DeviceTransferResponse
+	json.NewDecoder(rec.Body).Decode(&transfer)
+	if len(transfer.Code) != transferCodeLength || !strings.Contains(transfer.URL, "transfer="+transfer.Token) {
+		t.Errorf("transfer = %+v", transfer)
+	}
+
+	if rec := redeemTransfer(t, h, laptop, `{"token":"`+transfer.Token+`"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("redeem on the same device status = %d, want 400", rec.Code)
+	}
+	rec = redeemTransfer(t, h, phone, `{"code":"`+strings.ToLower(transfer.Code)+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redeem status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var moved JoinGameResponse
+	json.NewDecoder(rec.Body).Decode(&moved)
+	if len(moved.Plates) != 1 || moved.Plates[0].Fields != plate.Fields {
+		t.Errorf("phone got %+v", moved.Plates)
+	}
+	if rec := redeemTransfer(t, h, phone, `{"token":"`+transfer.Token+`"}`); rec.Code != http.StatusNotFound || decodeError(t, rec).Error.Code != ErrCodeTransferNotFound {
+		t.Errorf("second redeem status = %d, want 404 %s", rec.Code, ErrCodeTransferNotFound)
+	}
+	if seated, err := h.playsIn(t.Context(), code, laptop); err != nil || seated {
+		t.Errorf("laptop still holds plates: %v, %v", seated, err)
+	}
+
+	// The host moves to a new device and logs the old one out.
+	rec = startTransfer(t, h, creator, code, `{"invalidate_old_session":true}`)
+	json.NewDecoder(rec.Body).Decode(&transfer)
+	rec = redeemTransfer(t, h, "", `{"token":"`+transfer.Token+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redeem on a new device status = %d, body = %s", rec.Code, rec.Body)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session_id" {
+		t.Fatalf("new device cookies = %v", cookies)
+	}
+	var creatorID string
+	var oldSessions int
+	env.db.QueryRow(`SELECT creator_session_id FROM games WHERE game_code = ?`, code).Scan(&creatorID)
+	env.db.QueryRow(`SELECT COUNT(*) FROM user_sessions WHERE session_id = ?`, creator).Scan(&oldSessions)
+	if creatorID != cookies[0].Value || oldSessions != 0 {
+		t.Errorf("creator = %q, old sessions = %d; want the new device hosting and the old session gone", creatorID, oldSessions)
+	}
+
+	// The logged out device joins as a newcomer, not as its old session.
+	rec = serve(t, h.JoinGame, http.MethodGet, "/api/games/join?code="+code, creator, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rejoin status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Value == creator {
+		t.Errorf("rejoin cookies = %v, want a new session", cookies)
+	}
+	if seated, err := h.playsIn(t.Context(), code, creator); err != nil || seated {
+		t.Errorf("logged out session holds plates: %v, %v", seated, err)
+	}
+}
+
+func TestDeviceTransferExpires(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	rec = startTransfer(t, h, creator, game.GameCode, "")
+	var transfer DeviceTransferResponse
+	json.NewDecoder(rec.Body).Decode(&transfer)
+	if _, err := env.db.Exec(`UPDATE device_transfers SET expires_at = ? WHERE token = ?`, time.Now().Add(-time.Second), transfer.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = redeemTransfer(t, h, env.loggedInSession(t), `{"token":"`+transfer.Token+`"}`)
+	if rec.Code != http.StatusNotFound || decodeError(t, rec).Error.Code != ErrCodeTransferNotFound {
+		t.Errorf("expired redeem status = %d, want 404 %s", rec.Code, ErrCodeTransferNotFound)
+	}
+	if seated, err := h.playsIn(t.Context(), game.GameCode, creator); err != nil || !seated {
+		t.Errorf("creator lost their plates to an expired transfer: %v, %v", seated, err)
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/middleware"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// Winner is one prize won by a plate. Players are numbered by seat, as in
+// the difficulty report.
+type Winner struct {
+	Round  int    `json:"round"`
+	Tier   string `json:"tier"`
+	Prize  string `json:"prize,omitempty"`
+	Player int    `json:"player"`
+	// Team is set for prizes won by a team.
+	Team        string `json:"team,omitempty"`
+	PlateNumber int    `json:"plate_number"`
+	// CallNumber is the call of the round that completed the tier.
+	CallNumber int       `json:"call_number"`
+	WonAt      time.Time `json:"won_at"`
+}
+
+type WinnersResponse struct {
+	GameCode string   `json:"game_code"`
+	Winners  []Winner `json:"winners"`
+}
+
+// award records that a plate of owner, a session or team, reached a prize's
+// tier on callNumber, unless the prize has run out. Plates that already
+// won it keep it.
+func (h *GameHandler) award(ctx context.Context, gameCode string, round int, prize models.Prize, owner string, plateNumber, callNumber int) error {
+	_, err := h.db.ExecContext(ctx, `INSERT OR IGNORE INTO winners (game_code, round, tier, prize, user_session_id, plate_number, call_number, won_at)
+		SELECT :game, :round, :tier, :prize, :session, :plate, :call, :now
+		WHERE :max = 0
+			OR (SELECT COUNT(*) FROM winners WHERE game_code = :game AND round = :round AND tier = :tier) < :max
+			OR (:tie_break = :share AND EXISTS (SELECT 1 FROM winners WHERE game_code = :game AND round = :round AND tier = :tier AND call_number = :call))`,
+		sql.Named("game", gameCode),
+		sql.Named("round", round),
+		sql.Named("tier", prize.Tier),
+		sql.Named("prize", prize.Name),
+		sql.Named("session", owner),
+		sql.Named("plate", plateNumber),
+		sql.Named("call", callNumber),
+		sql.Named("now", time.Now()),
+		sql.Named("max", prize.MaxWinners),
+		sql.Named("tie_break", prize.TieBreak),
+		sql.Named("share", models.TieBreakShare),
+	)
+	return err
+}
+
+// seatNumbers numbers the players of a game in the order their plates were
+// first dealt.
+func (h *GameHandler) seatNumbers(ctx context.Context, gameCode string) (map[string]int, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT user_session_id FROM plates WHERE game_code = ? GROUP BY user_session_id ORDER BY MIN(id)`, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seats := make(map[string]int)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		seats[id] = len(seats) + 1
+	}
+	return seats, rows.Err()
+}
+
+// listWinners returns the winners of a round, or of every round when round
+// is 0, in the order they won.
+func (h *GameHandler) listWinners(ctx context.Context, gameCode string, round int) ([]Winner, error) {
+	seats, err := h.seatNumbers(ctx, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := h.teamNames(ctx, gameCode)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := h.db.QueryContext(ctx, `SELECT round, tier, prize, user_session_id, plate_number, call_number, won_at
+		FROM winners WHERE game_code = ? AND (? = 0 OR round = ?) ORDER BY id`, gameCode, round, round)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	winners := []Winner{}
+	for rows.Next() {
+		var w Winner
+		var sessionID string
+		if err := rows.Scan(&w.Round, &w.Tier, &w.Prize, &sessionID, &w.PlateNumber, &w.CallNumber, &w.WonAt); err != nil {
+			return nil, err
+		}
+		w.Player = seats[sessionID]
+		w.Team = teams[sessionID]
+		winners = append(winners, w)
+	}
+	return winners, rows.Err()
+}
+
+// GetWinners returns the winners ledger of a game, optionally for one
+// ?round=. With ?format=csv it is a CSV file for the venue's records.
+func (h *GameHandler) GetWinners(w http.ResponseWriter, r *http.Request) {
+	gameCode := r.PathValue("code")
+	middleware.SetGameCode(r.Context(), gameCode)
+
+	var round int
+	if v := r.URL.Query().Get("round"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "round must be a positive number",
+				map[string]any{"field": "round"})
+			return
+		}
+		round = n
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeErrorDetails(w, r, http.StatusBadRequest, ErrCodeInvalidRequest, "format must be json or csv",
+			map[string]any{"field": "format"})
+		return
+	}
+
+	if _, err := h.currentRound(r.Context(), gameCode); err != nil {
+		if errors.Is(err, errGameNotFound) {
+			writeError(w, r, http.StatusNotFound, ErrCodeGameNotFound, "Game not found")
+			return
+		}
+		middleware.Logger(r.Context()).Error("failed to load round", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch winners")
+		return
+	}
+	winners, err := h.listWinners(r.Context(), gameCode, round)
+	if err != nil {
+		middleware.Logger(r.Context()).Error("failed to load winners", "error", err)
+		writeError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Failed to fetch winners")
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="bingo-`+gameCode+`-winners.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"round", "tier", "prize", "player", "team", "plate_number", "call_number", "won_at"})
+		for _, win := range winners {
+			cw.Write([]string{
+				strconv.Itoa(win.Round),
+				win.Tier,
+				win.Prize,
+				strconv.Itoa(win.Player),
+				win.Team,
+				strconv.Itoa(win.PlateNumber),
+				strconv.Itoa(win.CallNumber),
+				win.WonAt.UTC().Format(time.RFC3339),
+			})
+		}
+		cw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WinnersResponse{GameCode: gameCode, Winners: winners})
+}
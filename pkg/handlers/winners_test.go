@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/trackname"
+)
+
+func getWinners(t *testing.T, h *GameHandler, gameCode, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h.GetWinners, http.MethodGet, "/api/games/"+gameCode+"/winners"+query, "", "", "code", gameCode)
+}
+
+func TestWinners(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+	player := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks",
+		"prizes":[{"tier":"one_row","name":"Drink voucher","max_winners":1},{"tier":"full","name":"Main prize"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+	joinGame(t, h, player, code)
+
+	for i := 1; i <= 40; i++ {
+		if rec := callTrack(t, h, creator, code, fmt.Sprintf("track%d", i)); rec.Code != http.StatusOK {
+			t.Fatalf("call status = %d, body = %s", rec.Code, rec.Body)
+		}
+	}
+
+	resp := claimPlate(t, h, creator, code, 1)
+	if resp.PrizeTier != models.PrizeTierFull || !resp.Bingo || len(resp.Won) != 2 || len(resp.Missed) != 0 {
+		t.Errorf("first claim = %+v, want both prizes", resp)
+	}
+	// The voucher has run out; the main prize has no cap.
+	resp = claimPlate(t, h, player, code, 1)
+	if len(resp.Won) != 1 || resp.Won[0].Prize != "Main prize" || resp.Won[0].Player != 2 ||
+		len(resp.Missed) != 1 || resp.Missed[0] != models.PrizeTierOneRow {
+		t.Errorf("second claim = %+v, want the main prize only", resp)
+	}
+	if again := claimPlate(t, h, creator, code, 1); len(again.Won) != 2 {
+		t.Errorf("repeated claim won %+v, want the same two prizes", again.Won)
+	}
+
+	var winners WinnersResponse
+	json.NewDecoder(getWinners(t, h, code, "").Body).Decode(&winners)
+	if len(winners.Winners) != 3 {
+		t.Fatalf("winners = %+v", winners.Winners)
+	}
+	for _, w := range winners.Winners {
+		if w.Round != 1 || w.CallNumber < 5 || w.CallNumber > 40 || w.WonAt.IsZero() {
+			t.Errorf("winner = %+v", w)
+		}
+	}
+
+	rec = getWinners(t, h, code, "?format=csv")
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" || len(records) != 4 || records[0][2] != "prize" || records[1][2] != "Drink voucher" {
+		t.Errorf("csv = %v", records)
+	}
+	if rec := getWinners(t, h, code, "?format=xml"); rec.Code != http.StatusBadRequest {
+		t.Errorf("xml status = %d, want 400", rec.Code)
+	}
+}
+
+func TestReleasedSeatKeepsPrizes(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+	creator := env.loggedInSession(t)
+	player := env.loggedInSession(t)
+	newcomer := env.loggedInSession(t)
+
+	rec := createGame(t, h, creator, `{"playlist_id":"pl1","player_count":2,"plates_per_player":1,"content_type":"tracks",
+		"prizes":[{"tier":"full","name":"Main prize"}]}`)
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+	code := game.GameCode
+	joinGame(t, h, player, code)
+	for i := 1; i <= 40; i++ {
+		callTrack(t, h, creator, code, fmt.Sprintf("track%d", i))
+	}
+	if resp := claimPlate(t, h, player, code, 1); len(resp.Won) != 1 || resp.Won[0].Player != 2 {
+		t.Fatalf("claim = %+v, want the main prize for player 2", resp)
+	}
+
+	// The prize stays with seat 2 as it is released and taken again.
+	if rec := seatRequest(t, h.ReleaseSeat, creator, code, "2", ""); rec.Code != http.StatusOK {
+		t.Fatalf("release status = %d, body = %s", rec.Code, rec.Body)
+	}
+	joinGame(t, h, newcomer, code)
+
+	var winners WinnersResponse
+	json.NewDecoder(getWinners(t, h, code, "").Body).Decode(&winners)
+	if len(winners.Winners) != 1 || winners.Winners[0].Player != 2 {
+		t.Errorf("winners = %+v, want the main prize for player 2", winners.Winners)
+	}
+	records, err := csv.NewReader(getWinners(t, h, code, "?format=csv").Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][3] != "2" {
+		t.Errorf("csv = %v, want player 2", records)
+	}
+}
+
+func TestAwardTieBreaks(t *testing.T) {
+	env := newTestEnv(t)
+	env.spotify.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party", Tracks: spotifytest.Tracks(40)})
+	h := NewGameHandler(env.db, env.cfg, trackname.Default())
+
+	rec := createGame(t, h, env.loggedInSession(t), `{"playlist_id":"pl1","player_count":4,"plates_per_player":1,"content_type":"tracks"}`)
+	var game CreateGameResponse
+	json.NewDecoder(rec.Body).Decode(&game)
+
+	tests := []struct {
+		tieBreak string
+		want     int
+	}{
+		{models.TieBreakFirstClaim, 1},
+		// The third plate completed on a later call, so only the tie shares.
+		{models.TieBreakShare, 2},
+	}
+	for round, tt := range tests {
+		prize := models.Prize{Tier: models.PrizeTierOneRow, MaxWinners: 1, TieBreak: tt.tieBreak}
+		for i, call := range []int{7, 7, 8} {
+			if err := h.award(context.Background(), game.GameCode, round+1, prize, fmt.Sprintf("session%d", i), 1, call); err != nil {
+				t.Fatal(err)
+			}
+		}
+		winners, err := h.listWinners(context.Background(), game.GameCode, round+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(winners) != tt.want {
+			t.Errorf("%s: %d winners, want %d", tt.tieBreak, len(winners), tt.want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Package importer parses track lists from files so games can be created
+// without a Spotify account.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatM3U  Format = "m3u"
+	FormatJSON Format = "json"
+)
+
+// localPrefix marks the IDs of tracks that didn't come from Spotify.
+const localPrefix = "local:"
+
+// MaxTracks caps how many tracks a single import may contain.
+const MaxTracks = 5000
+
+var (
+	ErrUnknownFormat = errors.New("importer: unknown file format")
+	ErrNoTracks      = errors.New("importer: no tracks found")
+	ErrTooManyTracks = fmt.Errorf("importer: more than %d tracks", MaxTracks)
+)
+
+// DetectFormat guesses the format from the file name, falling back to
+// sniffing the content.
+func DetectFormat(filename string, content []byte) (Format, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv", ".tsv":
+		return FormatCSV, nil
+	case ".m3u", ".m3u8":
+		return FormatM3U, nil
+	case ".json":
+		return FormatJSON, nil
+	}
+
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(content, utf8BOM))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return FormatJSON, nil
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")), bytes.HasPrefix(trimmed, []byte("#EXTINF")):
+		return FormatM3U, nil
+	case bytes.ContainsAny(trimmed, ",;\t"):
+		return FormatCSV, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Parse reads a track list in the given format. name is used as the
+// playlist name when the file doesn't carry one.
+func Parse(format Format, name string, r io.Reader) (models.PlaylistData, error) {
+	var data models.PlaylistData
+	var err error
+	switch format {
+	case FormatCSV:
+		data, err = ParseCSV(r)
+	case FormatM3U:
+		data, err = ParseM3U(r)
+	case FormatJSON:
+		data, err = ParseJSON(r)
+	default:
+		return models.PlaylistData{}, ErrUnknownFormat
+	}
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+
+	if data.PlaylistName == "" {
+		data.PlaylistName = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+	return Normalize(data)
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// ParseCSV reads rows of title, artists and an optional year. A header row
+// naming the columns is optional; without one the columns are taken in that
+// order. Multiple artists in one cell are separated by ";" or "|". Comma,
+// semicolon and tab delimited files are accepted.
+func ParseCSV(r io.Reader) (models.PlaylistData, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+	content = bytes.TrimPrefix(content, utf8BOM)
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comma = sniffDelimiter(content)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return models.PlaylistData{}, fmt.Errorf("importer: invalid CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return models.PlaylistData{}, ErrNoTracks
+	}
+
+	titleCol, artistCol, yearCol := 0, 1, 2
+	if cols, ok := headerColumns(records[0]); ok {
+		titleCol, artistCol, yearCol = cols[0], cols[1], cols[2]
+		records = records[1:]
+	}
+
+	var data models.PlaylistData
+	for i, record := range records {
+		title := cell(record, titleCol)
+		if title == "" {
+			continue
+		}
+
+		track := models.Track{
+			Name:    title,
+			Artists: splitArtists(cell(record, artistCol)),
+		}
+		if y := cell(record, yearCol); y != "" {
+			year, err := parseYear(y)
+			if err != nil {
+				return models.PlaylistData{}, fmt.Errorf("importer: row %d: %w", i+1, err)
+			}
+			track.Year = year
+		}
+		data.Tracks = append(data.Tracks, track)
+	}
+	return data, nil
+}
+
+// ParseM3U reads an M3U or M3U8 playlist. Track details come from #EXTINF
+// lines ("#EXTINF:213,Artist - Title"); entries without one fall back to the
+// file name of the path line.
+func ParseM3U(r io.Reader) (models.PlaylistData, error) {
+	var data models.PlaylistData
+	var pending string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, string(utf8BOM))
+			first = false
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			// Attributes like tvg-name="x" may contain commas, so the
+			// display title starts after the last comma outside quotes.
+			if idx := lastUnquotedComma(info); idx >= 0 {
+				pending = strings.TrimSpace(info[idx+1:])
+			} else {
+				pending = ""
+			}
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			data.PlaylistName = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// Other directives and comments.
+		default:
+			display := pending
+			if display == "" {
+				display = fileTitle(line)
+			}
+			pending = ""
+
+			artists, title := splitDisplayTitle(display)
+			if title == "" {
+				continue
+			}
+			data.Tracks = append(data.Tracks, models.Track{Name: title, Artists: artists})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return models.PlaylistData{}, fmt.Errorf("importer: reading M3U: %w", err)
+	}
+	return data, nil
+}
+
+// ParseJSON reads the PlaylistData format used for stored games.
+func ParseJSON(r io.Reader) (models.PlaylistData, error) {
+	var data models.PlaylistData
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&data); err != nil {
+		return models.PlaylistData{}, fmt.Errorf("importer: invalid JSON: %w", err)
+	}
+	return data, nil
+}
+
+// Normalize validates a parsed list: names are trimmed, entries without a
+// title are dropped, exact duplicates are removed and tracks without an ID
+// get a stable one derived from their title and artists. IDs given in the
+// file are moved under the local: prefix so an import can't pass its tracks
+// off as Spotify's.
+func Normalize(data models.PlaylistData) (models.PlaylistData, error) {
+	seen := make(map[string]bool)
+	tracks := make([]models.Track, 0, len(data.Tracks))
+	for _, t := range data.Tracks {
+		t.Name = strings.TrimSpace(t.Name)
+		if t.Name == "" || !utf8.ValidString(t.Name) {
+			continue
+		}
+		var artists []string
+		for _, a := range t.Artists {
+			if a = strings.TrimSpace(a); a != "" {
+				artists = append(artists, a)
+			}
+		}
+		t.Artists = artists
+
+		switch {
+		case t.ID == "":
+			t.ID = localID(t)
+		case !strings.HasPrefix(t.ID, localPrefix):
+			t.ID = localPrefix + t.ID
+		}
+		if seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+		tracks = append(tracks, t)
+	}
+
+	if len(tracks) == 0 {
+		return models.PlaylistData{}, ErrNoTracks
+	}
+	if len(tracks) > MaxTracks {
+		return models.PlaylistData{}, ErrTooManyTracks
+	}
+	data.Tracks = tracks
+	return data, nil
+}
+
+func localID(t models.Track) string {
+	h := sha1.New()
+	io.WriteString(h, strings.ToLower(t.Name))
+	for _, a := range t.Artists {
+		io.WriteString(h, "\x00"+strings.ToLower(a))
+	}
+	return localPrefix + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func sniffDelimiter(content []byte) rune {
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	best, bestCount := ',', 0
+	for _, d := range []rune{',', ';', '\t'} {
+		if n := bytes.Count(firstLine, []byte(string(d))); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
+
+// headerColumns recognises a header row naming at least the title and artist
+// columns. It returns the title, artist and year column indexes (-1 when
+// absent).
+func headerColumns(record []string) ([3]int, bool) {
+	cols := [3]int{-1, -1, -1}
+	for i, name := range record {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "title", "name", "track", "track name", "song":
+			cols[0] = i
+		case "artist", "artists", "artist name", "artist name(s)", "performer":
+			cols[1] = i
+		case "year", "release year", "release date":
+			cols[2] = i
+		}
+	}
+	return cols, cols[0] >= 0 && cols[1] >= 0
+}
+
+func cell(record []string, col int) string {
+	if col < 0 || col >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[col])
+}
+
+func splitArtists(s string) []string {
+	var artists []string
+	for _, a := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '|' }) {
+		if a = strings.TrimSpace(a); a != "" {
+			artists = append(artists, a)
+		}
+	}
+	return artists
+}
+
+// parseYear accepts a bare year or a date starting with one (1987-06-01).
+func parseYear(s string) (int, error) {
+	if len(s) >= 4 {
+		if year, err := strconv.Atoi(s[:4]); err == nil && (len(s) == 4 || s[4] == '-') {
+			if year >= 1000 && year <= 9999 {
+				return year, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid year %q", s)
+}
+
+func lastUnquotedComma(s string) int {
+	inQuotes := false
+	last := -1
+	for i, c := range s {
+		switch c {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				last = i
+			}
+		}
+	}
+	return last
+}
+
+// fileTitle turns "/music/Artist - Title.mp3" or a URL into "Artist - Title".
+func fileTitle(p string) string {
+	p = strings.ReplaceAll(p, `\`, "/")
+	if i := strings.IndexAny(p, "?#"); i >= 0 && strings.Contains(p, "://") {
+		p = p[:i]
+	}
+	base := path.Base(p)
+	return strings.TrimSpace(strings.TrimSuffix(base, path.Ext(base)))
+}
+
+// splitDisplayTitle splits "Artist - Title" into its parts. Without a
+// separator the whole string is the title.
+func splitDisplayTitle(s string) ([]string, string) {
+	artist, title, ok := strings.Cut(s, " - ")
+	if !ok {
+		return nil, strings.TrimSpace(s)
+	}
+	return splitArtists(artist), strings.TrimSpace(title)
+}
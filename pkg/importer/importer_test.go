@@ -0,0 +1,160 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   Format
+		filename string
+		input    string
+		want     []models.Track
+		wantName string
+	}{
+		{
+			name:     "csv with header",
+			format:   FormatCSV,
+			filename: "party.csv",
+			input:    "Artist,Title,Year\nQueen,Bohemian Rhapsody,1975\nDavid Bowie; Queen,Under Pressure,1981-10-26\n",
+			want: []models.Track{
+				{Name: "Bohemian Rhapsody", Artists: []string{"Queen"}, Year: 1975},
+				{Name: "Under Pressure", Artists: []string{"David Bowie", "Queen"}, Year: 1981},
+			},
+			wantName: "party",
+		},
+		{
+			name:     "csv without header, semicolons",
+			format:   FormatCSV,
+			filename: "list.csv",
+			input:    "\ufeffHey Jude;The Beatles\n\nLet It Be;The Beatles;1970\n",
+			want: []models.Track{
+				{Name: "Hey Jude", Artists: []string{"The Beatles"}},
+				{Name: "Let It Be", Artists: []string{"The Beatles"}, Year: 1970},
+			},
+			wantName: "list",
+		},
+		{
+			name:     "csv quoted commas",
+			format:   FormatCSV,
+			filename: "q.csv",
+			input:    "title,artists\n\"Hello, Goodbye\",The Beatles\n",
+			want:     []models.Track{{Name: "Hello, Goodbye", Artists: []string{"The Beatles"}}},
+			wantName: "q",
+		},
+		{
+			name:     "extended m3u",
+			format:   FormatM3U,
+			filename: "mix.m3u8",
+			input: "#EXTM3U\n#PLAYLIST:Friday Mix\n#EXTINF:354,Queen - Bohemian Rhapsody\n/music/queen/01.mp3\n" +
+				"#EXTINF:-1 tvg-name=\"a,b\",Toto - Africa\nhttp://stream.test/africa.mp3\n",
+			want: []models.Track{
+				{Name: "Bohemian Rhapsody", Artists: []string{"Queen"}},
+				{Name: "Africa", Artists: []string{"Toto"}},
+			},
+			wantName: "Friday Mix",
+		},
+		{
+			name:     "plain m3u uses file names",
+			format:   FormatM3U,
+			filename: "local.m3u",
+			input:    "C:\\Music\\a-ha - Take On Me.mp3\n# comment\n../Untitled.flac\n",
+			want: []models.Track{
+				{Name: "Take On Me", Artists: []string{"a-ha"}},
+				{Name: "Untitled"},
+			},
+			wantName: "local",
+		},
+		{
+			name:     "playlist data json",
+			format:   FormatJSON,
+			filename: "game.json",
+			input:    `{"playlist_name":"Stored","tracks":[{"name":"Song","artists":["Band"],"id":"abc"},{"name":"Song","artists":["Band"],"id":"abc"},{"name":"Other","id":"local:def"}]}`,
+			want:     []models.Track{{Name: "Song", Artists: []string{"Band"}, ID: "local:abc"}, {Name: "Other", ID: "local:def"}},
+			wantName: "Stored",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.format, tt.filename, strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got.PlaylistName != tt.wantName {
+				t.Errorf("name = %q, want %q", got.PlaylistName, tt.wantName)
+			}
+			if len(got.Tracks) != len(tt.want) {
+				t.Fatalf("tracks = %+v, want %+v", got.Tracks, tt.want)
+			}
+			for i, want := range tt.want {
+				track := got.Tracks[i]
+				if track.Name != want.Name || strings.Join(track.Artists, "|") != strings.Join(want.Artists, "|") || track.Year != want.Year {
+					t.Errorf("track %d = %+v, want %+v", i, track, want)
+				}
+				if want.ID != "" && track.ID != want.ID {
+					t.Errorf("track %d ID = %q, want %q", i, track.ID, want.ID)
+				}
+				if !strings.HasPrefix(track.ID, "local:") && want.ID == "" {
+					t.Errorf("track %d ID = %q, want local ID", i, track.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+		want   error
+	}{
+		{"empty csv", FormatCSV, "", ErrNoTracks},
+		{"header only", FormatCSV, "title,artist\n", ErrNoTracks},
+		{"m3u without entries", FormatM3U, "#EXTM3U\n", ErrNoTracks},
+		{"unknown format", Format("xml"), "<a/>", ErrUnknownFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.format, "x", strings.NewReader(tt.input)); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Parse(FormatCSV, "x", strings.NewReader("Song,Band,nineteen\n")); err == nil {
+		t.Error("invalid year: want error")
+	}
+	if _, err := Parse(FormatJSON, "x", strings.NewReader(`{"tracks":`)); err == nil {
+		t.Error("truncated JSON: want error")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		filename, content string
+		want              Format
+	}{
+		{"a.CSV", "", FormatCSV},
+		{"a.m3u8", "", FormatM3U},
+		{"a.json", "", FormatJSON},
+		{"upload", " {\"tracks\":[]}", FormatJSON},
+		{"upload", "#EXTM3U\n", FormatM3U},
+		{"upload", "title,artist\n", FormatCSV},
+	}
+	for _, tt := range tests {
+		got, err := DetectFormat(tt.filename, []byte(tt.content))
+		if err != nil || got != tt.want {
+			t.Errorf("DetectFormat(%q, %q) = %q, %v, want %q", tt.filename, tt.content, got, err, tt.want)
+		}
+	}
+	if _, err := DetectFormat("notes.txt", []byte("hello")); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("plain text error = %v, want ErrUnknownFormat", err)
+	}
+}
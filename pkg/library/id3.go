@@ -0,0 +1,290 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const maxTagSize = 16 << 20
+
+// readMP3 reads an ID3v2 tag from the start of the file, falling back to an
+// ID3v1 tag at the end, and estimates the duration from the audio frames
+// when the tag has no TLEN frame.
+func readMP3(f *os.File, size int64) (Tags, error) {
+	tags, audioStart, err := readID3v2(f)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	if tags.empty() {
+		v1, err := readID3v1(f, size)
+		if err != nil {
+			return Tags{}, err
+		}
+		v1.Duration = tags.Duration
+		tags = v1
+	}
+
+	if tags.Duration == 0 {
+		tags.Duration = mp3Duration(f, audioStart, size)
+	}
+
+	if tags.empty() {
+		return tags, ErrNoTags
+	}
+	return tags, nil
+}
+
+// readID3v2 parses the tag at the start of r and returns the offset where
+// the audio data begins.
+func readID3v2(r io.ReaderAt) (Tags, int64, error) {
+	var header [10]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Tags{}, 0, nil
+		}
+		return Tags{}, 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return Tags{}, 0, nil
+	}
+
+	version := header[3]
+	flags := header[5]
+	size := syncsafe(header[6:10])
+	if version < 2 || version > 4 || size > maxTagSize {
+		return Tags{}, 0, nil
+	}
+
+	end := int64(10 + size)
+	if flags&0x10 != 0 {
+		end += 10 // footer
+	}
+
+	body := make([]byte, size)
+	if _, err := r.ReadAt(body, 10); err != nil && !errors.Is(err, io.EOF) {
+		return Tags{}, 0, err
+	}
+
+	// ID3v2.3 applies unsynchronisation to the whole tag; v2.4 marks it
+	// per frame instead.
+	if flags&0x80 != 0 && version < 4 {
+		body = unsync(body)
+	}
+
+	// Skip the extended header.
+	if flags&0x40 != 0 && version > 2 && len(body) >= 4 {
+		var extSize int
+		if version == 4 {
+			extSize = syncsafe(body[:4])
+		} else {
+			extSize = int(binary.BigEndian.Uint32(body[:4])) + 4
+		}
+		if extSize > len(body) {
+			return Tags{}, end, nil
+		}
+		body = body[extSize:]
+	}
+
+	var tags Tags
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for len(body) >= headerLen {
+		id := string(body[:idLen])
+		if id[0] == 0 {
+			break // padding
+		}
+
+		var frameSize int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		case 4:
+			frameSize = syncsafe(body[4:8])
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		}
+		if frameSize <= 0 || frameSize > len(body)-headerLen {
+			break
+		}
+		data := body[headerLen : headerLen+frameSize]
+		body = body[headerLen+frameSize:]
+
+		// Compressed and encrypted frames are skipped.
+		if version == 3 && frameFlags&0x00C0 != 0 || version == 4 && frameFlags&0x000C != 0 {
+			continue
+		}
+		if version == 4 {
+			if frameFlags&0x0002 != 0 {
+				data = unsync(data)
+			}
+			if frameFlags&0x0001 != 0 && len(data) >= 4 {
+				data = data[4:] // data length indicator
+			}
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = firstText(data)
+		case "TPE1", "TP1":
+			for _, artist := range textValues(data) {
+				tags.addArtist(artist)
+			}
+		case "TALB", "TAL":
+			tags.Album = firstText(data)
+		case "TDRC", "TYER", "TYE", "TDOR", "TORY", "TOR":
+			if tags.Year == 0 {
+				tags.Year = parseYear(firstText(data))
+			}
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(firstText(data)); err == nil && ms > 0 {
+				tags.Duration = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	return tags, end, nil
+}
+
+func readID3v1(r io.ReaderAt, size int64) (Tags, error) {
+	if size < 128 {
+		return Tags{}, nil
+	}
+	var tag [128]byte
+	if _, err := r.ReadAt(tag[:], size-128); err != nil {
+		return Tags{}, err
+	}
+	if string(tag[:3]) != "TAG" {
+		return Tags{}, nil
+	}
+
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(latin1(b))
+	}
+
+	var tags Tags
+	tags.Title = field(tag[3:33])
+	tags.addArtist(field(tag[33:63]))
+	tags.Album = field(tag[63:93])
+	tags.Year = parseYear(field(tag[93:97]))
+	return tags, nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// unsync reverses ID3 unsynchronisation, which inserts a zero byte after
+// every 0xFF.
+func unsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+func firstText(data []byte) string {
+	values := textValues(data)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// textValues decodes a text frame. ID3v2.4 separates multiple values with
+// NUL characters.
+func textValues(data []byte) []string {
+	if len(data) < 2 {
+		return nil
+	}
+
+	var text string
+	switch data[0] {
+	case 0:
+		text = latin1(data[1:])
+	case 1:
+		text = decodeUTF16(data[1:], nil)
+	case 2:
+		text = decodeUTF16(data[1:], binary.BigEndian)
+	case 3:
+		text = string(data[1:])
+	default:
+		return nil
+	}
+
+	var values []string
+	for _, v := range strings.Split(text, "\x00") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// decodeUTF16 decodes UTF-16 text. With order nil each NUL separated value
+// starts with its own byte order mark.
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	var out []rune
+	bom := order == nil
+	if bom {
+		order = binary.LittleEndian
+	}
+	start := true
+	for i := 0; i+1 < len(b); i += 2 {
+		if bom && start {
+			switch {
+			case b[i] == 0xff && b[i+1] == 0xfe:
+				order = binary.LittleEndian
+				start = false
+				continue
+			case b[i] == 0xfe && b[i+1] == 0xff:
+				order = binary.BigEndian
+				start = false
+				continue
+			}
+		}
+		start = false
+
+		u := order.Uint16(b[i:])
+		if u == 0 {
+			out = append(out, 0)
+			start = true
+			continue
+		}
+		if utf16.IsSurrogate(rune(u)) && i+3 < len(b) {
+			out = append(out, utf16.DecodeRune(rune(u), rune(order.Uint16(b[i+2:]))))
+			i += 2
+			continue
+		}
+		out = append(out, rune(u))
+	}
+	return string(out)
+}
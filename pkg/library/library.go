@@ -0,0 +1,195 @@
+// Package library reads track metadata from a folder of local music files so
+// DJs playing from their own collection can build games from it. Tags are
+// parsed in pure Go: ID3v2/ID3v1 for MP3, Vorbis comments for FLAC and
+// Ogg, and iTunes-style atoms for MP4/M4A.
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/importer"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// Tags is the metadata read from a single file.
+type Tags struct {
+	Title    string
+	Artists  []string
+	Album    string
+	Year     int
+	Duration time.Duration
+}
+
+var (
+	ErrUnsupported = errors.New("library: unsupported file type")
+	ErrNoTags      = errors.New("library: no tags found")
+)
+
+type readerFunc func(f *os.File, size int64) (Tags, error)
+
+var readers = map[string]readerFunc{
+	".mp3":  readMP3,
+	".flac": readFLAC,
+	".ogg":  readOgg,
+	".oga":  readOgg,
+	".opus": readOgg,
+	".m4a":  readMP4,
+	".mp4":  readMP4,
+	".m4b":  readMP4,
+}
+
+// Supported reports whether path has an extension the library can read.
+func Supported(path string) bool {
+	_, ok := readers[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// ReadFile reads the tags of a single audio file.
+func ReadFile(path string) (Tags, error) {
+	read, ok := readers[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return Tags{}, ErrUnsupported
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Tags{}, err
+	}
+	return read(f, info.Size())
+}
+
+// ScanError records a file that could not be read during a Scan.
+type ScanError struct {
+	Path string
+	Err  error
+}
+
+func (e *ScanError) Error() string { return e.Path + ": " + e.Err.Error() }
+func (e *ScanError) Unwrap() error { return e.Err }
+
+// Scan walks root and returns every supported audio file as a track. Files
+// without a title tag fall back to their file name ("Artist - Title.mp3").
+// Unreadable files are skipped and reported in the returned slice; only a
+// failure to walk root itself is returned as an error. Track paths are
+// relative to root.
+func Scan(root string) (models.PlaylistData, []*ScanError, error) {
+	var tracks []models.Track
+	var skipped []*ScanError
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			skipped = append(skipped, &ScanError{Path: path, Err: err})
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != root {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !Supported(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			rel = path
+		}
+
+		tags, err := ReadFile(path)
+		if err != nil && !errors.Is(err, ErrNoTags) {
+			skipped = append(skipped, &ScanError{Path: rel, Err: err})
+			return nil
+		}
+		tracks = append(tracks, tags.track(filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return models.PlaylistData{}, skipped, fmt.Errorf("library: scanning %s: %w", root, err)
+	}
+
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].Path < tracks[j].Path })
+
+	name := filepath.Base(filepath.Clean(root))
+	data, err := importer.Normalize(models.PlaylistData{PlaylistName: name, Tracks: tracks})
+	if err != nil {
+		return models.PlaylistData{}, skipped, err
+	}
+	return data, skipped, nil
+}
+
+func (t Tags) track(path string) models.Track {
+	title, artists := t.Title, t.Artists
+	if title == "" {
+		base := filepath.Base(path)
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+		if artist, rest, ok := strings.Cut(base, " - "); ok && len(artists) == 0 {
+			artists = []string{strings.TrimSpace(artist)}
+			base = rest
+		}
+		title = strings.TrimSpace(base)
+	}
+
+	return models.Track{
+		Name:       title,
+		Artists:    artists,
+		Year:       t.Year,
+		Album:      strings.TrimSpace(t.Album),
+		DurationMS: int(t.Duration / time.Millisecond),
+		Path:       path,
+	}
+}
+
+// addArtist appends a non-empty, not yet seen artist name.
+func (t *Tags) addArtist(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	for _, a := range t.Artists {
+		if a == name {
+			return
+		}
+	}
+	t.Artists = append(t.Artists, name)
+}
+
+func (t Tags) empty() bool {
+	return t.Title == "" && len(t.Artists) == 0 && t.Album == "" && t.Year == 0
+}
+
+// parseYear reads the year from the start of a date such as "1987",
+// "1987-06-01" or "1987-06-01T00:00:00Z".
+func parseYear(s string) int {
+	s = strings.TrimSpace(s)
+	if len(s) < 4 {
+		return 0
+	}
+	year := 0
+	for _, c := range s[:4] {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		year = year*10 + int(c-'0')
+	}
+	if len(s) > 4 && s[4] >= '0' && s[4] <= '9' {
+		return 0
+	}
+	return year
+}
@@ -0,0 +1,331 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// id3Frame builds an ID3v2.3/2.4 text frame. enc 1 is UTF-16 with BOM, 3 is
+// UTF-8.
+func id3Frame(version int, id string, enc byte, values ...string) []byte {
+	var text []byte
+	for i, v := range values {
+		if i > 0 {
+			if enc == 1 {
+				text = append(text, 0, 0)
+			} else {
+				text = append(text, 0)
+			}
+		}
+		if enc == 1 {
+			text = append(text, 0xff, 0xfe)
+			for _, u := range utf16.Encode([]rune(v)) {
+				text = binary.LittleEndian.AppendUint16(text, u)
+			}
+		} else {
+			text = append(text, v...)
+		}
+	}
+	data := append([]byte{enc}, text...)
+
+	frame := []byte(id)
+	if version == 4 {
+		frame = append(frame, syncsafeBytes(len(data))...)
+	} else {
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+	}
+	frame = append(frame, 0, 0)
+	return append(frame, data...)
+}
+
+func id3Tag(version int, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, 32)...) // padding
+	tag := []byte{'I', 'D', '3', byte(version), 0, 0}
+	return append(append(tag, syncsafeBytes(len(body))...), body...)
+}
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// mp3Frames returns n MPEG-1 Layer III frames at 128 kbit/s, 44.1 kHz. With
+// xingFrames > 0 the first frame carries a Xing header.
+func mp3Frames(n, xingFrames int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	var out []byte
+	for i := range n {
+		f := slices.Clone(frame)
+		if i == 0 && xingFrames > 0 {
+			copy(f[36:], "Xing")
+			binary.BigEndian.PutUint32(f[40:], 1)
+			binary.BigEndian.PutUint32(f[44:], uint32(xingFrames))
+		}
+		out = append(out, f...)
+	}
+	return out
+}
+
+func vorbisComment(entries ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 4)
+	b = append(b, "test"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(entries)))
+	for _, e := range entries {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(e)))
+		b = append(b, e...)
+	}
+	return b
+}
+
+func flacFile(sampleRate, samples int, comments ...string) []byte {
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate<<4) | 0x02 // two channels
+	info[13] = 0xf0 | byte(samples>>32&0x0f)
+	binary.BigEndian.PutUint32(info[14:], uint32(samples))
+
+	block := func(typ byte, last bool, data []byte) []byte {
+		if last {
+			typ |= 0x80
+		}
+		return append([]byte{typ, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+	}
+
+	out := []byte("fLaC")
+	out = append(out, block(0, false, info)...)
+	out = append(out, block(4, true, vorbisComment(comments...))...)
+	return append(out, make([]byte, 64)...) // audio frames
+}
+
+func oggPage(granule int64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		for n := len(p); ; n -= 255 {
+			if n < 255 {
+				lacing = append(lacing, byte(n))
+				break
+			}
+			lacing = append(lacing, 255)
+		}
+		body = append(body, p...)
+	}
+	page := []byte("OggS\x00\x00")
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, 42) // serial
+	page = append(page, make([]byte, 8)...)           // sequence and CRC
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, body...)
+}
+
+func oggVorbisFile(sampleRate int, samples int64, comments ...string) []byte {
+	ident := []byte("\x01vorbis")
+	ident = binary.LittleEndian.AppendUint32(ident, 0)
+	ident = append(ident, 2)
+	ident = binary.LittleEndian.AppendUint32(ident, uint32(sampleRate))
+	ident = append(ident, make([]byte, 14)...)
+
+	comment := append([]byte("\x03vorbis"), vorbisComment(comments...)...)
+	comment = append(comment, 1)
+
+	out := oggPage(0, ident)
+	out = append(out, oggPage(0, comment, make([]byte, 300))...)
+	return append(out, oggPage(samples, make([]byte, 100))...)
+}
+
+func mp4Atom(typ string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), append([]byte(typ), body...)...)
+}
+
+func mp4Text(typ, value string) []byte {
+	data := binary.BigEndian.AppendUint32(nil, 1)
+	data = append(data, 0, 0, 0, 0)
+	return mp4Atom(typ, mp4Atom("data", append(data, value...)))
+}
+
+func mp4File(timescale, duration uint32, items ...[]byte) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+
+	meta := append([]byte{0, 0, 0, 0}, mp4Atom("hdlr", make([]byte, 25))...)
+	meta = append(meta, mp4Atom("ilst", items...)...)
+
+	out := mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00M4A "))
+	out = append(out, mp4Atom("mdat", make([]byte, 256))...)
+	return append(out, mp4Atom("moov", mp4Atom("mvhd", mvhd), mp4Atom("udta", mp4Atom("meta", meta)))...)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAG")
+	copy(id3v1[3:], "Take On Me")
+	copy(id3v1[33:], "a-ha")
+	copy(id3v1[93:], "1985")
+
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		want Tags
+	}{
+		{
+			name: "id3v2.3 utf-16 with xing header",
+			file: "a.mp3",
+			data: append(id3Tag(3,
+				id3Frame(3, "TIT2", 1, "Björk's Song"),
+				id3Frame(3, "TPE1", 1, "Björk"),
+				id3Frame(3, "TALB", 0, "Debut"),
+				id3Frame(3, "TYER", 0, "1993"),
+			), mp3Frames(3, 1000)...),
+			want: Tags{Title: "Björk's Song", Artists: []string{"Björk"}, Album: "Debut", Year: 1993,
+				Duration: 1000 * 1152 * time.Second / 44100},
+		},
+		{
+			name: "id3v2.4 utf-8 multiple artists, TLEN and cbr frames",
+			file: "b.mp3",
+			data: append(id3Tag(4,
+				id3Frame(4, "TIT2", 3, "Under Pressure"),
+				id3Frame(4, "TPE1", 3, "Queen", "David Bowie"),
+				id3Frame(4, "TDRC", 3, "1981-10-26"),
+				id3Frame(4, "TLEN", 3, "248000"),
+			), mp3Frames(10, 0)...),
+			want: Tags{Title: "Under Pressure", Artists: []string{"Queen", "David Bowie"}, Year: 1981,
+				Duration: 248 * time.Second},
+		},
+		{
+			name: "id3v1 only",
+			file: "c.mp3",
+			data: append(mp3Frames(10, 0), id3v1...),
+			want: Tags{Title: "Take On Me", Artists: []string{"a-ha"}, Year: 1985,
+				Duration: 4298 * 8 * time.Second / 128000},
+		},
+		{
+			name: "flac",
+			file: "d.flac",
+			data: flacFile(44100, 44100*185, "TITLE=Africa", "ARTIST=Toto", "DATE=1982-04-08", "ALBUM=Toto IV"),
+			want: Tags{Title: "Africa", Artists: []string{"Toto"}, Album: "Toto IV", Year: 1982, Duration: 185 * time.Second},
+		},
+		{
+			name: "ogg vorbis",
+			file: "e.ogg",
+			data: oggVorbisFile(48000, 48000*200, "title=Hey Jude", "artist=The Beatles", "artist=Billy Preston", "year=1968"),
+			want: Tags{Title: "Hey Jude", Artists: []string{"The Beatles", "Billy Preston"}, Year: 1968, Duration: 200 * time.Second},
+		},
+		{
+			name: "mp4",
+			file: "f.m4a",
+			data: mp4File(1000, 213500,
+				mp4Text("\xa9nam", "Hallelujah"),
+				mp4Text("\xa9ART", "Jeff Buckley"),
+				mp4Text("\xa9alb", "Grace"),
+				mp4Text("\xa9day", "1994-08-23T07:00:00Z"),
+			),
+			want: Tags{Title: "Hallelujah", Artists: []string{"Jeff Buckley"}, Album: "Grace", Year: 1994, Duration: 213500 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			writeFile(t, path, tt.data)
+
+			got, err := ReadFile(path)
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if got.Title != tt.want.Title || got.Album != tt.want.Album || got.Year != tt.want.Year ||
+				!slices.Equal(got.Artists, tt.want.Artists) || got.Duration != tt.want.Duration {
+				t.Errorf("ReadFile = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "notes.txt"), []byte("hello"))
+	if _, err := ReadFile(filepath.Join(dir, "notes.txt")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("txt error = %v, want ErrUnsupported", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "untagged.mp3"), mp3Frames(2, 0))
+	if _, err := ReadFile(filepath.Join(dir, "untagged.mp3")); !errors.Is(err, ErrNoTags) {
+		t.Errorf("untagged error = %v, want ErrNoTags", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "broken.flac"), []byte("not flac at all"))
+	if _, err := ReadFile(filepath.Join(dir, "broken.flac")); err == nil {
+		t.Error("broken flac: want error")
+	}
+}
+
+func TestScan(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Friday Set")
+	writeFile(t, filepath.Join(root, "80s", "africa.flac"),
+		flacFile(44100, 44100*10, "TITLE=Africa", "ARTIST=Toto", "DATE=1982"))
+	writeFile(t, filepath.Join(root, "80s", "a-ha - Take On Me.mp3"), mp3Frames(5, 0))
+	writeFile(t, filepath.Join(root, "90s", "grace.m4a"),
+		mp4File(1000, 5000, mp4Text("\xa9nam", "Hallelujah"), mp4Text("\xa9ART", "Jeff Buckley")))
+	writeFile(t, filepath.Join(root, "90s", "copy of grace.m4a"),
+		mp4File(1000, 5000, mp4Text("\xa9nam", "Hallelujah"), mp4Text("\xa9ART", "Jeff Buckley")))
+	writeFile(t, filepath.Join(root, "broken.flac"), []byte("junk"))
+	writeFile(t, filepath.Join(root, "cover.jpg"), []byte("jpeg"))
+	writeFile(t, filepath.Join(root, ".trash", "old.mp3"), mp3Frames(5, 0))
+
+	data, skipped, err := Scan(root)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	if data.PlaylistName != "Friday Set" {
+		t.Errorf("name = %q", data.PlaylistName)
+	}
+	if len(skipped) != 1 || skipped[0].Path != "broken.flac" {
+		t.Errorf("skipped = %v, want broken.flac", skipped)
+	}
+
+	type summary struct {
+		path, name, artists string
+		year, ms            int
+	}
+	var got []summary
+	for _, tr := range data.Tracks {
+		got = append(got, summary{tr.Path, tr.Name, strings.Join(tr.Artists, ", "), tr.Year, tr.DurationMS})
+		if tr.ID == "" {
+			t.Errorf("track %q has no ID", tr.Name)
+		}
+	}
+	want := []summary{
+		{"80s/a-ha - Take On Me.mp3", "Take On Me", "a-ha", 0, 130},
+		{"80s/africa.flac", "Africa", "Toto", 1982, 10000},
+		{"90s/copy of grace.m4a", "Hallelujah", "Jeff Buckley", 0, 5000},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("tracks =\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package library
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2/2.5
+	}
+	mp3SampleRates = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+type mp3Frame struct {
+	mpeg1      bool
+	mono       bool
+	bitrate    int // kbit/s
+	sampleRate int
+}
+
+func (fr mp3Frame) samples() int {
+	if fr.mpeg1 {
+		return 1152
+	}
+	return 576
+}
+
+// parseMP3Frame decodes a Layer III frame header.
+func parseMP3Frame(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version := (h[1] >> 3) & 0x03
+	layer := (h[1] >> 1) & 0x03
+	rates, ok := mp3SampleRates[version]
+	if !ok || layer != 1 {
+		return mp3Frame{}, false
+	}
+	bitrateIdx := h[2] >> 4
+	rateIdx := (h[2] >> 2) & 0x03
+	if bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return mp3Frame{}, false
+	}
+
+	fr := mp3Frame{
+		mpeg1:      version == 3,
+		mono:       h[3]>>6 == 3,
+		sampleRate: rates[rateIdx],
+	}
+	table := 1
+	if fr.mpeg1 {
+		table = 0
+	}
+	fr.bitrate = mp3Bitrates[table][bitrateIdx]
+	return fr, true
+}
+
+// mp3Duration finds the first audio frame after start. VBR files carry a
+// Xing/Info header with the frame count; otherwise the file is assumed to
+// be constant bitrate.
+func mp3Duration(r io.ReaderAt, start, size int64) time.Duration {
+	buf := make([]byte, 64<<10)
+	n, _ := r.ReadAt(buf, start)
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		fr, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+
+		// Side information follows the 4 byte header.
+		xing := i + 4
+		switch {
+		case fr.mpeg1 && !fr.mono:
+			xing += 32
+		case fr.mpeg1, !fr.mono:
+			xing += 17
+		default:
+			xing += 9
+		}
+		if xing+12 <= len(buf) {
+			id := string(buf[xing : xing+4])
+			flags := binary.BigEndian.Uint32(buf[xing+4:])
+			if (id == "Xing" || id == "Info") && flags&1 != 0 {
+				frames := int64(binary.BigEndian.Uint32(buf[xing+8:]))
+				return time.Duration(frames*int64(fr.samples())) * time.Second / time.Duration(fr.sampleRate)
+			}
+		}
+
+		audio := size - start - int64(i)
+		if audio <= 0 {
+			return 0
+		}
+		return time.Duration(audio*8) * time.Second / time.Duration(fr.bitrate*1000)
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package library
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type atom struct {
+	typ  string
+	data []byte // contents after the header
+}
+
+// readMP4 reads the iTunes metadata list (moov/udta/meta/ilst) and the
+// movie header duration of an MP4/M4A file.
+func readMP4(f *os.File, size int64) (Tags, error) {
+	moov, err := findTopLevelAtom(f, size, "moov")
+	if err != nil {
+		return Tags{}, err
+	}
+
+	var tags Tags
+	for _, a := range childAtoms(moov) {
+		switch a.typ {
+		case "mvhd":
+			tags.Duration = mvhdDuration(a.data)
+		case "udta":
+			for _, u := range childAtoms(a.data) {
+				if u.typ == "meta" {
+					readMeta(u.data, &tags)
+				}
+			}
+		case "meta":
+			readMeta(a.data, &tags)
+		}
+	}
+
+	if tags.empty() {
+		return tags, ErrNoTags
+	}
+	return tags, nil
+}
+
+// findTopLevelAtom reads the named atom without loading the others, which
+// matters because mdat holds the whole audio stream.
+func findTopLevelAtom(f *os.File, size int64, typ string) ([]byte, error) {
+	var offset int64
+	for offset+8 <= size {
+		var header [16]byte
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("library: reading MP4 atom: %w", err)
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		name := string(header[4:8])
+		headerLen := int64(8)
+		switch length {
+		case 0:
+			length = size - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			length = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if length < headerLen || offset+length > size {
+			return nil, errors.New("library: invalid MP4 atom size")
+		}
+
+		if offset == 0 && name != "ftyp" {
+			return nil, errors.New("library: not an MP4 file")
+		}
+		if name == typ {
+			if length-headerLen > maxBlockSize {
+				return nil, errors.New("library: MP4 metadata too large")
+			}
+			data := make([]byte, length-headerLen)
+			if _, err := f.ReadAt(data, offset+headerLen); err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+		offset += length
+	}
+	return nil, ErrNoTags
+}
+
+// childAtoms splits b into atoms, stopping at the first malformed one.
+func childAtoms(b []byte) []atom {
+	var atoms []atom
+	for len(b) >= 8 {
+		length := int(binary.BigEndian.Uint32(b[:4]))
+		if length == 0 {
+			length = len(b)
+		}
+		if length < 8 || length > len(b) {
+			break
+		}
+		atoms = append(atoms, atom{typ: string(b[4:8]), data: b[8:length]})
+		b = b[length:]
+	}
+	return atoms
+}
+
+func readMeta(b []byte, tags *Tags) {
+	// meta is a full box with a version/flags word, except in some
+	// QuickTime files where the handler atom follows immediately.
+	if len(b) >= 8 && string(b[4:8]) != "hdlr" {
+		b = b[4:]
+	}
+	for _, a := range childAtoms(b) {
+		if a.typ != "ilst" {
+			continue
+		}
+		for _, item := range childAtoms(a.data) {
+			value, ok := ilstText(item.data)
+			if !ok {
+				continue
+			}
+			switch item.typ {
+			case "\xa9nam":
+				tags.Title = value
+			case "\xa9ART":
+				tags.addArtist(value)
+			case "aART":
+				if len(tags.Artists) == 0 {
+					tags.addArtist(value)
+				}
+			case "\xa9alb":
+				tags.Album = value
+			case "\xa9day":
+				tags.Year = parseYear(value)
+			}
+		}
+	}
+}
+
+// ilstText returns the UTF-8 value of an item's data atom.
+func ilstText(b []byte) (string, bool) {
+	for _, a := range childAtoms(b) {
+		if a.typ != "data" || len(a.data) < 8 {
+			continue
+		}
+		// Type indicator 1 is UTF-8 text.
+		if binary.BigEndian.Uint32(a.data[:4])&0xffffff != 1 {
+			return "", false
+		}
+		return string(a.data[8:]), true
+	}
+	return "", false
+}
+
+func mvhdDuration(b []byte) time.Duration {
+	if len(b) < 1 {
+		return 0
+	}
+	var timescale, duration uint64
+	switch b[0] {
+	case 0:
+		if len(b) < 20 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	case 1:
+		if len(b) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(duration) * time.Second / time.Duration(timescale)
+}
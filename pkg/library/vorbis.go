@@ -0,0 +1,275 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const maxBlockSize = 16 << 20
+
+// readFLAC walks the metadata blocks of a FLAC file for STREAMINFO and the
+// Vorbis comment block.
+func readFLAC(f *os.File, size int64) (Tags, error) {
+	// Some taggers put an ID3v2 tag in front of the stream marker.
+	_, offset, err := readID3v2(f)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	var marker [4]byte
+	if _, err := f.ReadAt(marker[:], offset); err != nil {
+		return Tags{}, fmt.Errorf("library: reading FLAC marker: %w", err)
+	}
+	if string(marker[:]) != "fLaC" {
+		return Tags{}, errors.New("library: not a FLAC file")
+	}
+	offset += 4
+
+	var tags Tags
+	for {
+		var header [4]byte
+		if _, err := f.ReadAt(header[:], offset); err != nil {
+			return Tags{}, fmt.Errorf("library: reading FLAC block: %w", err)
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4
+		if offset+length > size {
+			return Tags{}, errors.New("library: truncated FLAC metadata")
+		}
+
+		switch blockType {
+		case 0: // STREAMINFO
+			block := make([]byte, length)
+			if _, err := f.ReadAt(block, offset); err != nil {
+				return Tags{}, err
+			}
+			if len(block) >= 18 {
+				rate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				if rate > 0 {
+					tags.Duration = time.Duration(samples) * time.Second / time.Duration(rate)
+				}
+			}
+		case 4: // VORBIS_COMMENT
+			if length > maxBlockSize {
+				return Tags{}, errors.New("library: FLAC comment block too large")
+			}
+			block := make([]byte, length)
+			if _, err := f.ReadAt(block, offset); err != nil {
+				return Tags{}, err
+			}
+			if err := parseVorbisComment(block, &tags); err != nil {
+				return Tags{}, err
+			}
+		}
+
+		offset += length
+		if last {
+			break
+		}
+	}
+
+	if tags.empty() {
+		return tags, ErrNoTags
+	}
+	return tags, nil
+}
+
+// readOgg reads the identification and comment headers of the first
+// logical stream in an Ogg Vorbis or Opus file, and the duration from the
+// granule position of its last page.
+func readOgg(f *os.File, size int64) (Tags, error) {
+	r := &oggReader{r: io.NewSectionReader(f, 0, size)}
+
+	ident, err := r.packet()
+	if err != nil {
+		return Tags{}, err
+	}
+	comment, err := r.packet()
+	if err != nil {
+		return Tags{}, err
+	}
+
+	var rate, preSkip int64
+	var tags Tags
+	switch {
+	case bytes.HasPrefix(ident, []byte("\x01vorbis")) && len(ident) >= 16:
+		rate = int64(binary.LittleEndian.Uint32(ident[12:16]))
+		if !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			return Tags{}, errors.New("library: missing Vorbis comment header")
+		}
+		err = parseVorbisComment(comment[7:], &tags)
+	case bytes.HasPrefix(ident, []byte("OpusHead")) && len(ident) >= 12:
+		// Opus granule positions always count 48 kHz samples.
+		rate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+		if !bytes.HasPrefix(comment, []byte("OpusTags")) {
+			return Tags{}, errors.New("library: missing Opus tags header")
+		}
+		err = parseVorbisComment(comment[8:], &tags)
+	default:
+		return Tags{}, errors.New("library: unsupported Ogg codec")
+	}
+	if err != nil {
+		return Tags{}, err
+	}
+
+	if granule := lastGranule(f, size, r.serial); granule > preSkip && rate > 0 {
+		tags.Duration = time.Duration(granule-preSkip) * time.Second / time.Duration(rate)
+	}
+
+	if tags.empty() {
+		return tags, ErrNoTags
+	}
+	return tags, nil
+}
+
+type oggReader struct {
+	r       io.Reader
+	serial  uint32
+	started bool
+	pending [][]byte // completed packets not yet returned
+	partial []byte
+}
+
+// packet returns the next complete packet of the first logical stream.
+func (o *oggReader) packet() ([]byte, error) {
+	for len(o.pending) == 0 {
+		var header [27]byte
+		if _, err := io.ReadFull(o.r, header[:]); err != nil {
+			return nil, fmt.Errorf("library: reading Ogg page: %w", err)
+		}
+		if string(header[:4]) != "OggS" {
+			return nil, errors.New("library: not an Ogg file")
+		}
+		serial := binary.LittleEndian.Uint32(header[14:18])
+		if !o.started {
+			o.serial = serial
+			o.started = true
+		}
+
+		lacing := make([]byte, header[26])
+		if _, err := io.ReadFull(o.r, lacing); err != nil {
+			return nil, err
+		}
+		total := 0
+		for _, l := range lacing {
+			total += int(l)
+		}
+		body := make([]byte, total)
+		if _, err := io.ReadFull(o.r, body); err != nil {
+			return nil, err
+		}
+		if serial != o.serial {
+			continue
+		}
+
+		for _, l := range lacing {
+			o.partial = append(o.partial, body[:l]...)
+			body = body[l:]
+			if l < 255 {
+				o.pending = append(o.pending, o.partial)
+				o.partial = nil
+			}
+			if len(o.partial) > maxBlockSize {
+				return nil, errors.New("library: Ogg packet too large")
+			}
+		}
+	}
+
+	p := o.pending[0]
+	o.pending = o.pending[1:]
+	return p, nil
+}
+
+// lastGranule finds the granule position of the last page of the stream.
+func lastGranule(r io.ReaderAt, size int64, serial uint32) int64 {
+	const window = 64 << 10
+	start := max(size-window, 0)
+	buf := make([]byte, size-start)
+	n, _ := r.ReadAt(buf, start)
+	buf = buf[:n]
+
+	for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+		if i+27 > len(buf) || binary.LittleEndian.Uint32(buf[i+14:]) != serial {
+			continue
+		}
+		if granule := int64(binary.LittleEndian.Uint64(buf[i+6:])); granule >= 0 {
+			return granule
+		}
+	}
+	return 0
+}
+
+// parseVorbisComment reads a comment block: a vendor string followed by
+// KEY=value entries, all length-prefixed little endian.
+func parseVorbisComment(b []byte, tags *Tags) error {
+	errShort := errors.New("library: truncated Vorbis comment")
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+
+	if _, ok := next(); !ok { // vendor
+		return errShort
+	}
+	if len(b) < 4 {
+		return errShort
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+
+	var date, year string
+	for range count {
+		entry, ok := next()
+		if !ok {
+			return errShort
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			if tags.Title == "" {
+				tags.Title = value
+			}
+		case "ARTIST":
+			tags.addArtist(value)
+		case "ALBUM":
+			if tags.Album == "" {
+				tags.Album = value
+			}
+		case "DATE", "ORIGINALDATE":
+			if date == "" {
+				date = value
+			}
+		case "YEAR", "ORIGINALYEAR":
+			if year == "" {
+				year = value
+			}
+		}
+	}
+
+	if tags.Year = parseYear(date); tags.Year == 0 {
+		tags.Year = parseYear(year)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package metrics
+
+// Application metrics exposed on /metrics.
+var (
+	GamesCreated = NewCounterVec("bingo_games_created_total",
+		"Games created, by plate content type.", "content_type")
+
+	GameJoins = NewCounterVec("bingo_game_joins_total",
+		"Players seated in a game for the first time.")
+
+	GameFullRejections = NewCounterVec("bingo_game_full_rejections_total",
+		"Join attempts rejected because every player slot was taken.")
+
+	PlateGenerationDuration = NewHistogramVec("bingo_plate_generation_duration_seconds",
+		"Time spent generating all plates for a game.", nil)
+
+	SpotifyRequestDuration = NewHistogramVec("bingo_spotify_request_duration_seconds",
+		"Latency of Spotify API calls, by endpoint and HTTP status.", nil, "endpoint", "status")
+
+	SourceCacheLookups = NewCounterVec("bingo_source_cache_lookups_total",
+		"Playlist snapshot cache lookups, by result (hit or miss).", "result")
+
+	SSEConnections = NewGauge("bingo_sse_connections_active",
+		"Currently open server-sent event streams.")
+)
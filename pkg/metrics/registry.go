@@ -0,0 +1,323 @@
+// Package metrics is a small, dependency-free implementation of Prometheus
+// counters, gauges and histograms with a text exposition handler.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets suits request latencies from a few milliseconds up to the
+// multi-second playlist fetches.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and renders them in the Prometheus text
+// format.
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+var defaultRegistry = NewRegistry()
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.mu.Lock()
+		collectors := append([]collector(nil), r.collectors...)
+		r.mu.Unlock()
+		for _, c := range collectors {
+			c.write(bw)
+		}
+		bw.Flush()
+	})
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return defaultRegistry.Handler()
+}
+
+// vec maps label value tuples to series of type T.
+type vec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.RWMutex
+	series map[string]*T
+	newT   func() *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = v.newT()
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values so scrapes are stable.
+func (v *vec[T]) sorted() ([][]string, []*T) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	labelValues := make([][]string, len(keys))
+	series := make([]*T, len(keys))
+	for i, k := range keys {
+		if len(v.labels) > 0 {
+			labelValues[i] = strings.Split(k, "\xff")
+		}
+		series[i] = v.series[k]
+	}
+	return labelValues, series
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+func newVec[T any](name, help, kind string, labels []string, newT func() *T) *vec[T] {
+	return &vec[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*T),
+		newT:   newT,
+	}
+}
+
+// atomicFloat is a float64 updated with compare-and-swap.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if f.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels.
+type CounterVec struct {
+	v *vec[atomicFloat]
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", labels, func() *atomicFloat { return &atomicFloat{} })}
+	if len(labels) == 0 {
+		// Unlabelled series exist from the start so scrapes see a zero.
+		c.v.with(nil)
+	}
+	defaultRegistry.register(name, c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.v.name + " cannot decrease")
+	}
+	c.v.with(labelValues).Add(delta)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.v.writeHeader(w)
+	labelValues, series := c.v.sorted()
+	for i, s := range series {
+		writeSample(w, c.v.name, c.v.labels, labelValues[i], "", "", s.Load())
+	}
+}
+
+// Gauge is a single value that can go up and down.
+type Gauge struct {
+	name  string
+	help  string
+	value atomicFloat
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	defaultRegistry.register(name, g)
+	return g
+}
+
+func (g *Gauge) Inc()              { g.value.Add(1) }
+func (g *Gauge) Dec()              { g.value.Add(-1) }
+func (g *Gauge) Add(delta float64) { g.value.Add(delta) }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, escapeHelp(g.help), g.name)
+	writeSample(w, g.name, nil, nil, "", "", g.value.Load())
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec tracks value distributions partitioned by labels.
+type HistogramVec struct {
+	v       *vec[histogram]
+	buckets []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.v = newVec(name, help, "histogram", labels, func() *histogram {
+		return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})
+	if len(labels) == 0 {
+		h.v.with(nil)
+	}
+	defaultRegistry.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.v.with(labelValues).observe(v)
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.v.writeHeader(w)
+	labelValues, series := h.v.sorted()
+	for i, s := range series {
+		s.mu.Lock()
+		for j, upper := range s.buckets {
+			writeSample(w, h.v.name+"_bucket", h.v.labels, labelValues[i], "le", formatFloat(upper), float64(s.counts[j]))
+		}
+		writeSample(w, h.v.name+"_bucket", h.v.labels, labelValues[i], "le", "+Inf", float64(s.count))
+		writeSample(w, h.v.name+"_sum", h.v.labels, labelValues[i], "", "", s.sum)
+		writeSample(w, h.v.name+"_count", h.v.labels, labelValues[i], "", "", float64(s.count))
+		s.mu.Unlock()
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type = %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestHandlerExposition(t *testing.T) {
+	requests := NewCounterVec("test_requests_total", "Requests served,\nby path.", "path", "status")
+	requests.Inc("/a\"b\\c\nd", "200")
+	requests.Add(2, "/plain", "500")
+
+	NewCounterVec("test_unlabelled_total", "Never incremented.")
+
+	open := NewGauge("test_open", "Open things.")
+	open.Inc()
+	open.Inc()
+	open.Dec()
+
+	latency := NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+	latency.Observe(0.05, "x")
+	latency.Observe(0.5, "x")
+	latency.Observe(3, "x")
+
+	body := scrape(t)
+	for _, want := range []string{
+		"# HELP test_requests_total Requests served,\\nby path.\n# TYPE test_requests_total counter\n",
+		`test_requests_total{path="/a\"b\\c\nd",status="200"} 1` + "\n",
+		`test_requests_total{path="/plain",status="500"} 2` + "\n",
+		"# TYPE test_unlabelled_total counter\ntest_unlabelled_total 0\n",
+		"# HELP test_open Open things.\n# TYPE test_open gauge\ntest_open 1\n",
+		"# TYPE test_latency_seconds histogram\n",
+		// Buckets are sorted and cumulative, ending in +Inf.
+		`test_latency_seconds_bucket{route="x",le="0.1"} 1` + "\n" +
+			`test_latency_seconds_bucket{route="x",le="1"} 2` + "\n" +
+			`test_latency_seconds_bucket{route="x",le="+Inf"} 3` + "\n" +
+			`test_latency_seconds_sum{route="x"} 3.55` + "\n" +
+			`test_latency_seconds_count{route="x"} 3` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scrape is missing %q", want)
+		}
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	NewGauge("test_duplicate", "First.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate name didn't panic")
+		}
+	}()
+	NewGauge("test_duplicate", "Second.")
+}
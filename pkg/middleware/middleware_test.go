@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// captureLogs sends the default logger to a buffer of JSON lines for the
+// rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// logLines decodes every captured line with the given message.
+func logLines(t *testing.T, buf *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		if line["msg"] == msg {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestRecover(t *testing.T) {
+	logs := captureLogs(t)
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), RequestID, AccessLog, Recover)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/games", nil))
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, content type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != "internal_error" || body.Error.Message == "" || body.RequestID != rec.Header().Get(RequestIDHeader) {
+		t.Errorf("body = %+v", body)
+	}
+
+	panics := logLines(t, logs, "panic in handler")
+	if len(panics) != 1 || panics[0]["panic"] != "boom" || panics[0]["request_id"] != body.RequestID {
+		t.Errorf("panic log = %v", panics)
+	}
+}
+
+func TestRecoverAfterHeaders(t *testing.T) {
+	captureLogs(t)
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late")
+	}), RequestID, AccessLog, Recover)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusAccepted || rec.Body.Len() != 0 {
+		t.Errorf("status = %d, body = %q; want the status already sent and no error body", rec.Code, rec.Body)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetGameCode(r.Context(), "ABC123")
+		SetSession(r.Context(), "logged-in")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}), RequestID, AccessLog, Recover)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/games/ABC123/calls", nil)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: "from-cookie"})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	lines := logLines(t, logs, "request")
+	if len(lines) != 1 {
+		t.Fatalf("access log lines = %v", lines)
+	}
+	line := lines[0]
+	for key, want := range map[string]any{
+		"level":      "INFO",
+		"method":     http.MethodPost,
+		"path":       "/api/games/ABC123/calls",
+		"status":     float64(http.StatusTeapot),
+		"bytes":      float64(len("short and stout")),
+		"request_id": rec.Header().Get(RequestIDHeader),
+		"game_code":  "ABC123",
+		// The session set by the handler replaces the cookie's.
+		"session": HashSessionID("logged-in"),
+	} {
+		if line[key] != want {
+			t.Errorf("%s = %v, want %v", key, line[key], want)
+		}
+	}
+}
+
+func TestAccessLogServerError(t *testing.T) {
+	logs := captureLogs(t)
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusBadGateway)
+	}), RequestID, AccessLog, Recover)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if lines := logLines(t, logs, "request"); len(lines) != 1 || lines[0]["level"] != "ERROR" || lines[0]["status"] != float64(http.StatusBadGateway) {
+		t.Errorf("access log = %v, want one error line with status 502", lines)
+	}
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const requestStateKey contextKey = iota
+
+// requestState carries per-request logging data. Handlers add attributes to it
+// as they learn them so the access log line and any handler log lines share
+// the same context.
+type requestState struct {
+	id string
+
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// RequestID assigns every request an ID, reusing a well-formed incoming
+// X-Request-ID header from a proxy, and echoes it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		state := &requestState{id: id}
+		if cookie, err := r.Cookie("session_id"); err == nil && cookie.Value != "" {
+			state.attrs = append(state.attrs, slog.String("session", HashSessionID(cookie.Value)))
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestStateKey, state)))
+	})
+}
+
+// RequestIDFromContext returns the ID assigned by RequestID, or "" outside a
+// request.
+func RequestIDFromContext(ctx context.Context) string {
+	if state, ok := ctx.Value(requestStateKey).(*requestState); ok {
+		return state.id
+	}
+	return ""
+}
+
+// AddAttrs attaches attributes to the current request. They are included in
+// the access log line and in every subsequent Logger(ctx) call.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	state, ok := ctx.Value(requestStateKey).(*requestState)
+	if !ok {
+		return
+	}
+	state.mu.Lock()
+	state.attrs = append(state.attrs, attrs...)
+	state.mu.Unlock()
+}
+
+// SetGameCode records the game a request is operating on.
+func SetGameCode(ctx context.Context, gameCode string) {
+	if gameCode != "" {
+		AddAttrs(ctx, slog.String("game_code", gameCode))
+	}
+}
+
+// SetSession records the session a request is acting as. Only a hash of the
+// ID is logged so log files can't be used to hijack sessions.
+func SetSession(ctx context.Context, sessionID string) {
+	if sessionID != "" {
+		AddAttrs(ctx, slog.String("session", HashSessionID(sessionID)))
+	}
+}
+
+// Logger returns the default logger annotated with the request ID and any
+// attributes added so far.
+func Logger(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	state, ok := ctx.Value(requestStateKey).(*requestState)
+	if !ok {
+		return logger
+	}
+	args := []any{slog.String("request_id", state.id)}
+	for _, attr := range state.snapshot() {
+		args = append(args, attr)
+	}
+	return logger.With(args...)
+}
+
+// HashSessionID returns a short, stable, non-reversible identifier for a
+// session ID that is safe to write to logs.
+func HashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (s *requestState) snapshot() []slog.Attr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Later values win, so SetSession after login replaces the cookie hash.
+	seen := make(map[string]bool, len(s.attrs))
+	var out []slog.Attr
+	for i := len(s.attrs) - 1; i >= 0; i-- {
+		if seen[s.attrs[i].Key] {
+			continue
+		}
+		seen[s.attrs[i].Key] = true
+		out = append(out, s.attrs[i])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"none", "", false},
+		{"well formed", "proxy-id_1.2", true},
+		{"invalid characters", "id with spaces\r\n", false},
+		{"too long", strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.incoming != "" {
+			req.Header.Set(RequestIDHeader, tt.incoming)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		id := rec.Header().Get(RequestIDHeader)
+		if id == "" || id != seen {
+			t.Errorf("%s: response ID %q, handler saw %q", tt.name, id, seen)
+		}
+		if (id == tt.incoming) != tt.keep {
+			t.Errorf("%s: response ID %q for incoming %q, keep = %v", tt.name, id, tt.incoming, tt.keep)
+		}
+		if !validRequestID(id) {
+			t.Errorf("%s: assigned ID %q is not valid", tt.name, id)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package models
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"time"
 )
 
@@ -10,16 +15,315 @@ const (
 	ContentTypeMixed    = "mixed"
 	ContentTypeTracks   = "tracks"
 	ContentTypeArtists  = "artists"
+	ContentTypeYears    = "years"
+	ContentTypeDecades  = "decades"
+	ContentTypeAlbums   = "albums"
+	ContentTypeGenres   = "genres"
+	// ContentTypeCustom marks games created with an explicit recipe.
+	ContentTypeCustom = "custom"
+)
+
+// Field kinds, the values of BingoField.Type.
+const (
+	FieldTrack    = "track"
+	FieldArtist   = "artist"
+	FieldCombined = "combined"
+	FieldYear     = "year"
+	FieldDecade   = "decade"
+	FieldAlbum    = "album"
+	FieldGenre    = "genre"
+)
+
+// FieldKinds lists every field kind in a fixed order.
+var FieldKinds = []string{FieldTrack, FieldArtist, FieldCombined, FieldYear, FieldDecade, FieldAlbum, FieldGenre}
+
+// FieldsPerPlate is the number of filled fields on a plate: five in each of
+// three rows.
+const FieldsPerPlate = 15
+
+const (
+	SourceTypePlaylist        = "playlist"
+	SourceTypeAlbum           = "album"
+	SourceTypeArtist          = "artist"
+	SourceTypeArtistTopTracks = "artist_top_tracks"
+	SourceTypeSavedTracks     = "saved_tracks"
+	SourceTypeImport          = "import"
 )
 
 type Game struct {
-	GameCode        string       `json:"game_code" db:"game_code"`
-	CreatorID       string       `json:"creator_id" db:"creator_session_id"`
-	PlayerCount     int          `json:"player_count" db:"player_count"`
-	PlatesPerPlayer int          `json:"plates_per_player" db:"plates_per_player"`
-	ContentType     string       `json:"content_type" db:"content_type"`
-	PlaylistData    PlaylistData `json:"playlist_data" db:"playlist_data"`
-	CreatedAt       time.Time    `json:"created_at" db:"created_at"`
+	GameCode        string           `json:"game_code" db:"game_code"`
+	CreatorID       string           `json:"creator_id" db:"creator_session_id"`
+	PlayerCount     int              `json:"player_count" db:"player_count"`
+	PlatesPerPlayer int              `json:"plates_per_player" db:"plates_per_player"`
+	ContentType     string           `json:"content_type" db:"content_type"`
+	ContentRecipe   ContentRecipe    `json:"content_recipe" db:"content_recipe"`
+	Difficulty      string           `json:"difficulty" db:"difficulty"`
+	PlaylistData    PlaylistData     `json:"playlist_data" db:"playlist_data"`
+	Source          SourceDescriptor `json:"source" db:"source"`
+	CreatedAt       time.Time        `json:"created_at" db:"created_at"`
+}
+
+// Round is one round of a game. Every round has its own calls and is won
+// by reaching its prize tier.
+type Round struct {
+	Number    int    `json:"number" db:"number"`
+	PrizeTier string `json:"prize_tier" db:"prize_tier"`
+	Status    string `json:"status" db:"status"`
+	// NewPlates is set when the round dealt fresh plates instead of
+	// continuing with the previous ones.
+	NewPlates bool `json:"new_plates" db:"new_plates"`
+	// Prizes are what the round awards, at most one per tier.
+	Prizes     []Prize    `json:"prizes" db:"prizes"`
+	StartedAt  time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+const (
+	RoundActive   = "active"
+	RoundFinished = "finished"
+)
+
+// Prize tiers say what a plate needs to win a round.
+const (
+	PrizeTierOneRow  = "one_row"
+	PrizeTierTwoRows = "two_rows"
+	PrizeTierFull    = "full"
+)
+
+// PrizeTiers lists the prize tiers from the easiest to the hardest.
+var PrizeTiers = []string{PrizeTierOneRow, PrizeTierTwoRows, PrizeTierFull}
+
+// ValidPrizeTier reports whether tier is one of the PrizeTier values.
+func ValidPrizeTier(tier string) bool {
+	return tierRank(tier) >= 0
+}
+
+func tierRank(tier string) int {
+	for i, t := range PrizeTiers {
+		if t == tier {
+			return i
+		}
+	}
+	return -1
+}
+
+// Prize is what a round awards to plates reaching a tier, such as a drink
+// voucher for one row.
+type Prize struct {
+	Tier string `json:"tier"`
+	Name string `json:"name,omitempty"`
+	// MaxWinners caps the plates that win the prize; 0 means no cap.
+	MaxWinners int `json:"max_winners,omitempty"`
+	// TieBreak decides who gets the last places when several plates
+	// complete the tier on the same call. Empty means TieBreakFirstClaim.
+	TieBreak string `json:"tie_break,omitempty"`
+}
+
+const (
+	// TieBreakFirstClaim gives the prize to plates in the order they are
+	// claimed, until it runs out.
+	TieBreakFirstClaim = "first_claim"
+	// TieBreakShare lets a plate that completed the tier on the same call
+	// as a winner share the prize, even past MaxWinners.
+	TieBreakShare = "share"
+)
+
+// ValidatePrizes reports the first problem with a round's prizes.
+func ValidatePrizes(prizes []Prize) error {
+	seen := make(map[string]bool, len(prizes))
+	for _, p := range prizes {
+		if !ValidPrizeTier(p.Tier) {
+			return fmt.Errorf("unknown prize tier %q", p.Tier)
+		}
+		if seen[p.Tier] {
+			return fmt.Errorf("more than one prize for %s", p.Tier)
+		}
+		seen[p.Tier] = true
+		if p.MaxWinners < 0 {
+			return fmt.Errorf("max winners for %s must not be negative", p.Tier)
+		}
+		switch p.TieBreak {
+		case "", TieBreakFirstClaim, TieBreakShare:
+		default:
+			return fmt.Errorf("unknown tie break %q", p.TieBreak)
+		}
+	}
+	return nil
+}
+
+// TopTier returns the hardest tier with a prize.
+func TopTier(prizes []Prize) string {
+	top := ""
+	for _, p := range prizes {
+		if tierRank(p.Tier) > tierRank(top) {
+			top = p.Tier
+		}
+	}
+	return top
+}
+
+func PrizesToJSON(prizes []Prize) (string, error) {
+	data, err := json.Marshal(prizes)
+	return string(data), err
+}
+
+func PrizesFromJSON(data string) ([]Prize, error) {
+	var prizes []Prize
+	err := json.Unmarshal([]byte(data), &prizes)
+	return prizes, err
+}
+
+// Difficulty levels bias plates toward well-known or obscure tracks, going
+// by Spotify popularity.
+const (
+	DifficultyEasy   = "easy"
+	DifficultyNormal = "normal"
+	DifficultyHard   = "hard"
+)
+
+// ContentRecipe describes how the fields of a plate are split between
+// field kinds. Weights are relative, so {"track": 7, "artist": 3} gives
+// roughly 70% titles. Minimums are filled first on every plate, and the
+// remaining fields are shared out by weight.
+type ContentRecipe struct {
+	Weights  map[string]float64 `json:"weights"`
+	Minimums map[string]int     `json:"minimums,omitempty"`
+}
+
+// RecipeForContentType returns the recipe equivalent to one of the content
+// type strings games were created with before recipes existed.
+func RecipeForContentType(contentType string) (ContentRecipe, bool) {
+	kind := map[string]string{
+		ContentTypeTracks:   FieldTrack,
+		ContentTypeArtists:  FieldArtist,
+		ContentTypeCombined: FieldCombined,
+		ContentTypeYears:    FieldYear,
+		ContentTypeDecades:  FieldDecade,
+		ContentTypeAlbums:   FieldAlbum,
+		ContentTypeGenres:   FieldGenre,
+	}
+	if contentType == ContentTypeMixed {
+		return ContentRecipe{Weights: map[string]float64{FieldTrack: 1, FieldArtist: 1}}, true
+	}
+	if k, ok := kind[contentType]; ok {
+		return ContentRecipe{Weights: map[string]float64{k: 1}}, true
+	}
+	return ContentRecipe{}, false
+}
+
+// Validate reports the first problem with the recipe.
+func (cr ContentRecipe) Validate() error {
+	known := make(map[string]bool, len(FieldKinds))
+	for _, k := range FieldKinds {
+		known[k] = true
+	}
+
+	total := 0.0
+	for kind, w := range cr.Weights {
+		if !known[kind] {
+			return fmt.Errorf("unknown field kind %q", kind)
+		}
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("weight for %s must be a non-negative number", kind)
+		}
+		total += w
+	}
+
+	minimums := 0
+	for kind, n := range cr.Minimums {
+		if !known[kind] {
+			return fmt.Errorf("unknown field kind %q", kind)
+		}
+		if n < 0 {
+			return fmt.Errorf("minimum for %s must not be negative", kind)
+		}
+		minimums += n
+	}
+	if minimums > FieldsPerPlate {
+		return fmt.Errorf("minimums add up to %d, but a plate has %d fields", minimums, FieldsPerPlate)
+	}
+	if total == 0 && minimums < FieldsPerPlate {
+		return errors.New("at least one weight must be positive")
+	}
+	return nil
+}
+
+// Kinds returns the field kinds the recipe uses, in FieldKinds order.
+func (cr ContentRecipe) Kinds() []string {
+	var kinds []string
+	for _, k := range FieldKinds {
+		if cr.Weights[k] > 0 || cr.Minimums[k] > 0 {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+func (cr ContentRecipe) ToJSON() (string, error) {
+	data, err := json.Marshal(cr)
+	return string(data), err
+}
+
+func ContentRecipeFromJSON(data string) (ContentRecipe, error) {
+	var cr ContentRecipe
+	err := json.Unmarshal([]byte(data), &cr)
+	return cr, err
+}
+
+// FairnessReport records how evenly the plates of a game are expected to
+// finish, before and after the generator's balancing pass.
+type FairnessReport struct {
+	// PlayOrder is "planned" when a play order was known at generation and
+	// "random" otherwise.
+	PlayOrder string           `json:"play_order"`
+	Before    CompletionSpread `json:"before"`
+	After     CompletionSpread `json:"after"`
+}
+
+// Play orders a FairnessReport can be computed for.
+const (
+	PlayOrderPlanned = "planned"
+	PlayOrderRandom  = "random"
+)
+
+// CompletionSpread is, for each prize tier, the gap in expected calls
+// between the quickest and slowest plate.
+type CompletionSpread struct {
+	OneRow  float64 `json:"one_row"`
+	TwoRows float64 `json:"two_rows"`
+	Full    float64 `json:"full"`
+}
+
+// Max is the largest gap of any tier.
+func (cs CompletionSpread) Max() float64 {
+	return max(cs.OneRow, cs.TwoRows, cs.Full)
+}
+
+func (fr FairnessReport) ToJSON() (string, error) {
+	data, err := json.Marshal(fr)
+	return string(data), err
+}
+
+func FairnessReportFromJSON(data string) (FairnessReport, error) {
+	var fr FairnessReport
+	err := json.Unmarshal([]byte(data), &fr)
+	return fr, err
+}
+
+// SourceDescriptor records where a game's tracks came from. Games built
+// from several sources (e.g. two artists for a theme night) list each one.
+type SourceDescriptor struct {
+	Sources []Source `json:"sources"`
+}
+
+type Source struct {
+	Type string `json:"type"`
+	// ID is the Spotify ID; empty for the user's saved tracks.
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// SnapshotID identifies the version of the source the game was built
+	// from: Spotify's snapshot_id for playlists, a content hash otherwise.
+	SnapshotID string `json:"snapshot_id,omitempty"`
 }
 
 type PlaylistData struct {
@@ -32,14 +336,30 @@ type Track struct {
 	Name    string   `json:"name"`
 	Artists []string `json:"artists"`
 	ID      string   `json:"id"`
+	// ArtistIDs are the Spotify IDs of Artists, used to look up genres.
+	ArtistIDs []string `json:"artist_ids,omitempty"`
+	// Year is the release year, or 0 when unknown.
+	Year  int    `json:"year,omitempty"`
+	Album string `json:"album,omitempty"`
+	// Genres are the genres of the track's artists; Spotify doesn't tag
+	// tracks themselves.
+	Genres []string `json:"genres,omitempty"`
+	// Popularity is Spotify's 1-100 score, or 0 when unknown.
+	Popularity int `json:"popularity,omitempty"`
+	// DurationMS and Path are only known for tracks read from local files.
+	DurationMS int    `json:"duration_ms,omitempty"`
+	Path       string `json:"path,omitempty"`
 }
 
 type Plate struct {
-	ID            int         `json:"id" db:"id"`
-	GameCode      string      `json:"game_code" db:"game_code"`
-	UserSessionID string      `json:"user_session_id" db:"user_session_id"`
-	PlateNumber   int         `json:"plate_number" db:"plate_number"`
-	Fields        PlateFields `json:"fields" db:"fields"`
+	ID            int    `json:"id" db:"id"`
+	GameCode      string `json:"game_code" db:"game_code"`
+	UserSessionID string `json:"user_session_id" db:"user_session_id"`
+	// Round is the round the plate was dealt in. Plates stay in play until
+	// a later round deals new ones.
+	Round       int         `json:"round" db:"round"`
+	PlateNumber int         `json:"plate_number" db:"plate_number"`
+	Fields      PlateFields `json:"fields" db:"fields"`
 }
 
 type PlateFields struct {
@@ -50,13 +370,32 @@ type BingoField struct {
 	Content string `json:"content"`
 	Type    string `json:"type"`
 	Marked  bool   `json:"marked"`
+	// TrackID is set for track and combined fields.
+	TrackID string `json:"track_id,omitempty"`
+	// Key is the normalized value the field is matched on when tracks are
+	// called; see package normalize.
+	Key string `json:"key,omitempty"`
 }
 
 type UserSession struct {
-	SessionID    string    `json:"session_id" db:"session_id"`
-	SpotifyToken string    `json:"spotify_token,omitempty" db:"spotify_token"`
-	ExpiresAt    time.Time `json:"expires_at" db:"expires_at"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	SessionID    string `json:"session_id" db:"session_id"`
+	SpotifyToken string `json:"spotify_token,omitempty" db:"spotify_token"`
+	// TokenExpiresAt is when SpotifyToken stops working. The session
+	// itself lasts until ExpiresAt.
+	TokenExpiresAt time.Time `json:"token_expires_at" db:"token_expires_at"`
+	ExpiresAt      time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// ContentSnapshotID derives a snapshot ID from a track list, for sources
+// that don't have one of their own.
+func ContentSnapshotID(tracks []Track) string {
+	h := sha1.New()
+	enc := json.NewEncoder(h)
+	for _, t := range tracks {
+		enc.Encode(t)
+	}
+	return "content:" + hex.EncodeToString(h.Sum(nil))
 }
 
 func (pd PlaylistData) ToJSON() (string, error) {
@@ -70,6 +409,17 @@ func PlaylistDataFromJSON(data string) (PlaylistData, error) {
 	return pd, err
 }
 
+func (sd SourceDescriptor) ToJSON() (string, error) {
+	data, err := json.Marshal(sd)
+	return string(data), err
+}
+
+func SourceDescriptorFromJSON(data string) (SourceDescriptor, error) {
+	var sd SourceDescriptor
+	err := json.Unmarshal([]byte(data), &sd)
+	return sd, err
+}
+
 func (pf PlateFields) ToJSON() (string, error) {
 	data, err := json.Marshal(pf)
 	return string(data), err
@@ -0,0 +1,276 @@
+// Package normalize reduces titles and artist names to comparison keys, so
+// spelling variants such as "Beyoncé" and "Beyonce", or "The Beatles" and
+// "Beatles", count as one value both when plates are generated and when
+// calls are matched against fields.
+package normalize
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// Key prefixes keep keys of different field kinds from colliding: a song
+// called "Madonna" is not the artist Madonna.
+const (
+	prefixSong   = "song:"
+	prefixArtist = "artist:"
+	prefixTrack  = "track:"
+	prefixYear   = "year:"
+	prefixDecade = "decade:"
+	prefixAlbum  = "album:"
+	prefixGenre  = "genre:"
+)
+
+// DefaultAliases maps alternative artist spellings to a canonical one.
+// Spellings are normalized before lookup, so entries only need to cover
+// differences that folding can't, like stage names and symbols.
+var DefaultAliases = map[string]string{
+	"P!nk":                                "Pink",
+	"Ke$ha":                               "Kesha",
+	"Jay Z":                               "Jay-Z",
+	"Beyoncé Knowles":                     "Beyoncé",
+	"Snoop Doggy Dogg":                    "Snoop Dogg",
+	"Puff Daddy":                          "Diddy",
+	"P. Diddy":                            "Diddy",
+	"The Artist Formerly Known as Prince": "Prince",
+	"Prince and The Revolution":           "Prince",
+	"Bob Marley and The Wailers":          "Bob Marley",
+	"Tom Petty and The Heartbreakers":     "Tom Petty",
+	"Bruce Springsteen and The E Street Band": "Bruce Springsteen",
+}
+
+// Normalizer builds keys using an alias table. It is safe for concurrent
+// use.
+type Normalizer struct {
+	aliases map[string]string
+}
+
+var defaultNormalizer = New(DefaultAliases)
+
+// Default returns a normalizer using DefaultAliases.
+func Default() *Normalizer {
+	return defaultNormalizer
+}
+
+// New builds a normalizer from an alias table of spelling → canonical
+// spelling.
+func New(aliases map[string]string) *Normalizer {
+	n := &Normalizer{aliases: make(map[string]string, len(aliases))}
+	for alias, canonical := range aliases {
+		n.aliases[artistName(alias)] = artistName(canonical)
+	}
+	return n
+}
+
+// foldings covers letters whose decomposition isn't just a base letter plus
+// combining marks, and the common precomposed Latin letters.
+var foldings = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'ď': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ō': "o", 'ő': "o",
+	'ř': "r",
+	'ś': "s", 'š': "s", 'ş': "s", 'ș': "s",
+	'ť': "t", 'ţ': "t", 'ț': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// Fold lowercases s, strips diacritics, spells out "&" and drops
+// punctuation, so "Beyoncé", "beyonce" and "BEYONCE!" fold alike.
+func Fold(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		if f, ok := foldings[r]; ok {
+			b.WriteString(f)
+			space = false
+			continue
+		}
+		switch {
+		case r == '&':
+			if !space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString("and ")
+			space = true
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			space = false
+		case unicode.IsSpace(r):
+			if !space && b.Len() > 0 {
+				b.WriteByte(' ')
+				space = true
+			}
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks from decomposed input.
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+var (
+	featuringBrackets = regexp.MustCompile(`(?i)\s*[(\[](?:feat\.?|ft\.?|featuring)\s[^)\]]*[)\]]`)
+	featuringSuffix   = regexp.MustCompile(`(?i)\s+(?:-\s+)?(?:feat\.?|ft\.?|featuring)\s.*$`)
+	featuringMarker   = regexp.MustCompile(`(?i)\s*[(\[]?\s*\b(?:feat\.?|ft\.?|featuring)\s+`)
+	creditSeparator   = regexp.MustCompile(`\s*(?:,|\s&\s)\s*`)
+)
+
+// SplitArtists splits a credit such as "Calvin Harris feat. Rihanna" into
+// the main and featured artists. Featured artists are further split on
+// commas and "&"; the main artist never is, so "Simon & Garfunkel" stays
+// whole.
+func SplitArtists(credit string) []string {
+	loc := featuringMarker.FindStringIndex(credit)
+	if loc == nil {
+		if s := strings.TrimSpace(credit); s != "" {
+			return []string{s}
+		}
+		return nil
+	}
+
+	var artists []string
+	if main := strings.TrimSpace(credit[:loc[0]]); main != "" {
+		artists = append(artists, main)
+	}
+	featured := strings.TrimRight(strings.TrimSpace(credit[loc[1]:]), ")]")
+	for _, a := range creditSeparator.Split(featured, -1) {
+		if a = strings.TrimSpace(a); a != "" {
+			artists = append(artists, a)
+		}
+	}
+	return artists
+}
+
+// Title returns the comparison form of a track title, without featuring
+// credits.
+func Title(name string) string {
+	name = featuringBrackets.ReplaceAllString(name, "")
+	name = featuringSuffix.ReplaceAllString(name, "")
+	return Fold(name)
+}
+
+// artistName folds an artist name and drops a leading "The".
+func artistName(name string) string {
+	folded := Fold(name)
+	if rest, ok := strings.CutPrefix(folded, "the "); ok {
+		return rest
+	}
+	return folded
+}
+
+// Artist returns the comparison form of an artist name, resolving aliases.
+func (n *Normalizer) Artist(name string) string {
+	key := artistName(name)
+	if canonical, ok := n.aliases[key]; ok {
+		return canonical
+	}
+	return key
+}
+
+// Artists splits featuring credits and drops artists that normalize to
+// one already listed, keeping the first spelling seen.
+func (n *Normalizer) Artists(artists []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, credit := range artists {
+		for _, a := range SplitArtists(credit) {
+			key := n.Artist(a)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// ArtistKey is the key of an artist field.
+func (n *Normalizer) ArtistKey(name string) string {
+	return prefixArtist + n.Artist(name)
+}
+
+// SongKey is the key of a track or combined field: the title and main
+// artist, so the same song on a single and on an album is one song.
+func (n *Normalizer) SongKey(name string, artists []string) string {
+	main := ""
+	if split := n.Artists(artists); len(split) > 0 {
+		main = n.Artist(split[0])
+	}
+	return prefixSong + Title(name) + "|" + main
+}
+
+// TrackIDKey matches a field to one exact track. Fields stored before keys
+// existed fall back to it.
+func TrackIDKey(id string) string {
+	return prefixTrack + id
+}
+
+// YearKey is the key of a year field.
+func YearKey(year int) string {
+	return prefixYear + strconv.Itoa(year)
+}
+
+// DecadeKey is the key of a decade field; any year of the decade gives the
+// same key.
+func DecadeKey(year int) string {
+	return prefixDecade + strconv.Itoa(year-year%10)
+}
+
+// AlbumKey is the key of an album field.
+func AlbumKey(name string) string {
+	return prefixAlbum + Title(name)
+}
+
+// GenreKey is the key of a genre field.
+func GenreKey(genre string) string {
+	return prefixGenre + Fold(genre)
+}
+
+// TrackKeys returns every field key a called track satisfies.
+func (n *Normalizer) TrackKeys(track models.Track) []string {
+	keys := []string{n.SongKey(track.Name, track.Artists)}
+	if track.ID != "" {
+		keys = append(keys, TrackIDKey(track.ID))
+	}
+	if track.Year > 0 {
+		keys = append(keys, YearKey(track.Year), DecadeKey(track.Year))
+	}
+	if track.Album != "" {
+		keys = append(keys, AlbumKey(track.Album))
+	}
+	for _, g := range track.Genres {
+		keys = append(keys, GenreKey(g))
+	}
+	for _, a := range n.Artists(track.Artists) {
+		keys = append(keys, n.ArtistKey(a))
+	}
+	return keys
+}
+
+// UniqueSongs drops tracks whose song key was already seen, keeping the
+// first occurrence.
+func (n *Normalizer) UniqueSongs(tracks []models.Track) []models.Track {
+	seen := make(map[string]bool, len(tracks))
+	unique := make([]models.Track, 0, len(tracks))
+	for _, t := range tracks {
+		key := n.SongKey(t.Name, t.Artists)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, t)
+	}
+	return unique
+}
@@ -0,0 +1,104 @@
+package normalize
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+func TestArtist(t *testing.T) {
+	same := [][]string{
+		{"Beyoncé", "Beyonce", "BEYONCE", "Beyonce\u0301", "Beyoncé Knowles"},
+		{"The Beatles", "Beatles", "the beatles"},
+		{"Sigur Rós", "Sigur Ros"},
+		{"Earth, Wind & Fire", "Earth Wind and Fire"},
+		{"AC/DC", "ACDC"},
+		{"P!nk", "Pink"},
+		{"Jay-Z", "Jay Z", "JAY-Z"},
+		{"Motörhead", "Motorhead"},
+	}
+	n := Default()
+	for _, group := range same {
+		want := n.Artist(group[0])
+		for _, name := range group[1:] {
+			if got := n.Artist(name); got != want {
+				t.Errorf("Artist(%q) = %q, want %q as for %q", name, got, want, group[0])
+			}
+		}
+	}
+
+	different := [][2]string{
+		{"Pink", "Pink Floyd"},
+		{"Prince", "Princess"},
+	}
+	for _, pair := range different {
+		if n.Artist(pair[0]) == n.Artist(pair[1]) {
+			t.Errorf("%q and %q normalize alike", pair[0], pair[1])
+		}
+	}
+}
+
+func TestSplitArtists(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Calvin Harris feat. Rihanna", []string{"Calvin Harris", "Rihanna"}},
+		{"Major Lazer (ft. MØ & DJ Snake)", []string{"Major Lazer", "MØ", "DJ Snake"}},
+		{"Santana featuring Rob Thomas, Michelle Branch", []string{"Santana", "Rob Thomas", "Michelle Branch"}},
+		{"Simon & Garfunkel", []string{"Simon & Garfunkel"}},
+		{"Daft Punk", []string{"Daft Punk"}},
+		{"Craft Spells", []string{"Craft Spells"}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		if got := SplitArtists(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArtists(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestArtistsDedupes(t *testing.T) {
+	got := Default().Artists([]string{"Beyoncé feat. Jay-Z", "Beyonce", "JAY Z"})
+	want := []string{"Beyoncé", "Jay-Z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Artists = %q, want %q", got, want)
+	}
+}
+
+func TestUniqueSongs(t *testing.T) {
+	tracks := []models.Track{
+		{ID: "1", Name: "Crazy in Love (feat. Jay-Z)", Artists: []string{"Beyoncé", "Jay-Z"}},
+		{ID: "2", Name: "Crazy In Love", Artists: []string{"Beyonce"}},
+		{ID: "3", Name: "Crazy in Love", Artists: []string{"Gnarls Barkley"}},
+		{ID: "4", Name: "Yesterday", Artists: []string{"The Beatles"}},
+		{ID: "5", Name: "Yesterday", Artists: []string{"Beatles"}},
+	}
+	var ids []string
+	for _, t := range Default().UniqueSongs(tracks) {
+		ids = append(ids, t.ID)
+	}
+	if want := []string{"1", "3", "4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("unique songs = %v, want %v", ids, want)
+	}
+}
+
+func TestTrackKeys(t *testing.T) {
+	n := Default()
+	keys := n.TrackKeys(models.Track{ID: "x", Name: "Lean On", Artists: []string{"Major Lazer feat. MØ"}})
+	want := map[string]bool{
+		n.SongKey("Lean On", []string{"Major Lazer"}): true,
+		TrackIDKey("x"):            true,
+		n.ArtistKey("Major Lazer"): true,
+		n.ArtistKey("Mo"):          true,
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %q, want %d keys", keys, len(want))
+	}
+	for _, k := range keys {
+		if !want[k] {
+			t.Errorf("unexpected key %q", k)
+		}
+	}
+}
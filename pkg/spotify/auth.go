@@ -1,19 +1,28 @@
 package spotify
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/metrics"
 )
 
+const DefaultAccountsURL = "https://accounts.spotify.com"
+
 type AuthConfig struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURI  string
+	// AccountsURL overrides the accounts service host. Defaults to
+	// DefaultAccountsURL when empty.
+	AccountsURL string
 }
 
 type TokenResponse struct {
@@ -25,7 +34,7 @@ type TokenResponse struct {
 }
 
 func (ac *AuthConfig) GetAuthURL(state string) string {
-	scopes := "playlist-read-private playlist-read-collaborative"
+	scopes := "playlist-read-private playlist-read-collaborative user-library-read"
 	params := url.Values{}
 	params.Add("response_type", "code")
 	params.Add("client_id", ac.ClientID)
@@ -33,16 +42,16 @@ func (ac *AuthConfig) GetAuthURL(state string) string {
 	params.Add("redirect_uri", ac.RedirectURI)
 	params.Add("state", state)
 
-	return "https://accounts.spotify.com/authorize?" + params.Encode()
+	return ac.accountsURL() + "/authorize?" + params.Encode()
 }
 
-func (ac *AuthConfig) ExchangeCodeForToken(code string) (*TokenResponse, error) {
+func (ac *AuthConfig) ExchangeCodeForToken(ctx context.Context, code string) (*TokenResponse, error) {
 	data := url.Values{}
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", code)
 	data.Set("redirect_uri", ac.RedirectURI)
 
-	req, err := http.NewRequest("POST", "https://accounts.spotify.com/api/token", strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", ac.accountsURL()+"/api/token", strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -51,15 +60,18 @@ func (ac *AuthConfig) ExchangeCodeForToken(code string) (*TokenResponse, error)
 	req.SetBasicAuth(ac.ClientID, ac.ClientSecret)
 
 	client := &http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		metrics.SpotifyRequestDuration.ObserveSince(start, "token", "error")
 		return nil, err
 	}
+	metrics.SpotifyRequestDuration.ObserveSince(start, "token", strconv.Itoa(resp.StatusCode))
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("token exchange failed: %s", string(body))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, fmt.Errorf("token exchange failed: %w: %s", &StatusError{Endpoint: "token", StatusCode: resp.StatusCode}, string(body))
 	}
 
 	var tokenResp TokenResponse
@@ -69,3 +81,10 @@ func (ac *AuthConfig) ExchangeCodeForToken(code string) (*TokenResponse, error)
 
 	return &tokenResp, nil
 }
+
+func (ac *AuthConfig) accountsURL() string {
+	if ac.AccountsURL == "" {
+		return DefaultAccountsURL
+	}
+	return strings.TrimRight(ac.AccountsURL, "/")
+}
@@ -1,28 +1,68 @@
 package spotify
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
-	"regexp"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/kirkegaard/go-spotify-bingo/pkg/metrics"
 	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
 )
 
+const (
+	DefaultAPIURL = "https://api.spotify.com"
+
+	defaultMaxRetries = 3
+	defaultBaseDelay  = 500 * time.Millisecond
+	defaultMaxDelay   = 10 * time.Second
+)
+
 type Client struct {
 	accessToken string
+	apiURL      string
 	httpClient  *http.Client
+
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
 }
 
 type PlaylistResponse struct {
 	Items []PlaylistItem `json:"items"`
+	Next  *string        `json:"next"`
 }
 
 type PlaylistItem struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	SnapshotID string        `json:"snapshot_id"`
+	Owner      User          `json:"owner"`
+	Images     []Image       `json:"images"`
+	Tracks     TracksSummary `json:"tracks"`
+}
+
+type User struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+}
+
+type Image struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// TracksSummary is the track reference embedded in simplified playlist
+// objects; only the total is included.
+type TracksSummary struct {
+	Total int `json:"total"`
 }
 
 type PlaylistTracksResponse struct {
@@ -38,91 +78,104 @@ type Track struct {
 	ID      string   `json:"id"`
 	Name    string   `json:"name"`
 	Artists []Artist `json:"artists"`
+	// Album is the simplified album object. It is missing from the tracks
+	// of an album's own track listing.
+	Album *Album `json:"album,omitempty"`
+	// Popularity runs from 0 to 100. Like Album, it is missing from album
+	// track listings.
+	Popularity int `json:"popularity,omitempty"`
 }
 
 type Artist struct {
+	ID   string `json:"id"`
 	Name string `json:"name"`
 }
 
-func NewClient(accessToken string) *Client {
-	return &Client{
-		accessToken: accessToken,
-		httpClient:  &http.Client{Timeout: 10 * time.Second},
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIURL points the client at a different Web API host, such as a fake
+// server in tests. The URL must not have a trailing /v1.
+func WithAPIURL(apiURL string) Option {
+	return func(c *Client) {
+		if apiURL != "" {
+			c.apiURL = strings.TrimRight(apiURL, "/")
+		}
 	}
 }
 
-func (c *Client) GetUserPlaylists() ([]PlaylistItem, error) {
-	req, err := http.NewRequest("GET", "https://api.spotify.com/v1/me/playlists?limit=50", nil)
-	if err != nil {
-		return nil, err
+// WithHTTPClient replaces the default HTTP client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
 	}
+}
 
-	req.Header.Set("Authorization", "Bearer "+c.accessToken)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get playlists: status %d", resp.StatusCode)
+func NewClient(accessToken string, opts ...Option) *Client {
+	c := &Client{
+		accessToken: accessToken,
+		apiURL:      DefaultAPIURL,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		maxRetries:  defaultMaxRetries,
+		baseDelay:   defaultBaseDelay,
+		maxDelay:    defaultMaxDelay,
 	}
-
-	var playlistResp PlaylistResponse
-	if err := json.NewDecoder(resp.Body).Decode(&playlistResp); err != nil {
-		return nil, err
+	for _, opt := range opts {
+		opt(c)
 	}
-
-	return playlistResp.Items, nil
+	return c
 }
 
-func (c *Client) GetPlaylistTracks(playlistID string) (models.PlaylistData, error) {
-	var allTracks []models.Track
-	url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks?limit=100", playlistID)
+// GetUserPlaylists returns every playlist the user owns or follows, following
+// the pagination links until the last page.
+func (c *Client) GetUserPlaylists(ctx context.Context) ([]PlaylistItem, error) {
+	var playlists []PlaylistItem
+	next := c.apiURL + "/v1/me/playlists?limit=50"
 
-	for url != "" {
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return models.PlaylistData{}, err
+	for next != "" {
+		var playlistResp PlaylistResponse
+		if err := c.get(ctx, "me/playlists", next, &playlistResp); err != nil {
+			return nil, fmt.Errorf("failed to get playlists: %w", err)
 		}
 
-		req.Header.Set("Authorization", "Bearer "+c.accessToken)
-
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			return models.PlaylistData{}, err
+		for _, item := range playlistResp.Items {
+			// Spotify returns null entries for playlists that have since
+			// been deleted or made private.
+			if item.ID != "" {
+				playlists = append(playlists, item)
+			}
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			return models.PlaylistData{}, fmt.Errorf("failed to get playlist tracks: status %d", resp.StatusCode)
+		if playlistResp.Next != nil {
+			next = *playlistResp.Next
+		} else {
+			next = ""
 		}
+	}
+
+	return playlists, nil
+}
 
+func (c *Client) GetPlaylistTracks(ctx context.Context, playlistID string) (models.PlaylistData, error) {
+	var allTracks []models.Track
+	next := fmt.Sprintf("%s/v1/playlists/%s/tracks?limit=100", c.apiURL, url.PathEscape(playlistID))
+
+	for next != "" {
 		var tracksResp PlaylistTracksResponse
-		if err := json.NewDecoder(resp.Body).Decode(&tracksResp); err != nil {
-			return models.PlaylistData{}, err
+		if err := c.get(ctx, "playlists/{id}/tracks", next, &tracksResp); err != nil {
+			return models.PlaylistData{}, fmt.Errorf("failed to get playlist tracks: %w", err)
 		}
 
 		for _, item := range tracksResp.Items {
-			if item.Track.ID != "" {
-				var artistNames []string
-				for _, artist := range item.Track.Artists {
-					artistNames = append(artistNames, artist.Name)
-				}
-
-				allTracks = append(allTracks, models.Track{
-					ID:      item.Track.ID,
-					Name:    cleanTrackName(item.Track.Name),
-					Artists: artistNames,
-				})
+			if track, ok := toModelTrack(item.Track); ok {
+				allTracks = append(allTracks, track)
 			}
 		}
 
 		if tracksResp.Next != nil {
-			url = *tracksResp.Next
+			next = *tracksResp.Next
 		} else {
-			url = ""
+			next = ""
 		}
 	}
 
@@ -132,107 +185,150 @@ func (c *Client) GetPlaylistTracks(playlistID string) (models.PlaylistData, erro
 	}, nil
 }
 
-func (c *Client) GetPlaylistByID(playlistID string) (*PlaylistItem, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.spotify.com/v1/playlists/%s", playlistID), nil)
-	if err != nil {
-		return nil, err
+func (c *Client) GetPlaylistByID(ctx context.Context, playlistID string) (*PlaylistItem, error) {
+	var playlist PlaylistItem
+	if err := c.get(ctx, "playlists/{id}", fmt.Sprintf("%s/v1/playlists/%s", c.apiURL, url.PathEscape(playlistID)), &playlist); err != nil {
+		return nil, fmt.Errorf("failed to get playlist: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	return &playlist, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// toModelTrack converts an API track into the stored form. Local files and
+// tracks that are no longer available have no ID and are skipped. Names are
+// stored as Spotify has them; cleaning happens when plates are generated.
+func toModelTrack(t Track) (models.Track, bool) {
+	if t.ID == "" {
+		return models.Track{}, false
+	}
+
+	var artistNames, artistIDs []string
+	for _, artist := range t.Artists {
+		artistNames = append(artistNames, artist.Name)
+		if artist.ID != "" {
+			artistIDs = append(artistIDs, artist.ID)
+		}
+	}
+
+	track := models.Track{
+		ID:         t.ID,
+		Name:       t.Name,
+		Artists:    artistNames,
+		ArtistIDs:  artistIDs,
+		Popularity: t.Popularity,
+	}
+	if t.Album != nil {
+		track.Year = releaseYear(t.Album.ReleaseDate)
+		track.Album = t.Album.Name
+	}
+	return track, true
+}
+
+// releaseYear reads the year from a release date, which Spotify gives as
+// "1987", "1987-06" or "1987-06-15" depending on its precision.
+func releaseYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
 	if err != nil {
-		return nil, err
+		return 0
+	}
+	return year
+}
+
+// get fetches rawURL and decodes the JSON response into out, retrying rate
+// limits and server errors. endpoint is a path template used for metrics
+// and errors so playlist IDs don't explode the label cardinality.
+func (c *Client) get(ctx context.Context, endpoint, rawURL string, out any) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+		resp, err := c.do(req, endpoint)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(out)
+			resp.Body.Close()
+			return err
+		}
+
+		// Drain so the connection can be reused by the retry.
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		statusErr := &StatusError{Endpoint: endpoint, StatusCode: resp.StatusCode}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		if !retryable(resp.StatusCode) || attempt >= c.maxRetries {
+			return statusErr
+		}
+
+		delay := c.backoff(attempt)
+		if statusErr.RetryAfter > 0 {
+			delay = statusErr.RetryAfter
+		}
+		if delay > c.maxDelay {
+			// Waiting longer than this would outlast the HTTP request
+			// that's waiting on us, so give up and let the caller decide.
+			return statusErr
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get playlist: status %d", resp.StatusCode)
+// do sends req and records its latency under the given endpoint label.
+func (c *Client) do(req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
 	}
+	metrics.SpotifyRequestDuration.ObserveSince(start, endpoint, status)
+	return resp, err
+}
 
-	var playlist PlaylistItem
-	if err := json.NewDecoder(resp.Body).Decode(&playlist); err != nil {
-		return nil, err
+// backoff returns the exponential delay for the given attempt with full
+// jitter, so concurrent game creations don't retry in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.baseDelay << attempt
+	if d <= 0 || d > c.maxDelay {
+		d = c.maxDelay
 	}
+	return time.Duration(rand.Int64N(int64(d)) + 1)
+}
 
-	return &playlist, nil
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
 }
 
-// cleanTrackName removes common suffixes from track names to make bingo fields cleaner
-func cleanTrackName(name string) string {
-	// Remove common patterns in parentheses or after dashes
-	patterns := []string{
-		// Remix/Edit variations (parentheses)
-		`\s*\(.*?[Rr]adio [Ee]dit.*?\)`,
-		`\s*\(.*?[Rr]adio [Vv]ersion.*?\)`,
-		`\s*\(.*?[Oo]riginal.*?[Rr]adio.*?[Ee]dit.*?\)`,
-		`\s*\(.*?[Oo]riginal [Mm]ix.*?\)`,
-		`\s*\([Oo]riginal\)`,
-		`\s*\(.*?[Aa]lbum [Vv]ersion.*?\)`,
-		`\s*\(.*?[Ss]ingle [Vv]ersion.*?\)`,
-		`\s*\(.*?[Ee]xtended [Mm]ix.*?\)`,
-		`\s*\(.*?[Cc]lub [Mm]ix.*?\)`,
-		`\s*\(.*?[Ss]hort [Mm]ix.*?\)`,
-		`\s*\(.*?[Vv]ideo [Mm]ix.*?\)`,
-		`\s*\(.*?\d+["'""]?\s*[Vv]ersion.*?\)`, // 12" Version, 7" Version, etc.
-		`\s*\(.*?\d{4}.*?[Rr]emaster.*?\)`,     // 2005 Remaster, etc.
-		`\s*\(.*?[Rr]emix.*?\)`,
-		`\s*\(.*?[Ee]dit.*?\)`,
-		`\s*\(.*?[Vv]ersion.*?\)`,
-		`\s*\(.*?[Rr]emaster.*?\)`,
-		`\s*\(.*?[Mm]ix.*?\)`, // Generic mix patterns
-
-		// Dash variations
-		`\s*-\s*[Rr]adio [Ee]dit.*$`,
-		`\s*-\s*[Rr]adio [Vv]ersion.*$`,
-		`\s*-\s*[Oo]riginal.*?[Rr]adio.*?[Ee]dit.*$`,
-		`\s*-\s*[Oo]riginal [Mm]ix.*$`,
-		`\s*-\s*[Oo]riginal [Aa]lbum [Vv]ersion.*$`,
-		`\s*-\s*[Oo]riginal$`,
-		`\s*-\s*[Aa]lbum [Vv]ersion.*$`,
-		`\s*-\s*[Ss]ingle [Vv]ersion.*$`,
-		`\s*-\s*[Ee]xtended [Mm]ix.*$`,
-		`\s*-\s*[Cc]lub [Mm]ix.*$`,
-		`\s*-\s*[Ss]hort [Mm]ix.*$`,
-		`\s*-\s*[Vv]ideo [Mm]ix.*$`,
-		`\s*-\s*\d+["'""]?\s*[Vv]ersion.*$`, // 12" Version, 7" Version, etc.
-		`\s*-\s*\d{4}.*?[Rr]emaster.*$`,     // 2005 Remaster, etc.
-		`\s*-\s*[Rr]emix.*$`,
-		`\s*-\s*[Ee]dit.*$`,
-		`\s*-\s*[Vv]ersion.*$`,
-		`\s*-\s*[Rr]emaster.*$`,
-		`\s*-\s*.*?[Mm]ix.*$`, // Generic mix patterns
-
-		// Additional common patterns
-		`\s*\([Ff]eat\..*?\)`,
-		`\s*\([Ff]t\..*?\)`,
-		`\s*\(.*?[Yy]ear.*?\)`,
-		`\s*\(.*?\d{4}.*?\)`, // Years
-
-		// Complex combinations with slashes (e.g., "Radio Edit / Remastered 2025")
-		`\s*-\s*.*?[Rr]adio [Ee]dit\s*/\s*[Rr]emaster.*?\d{4}.*$`,
-		`\s*-\s*.*?[Ee]dit\s*/\s*[Rr]emaster.*?\d{4}.*$`,
-		`\s*-\s*.*?[Mm]ix\s*/\s*[Rr]emaster.*?\d{4}.*$`,
-		`\s*-\s*.*?[Vv]ersion\s*/\s*[Rr]emaster.*?\d{4}.*$`,
-
-		// Catch any remaining parentheses with mix/edit/version
-		`\s*\(.*?(?i:mix|edit|version|remix|remaster).*?\)`,
-	}
-
-	cleaned := name
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		cleaned = re.ReplaceAllString(cleaned, "")
-	}
-
-	// Clean up extra whitespace
-	cleaned = strings.TrimSpace(cleaned)
-	cleaned = regexp.MustCompile(`\s+`).ReplaceAllString(cleaned, " ")
-
-	// If we accidentally removed everything, return original
-	if cleaned == "" {
-		return name
-	}
-
-	return cleaned
+// parseRetryAfter understands both the delay-seconds and HTTP-date forms.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
 }
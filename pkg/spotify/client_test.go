@@ -0,0 +1,128 @@
+package spotify_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify/spotifytest"
+)
+
+func newClient(fake *spotifytest.Server, maxDelay time.Duration) *spotify.Client {
+	return spotify.NewClient(spotifytest.AccessToken, spotify.WithAPIURL(fake.URL), spotify.WithRetryDelays(time.Millisecond, maxDelay))
+}
+
+func faults(status, n int) []spotifytest.Fault {
+	f := make([]spotifytest.Fault, n)
+	for i := range f {
+		f[i] = spotifytest.Fault{Status: status}
+	}
+	return f
+}
+
+func TestClientErrorSentinels(t *testing.T) {
+	sentinels := []error{spotify.ErrUnauthorized, spotify.ErrForbidden, spotify.ErrNotFound, spotify.ErrRateLimited, spotify.ErrUnavailable}
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusUnauthorized, spotify.ErrUnauthorized},
+		{http.StatusForbidden, spotify.ErrForbidden},
+		{http.StatusNotFound, spotify.ErrNotFound},
+		{http.StatusTooManyRequests, spotify.ErrRateLimited},
+		{http.StatusInternalServerError, spotify.ErrUnavailable},
+		{http.StatusBadGateway, spotify.ErrUnavailable},
+		{http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		fake := spotifytest.NewServer(t)
+		fake.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party"})
+		// Enough faults to outlast the retries of retryable statuses.
+		fake.Fail(spotifytest.RoutePlaylist, faults(tt.status, 4)...)
+
+		_, err := newClient(fake, time.Second).GetPlaylistByID(context.Background(), "pl1")
+		var statusErr *spotify.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || statusErr.Endpoint != "playlists/{id}" {
+			t.Errorf("%d: err = %v, want a StatusError", tt.status, err)
+			continue
+		}
+		for _, sentinel := range sentinels {
+			if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+				t.Errorf("%d: errors.Is(err, %v) = %v", tt.status, sentinel, got)
+			}
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	fake := spotifytest.NewServer(t)
+	fake.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party"})
+	fake.Fail(spotifytest.RoutePlaylist, faults(http.StatusServiceUnavailable, 2)...)
+
+	playlist, err := newClient(fake, time.Second).GetPlaylistByID(context.Background(), "pl1")
+	if err != nil || playlist.Name != "Party" {
+		t.Fatalf("GetPlaylistByID = %+v, %v", playlist, err)
+	}
+	if got := fake.Requests(spotifytest.RoutePlaylist); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+}
+
+func TestClientRunsOutOfRetries(t *testing.T) {
+	fake := spotifytest.NewServer(t)
+	fake.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party"})
+	fake.Fail(spotifytest.RoutePlaylist, faults(http.StatusServiceUnavailable, 10)...)
+
+	_, err := newClient(fake, time.Second).GetPlaylistByID(context.Background(), "pl1")
+	if !errors.Is(err, spotify.ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	// The first attempt and three retries.
+	if got := fake.Requests(spotifytest.RoutePlaylist); got != 4 {
+		t.Errorf("requests = %d, want 4", got)
+	}
+}
+
+func TestClientRetryAfterDate(t *testing.T) {
+	fake := spotifytest.NewServer(t)
+	fake.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party"})
+	retryAt := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	fake.Fail(spotifytest.RoutePlaylist, spotifytest.Fault{Status: http.StatusTooManyRequests, RetryAfter: retryAt})
+
+	// An hour is longer than the client will wait, so it gives up at once
+	// and reports the wait.
+	_, err := newClient(fake, time.Second).GetPlaylistByID(context.Background(), "pl1")
+	var statusErr *spotify.StatusError
+	if !errors.As(err, &statusErr) || !errors.Is(err, spotify.ErrRateLimited) {
+		t.Fatalf("err = %v, want a rate limit StatusError", err)
+	}
+	if statusErr.RetryAfter < 59*time.Minute || statusErr.RetryAfter > time.Hour {
+		t.Errorf("RetryAfter = %v, want about an hour", statusErr.RetryAfter)
+	}
+	if got := fake.Requests(spotifytest.RoutePlaylist); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+func TestClientCancelDuringBackoff(t *testing.T) {
+	fake := spotifytest.NewServer(t)
+	fake.AddPlaylist(spotifytest.Playlist{ID: "pl1", Name: "Party"})
+	fake.Fail(spotifytest.RoutePlaylist, spotifytest.Fault{Status: http.StatusTooManyRequests, RetryAfter: "5"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := newClient(fake, 10*time.Second).GetPlaylistByID(ctx, "pl1")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned after %v, want soon after the cancel", elapsed)
+	}
+	if got := fake.Requests(spotifytest.RoutePlaylist); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
@@ -0,0 +1,53 @@
+package spotify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrUnauthorized means the access token was rejected, usually because it
+	// expired. The user has to log in again.
+	ErrUnauthorized = errors.New("spotify: unauthorized")
+	// ErrForbidden means the token lacks a required scope, e.g. a session
+	// from before user-library-read was requested.
+	ErrForbidden = errors.New("spotify: forbidden")
+	// ErrNotFound means the requested resource doesn't exist or isn't
+	// visible to the user.
+	ErrNotFound = errors.New("spotify: not found")
+	// ErrRateLimited means Spotify kept answering 429 after all retries.
+	ErrRateLimited = errors.New("spotify: rate limited")
+	// ErrUnavailable means Spotify kept failing with 5xx after all retries.
+	ErrUnavailable = errors.New("spotify: service unavailable")
+)
+
+// StatusError is returned for non-2xx responses. It unwraps to one of the
+// sentinel errors above when the status has a specific meaning.
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+	// RetryAfter is the wait Spotify asked for on the last 429, if any.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("spotify: %s returned status %d", e.Endpoint, e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package spotify
+
+import "time"
+
+// WithRetryDelays shortens the backoff so tests don't wait on it.
+func WithRetryDelays(base, max time.Duration) Option {
+	return func(c *Client) {
+		c.baseDelay = base
+		c.maxDelay = max
+	}
+}
@@ -0,0 +1,413 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/metrics"
+	"github.com/kirkegaard/go-spotify-bingo/pkg/models"
+)
+
+// Maximum number of IDs /v1/albums and /v1/artists accept.
+const (
+	albumBatchSize  = 20
+	artistBatchSize = 50
+)
+
+// Paging is the Web API's generic paging object.
+type Paging[T any] struct {
+	Items []T     `json:"items"`
+	Next  *string `json:"next"`
+}
+
+type Album struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Artists     []Artist      `json:"artists"`
+	ReleaseDate string        `json:"release_date"`
+	Tracks      Paging[Track] `json:"tracks"`
+}
+
+type ArtistDetails struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Genres []string `json:"genres"`
+}
+
+type artistsResponse struct {
+	Artists []*ArtistDetails `json:"artists"`
+}
+
+type albumsResponse struct {
+	Albums []Album `json:"albums"`
+}
+
+type topTracksResponse struct {
+	Tracks []Track `json:"tracks"`
+}
+
+// GetAlbumTracks returns every track on an album.
+func (c *Client) GetAlbumTracks(ctx context.Context, albumID string) (models.PlaylistData, error) {
+	var album Album
+	if err := c.get(ctx, "albums/{id}", fmt.Sprintf("%s/v1/albums/%s", c.apiURL, url.PathEscape(albumID)), &album); err != nil {
+		return models.PlaylistData{}, fmt.Errorf("failed to get album: %w", err)
+	}
+
+	tracks, err := c.albumTracks(ctx, album)
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+
+	return models.PlaylistData{
+		PlaylistID:   albumID,
+		PlaylistName: album.Name,
+		Tracks:       tracks,
+	}, nil
+}
+
+// GetArtistTracks returns the tracks from an artist's albums and singles.
+// Compilations and "appears on" releases are left out since they are mostly
+// other artists' songs.
+func (c *Client) GetArtistTracks(ctx context.Context, artistID string) (models.PlaylistData, error) {
+	artist, err := c.GetArtist(ctx, artistID)
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+
+	var albumIDs []string
+	next := fmt.Sprintf("%s/v1/artists/%s/albums?include_groups=album,single&limit=50", c.apiURL, url.PathEscape(artistID))
+	for next != "" {
+		var page Paging[Album]
+		if err := c.get(ctx, "artists/{id}/albums", next, &page); err != nil {
+			return models.PlaylistData{}, fmt.Errorf("failed to get artist albums: %w", err)
+		}
+		for _, album := range page.Items {
+			albumIDs = append(albumIDs, album.ID)
+		}
+		next = nextURL(page.Next)
+	}
+
+	var tracks []models.Track
+	for start := 0; start < len(albumIDs); start += albumBatchSize {
+		batch := albumIDs[start:min(start+albumBatchSize, len(albumIDs))]
+		var resp albumsResponse
+		batchURL := fmt.Sprintf("%s/v1/albums?ids=%s", c.apiURL, url.QueryEscape(strings.Join(batch, ",")))
+		if err := c.get(ctx, "albums", batchURL, &resp); err != nil {
+			return models.PlaylistData{}, fmt.Errorf("failed to get albums: %w", err)
+		}
+		for _, album := range resp.Albums {
+			albumTracks, err := c.albumTracks(ctx, album)
+			if err != nil {
+				return models.PlaylistData{}, err
+			}
+			tracks = append(tracks, albumTracks...)
+		}
+	}
+
+	return models.PlaylistData{
+		PlaylistID:   artistID,
+		PlaylistName: artist.Name,
+		Tracks:       tracks,
+	}, nil
+}
+
+// GetArtistTopTracks returns the artist's most popular tracks in the user's
+// market. Spotify returns at most ten.
+func (c *Client) GetArtistTopTracks(ctx context.Context, artistID string) (models.PlaylistData, error) {
+	artist, err := c.GetArtist(ctx, artistID)
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+
+	var resp topTracksResponse
+	if err := c.get(ctx, "artists/{id}/top-tracks", fmt.Sprintf("%s/v1/artists/%s/top-tracks", c.apiURL, url.PathEscape(artistID)), &resp); err != nil {
+		return models.PlaylistData{}, fmt.Errorf("failed to get artist top tracks: %w", err)
+	}
+
+	var tracks []models.Track
+	for _, t := range resp.Tracks {
+		if track, ok := toModelTrack(t); ok {
+			tracks = append(tracks, track)
+		}
+	}
+
+	return models.PlaylistData{
+		PlaylistID:   artistID,
+		PlaylistName: artist.Name + " - Top Tracks",
+		Tracks:       tracks,
+	}, nil
+}
+
+// GetSavedTracks returns the user's Liked Songs. Requires the
+// user-library-read scope.
+func (c *Client) GetSavedTracks(ctx context.Context) (models.PlaylistData, error) {
+	var tracks []models.Track
+	next := c.apiURL + "/v1/me/tracks?limit=50"
+	for next != "" {
+		var page Paging[TrackItem]
+		if err := c.get(ctx, "me/tracks", next, &page); err != nil {
+			return models.PlaylistData{}, fmt.Errorf("failed to get saved tracks: %w", err)
+		}
+		for _, item := range page.Items {
+			if track, ok := toModelTrack(item.Track); ok {
+				tracks = append(tracks, track)
+			}
+		}
+		next = nextURL(page.Next)
+	}
+
+	return models.PlaylistData{
+		PlaylistID:   "saved",
+		PlaylistName: "Liked Songs",
+		Tracks:       tracks,
+	}, nil
+}
+
+func (c *Client) GetArtist(ctx context.Context, artistID string) (*ArtistDetails, error) {
+	var artist ArtistDetails
+	if err := c.get(ctx, "artists/{id}", fmt.Sprintf("%s/v1/artists/%s", c.apiURL, url.PathEscape(artistID)), &artist); err != nil {
+		return nil, fmt.Errorf("failed to get artist: %w", err)
+	}
+	return &artist, nil
+}
+
+// GetArtists looks up several artists, batching the IDs. Unknown IDs are
+// left out of the result.
+func (c *Client) GetArtists(ctx context.Context, artistIDs []string) ([]ArtistDetails, error) {
+	var artists []ArtistDetails
+	for start := 0; start < len(artistIDs); start += artistBatchSize {
+		batch := artistIDs[start:min(start+artistBatchSize, len(artistIDs))]
+		var resp artistsResponse
+		batchURL := fmt.Sprintf("%s/v1/artists?ids=%s", c.apiURL, url.QueryEscape(strings.Join(batch, ",")))
+		if err := c.get(ctx, "artists", batchURL, &resp); err != nil {
+			return nil, fmt.Errorf("failed to get artists: %w", err)
+		}
+		for _, a := range resp.Artists {
+			if a != nil {
+				artists = append(artists, *a)
+			}
+		}
+	}
+	return artists, nil
+}
+
+// addGenres fills in each track's genres from its artists, looking every
+// artist up once.
+func (c *Client) addGenres(ctx context.Context, tracks []models.Track) error {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, t := range tracks {
+		for _, id := range t.ArtistIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	artists, err := c.GetArtists(ctx, ids)
+	if err != nil {
+		return err
+	}
+	genres := make(map[string][]string, len(artists))
+	for _, a := range artists {
+		genres[a.ID] = a.Genres
+	}
+
+	for i, t := range tracks {
+		var trackGenres []string
+		have := make(map[string]bool)
+		for _, id := range t.ArtistIDs {
+			for _, g := range genres[id] {
+				if !have[g] {
+					have[g] = true
+					trackGenres = append(trackGenres, g)
+				}
+			}
+		}
+		tracks[i].Genres = trackGenres
+	}
+	return nil
+}
+
+// GetPlaylist returns a playlist's name and tracks.
+func (c *Client) GetPlaylist(ctx context.Context, playlistID string) (models.PlaylistData, error) {
+	playlist, err := c.GetPlaylistByID(ctx, playlistID)
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+
+	data, err := c.GetPlaylistTracks(ctx, playlistID)
+	if err != nil {
+		return models.PlaylistData{}, err
+	}
+	data.PlaylistName = playlist.Name
+	return data, nil
+}
+
+// GetPlaylistSnapshot returns only a playlist's name and snapshot ID, which
+// is cheap enough to check before every game.
+func (c *Client) GetPlaylistSnapshot(ctx context.Context, playlistID string) (*PlaylistItem, error) {
+	var playlist PlaylistItem
+	rawURL := fmt.Sprintf("%s/v1/playlists/%s?fields=id,name,snapshot_id", c.apiURL, url.PathEscape(playlistID))
+	if err := c.get(ctx, "playlists/{id}", rawURL, &playlist); err != nil {
+		return nil, fmt.Errorf("failed to get playlist: %w", err)
+	}
+	return &playlist, nil
+}
+
+// SourceStore caches fetched sources by snapshot so unchanged playlists
+// aren't downloaded again. *database.DB implements it.
+type SourceStore interface {
+	// LookupSource returns the stored tracks for src at src.SnapshotID.
+	LookupSource(ctx context.Context, src models.Source) (models.PlaylistData, bool, error)
+	// SaveSource stores a snapshot; saving one that exists is a no-op.
+	SaveSource(ctx context.Context, src models.Source, data models.PlaylistData) error
+}
+
+// FetchSource loads the tracks for a single source.
+func (c *Client) FetchSource(ctx context.Context, src models.Source) (models.PlaylistData, error) {
+	switch src.Type {
+	case models.SourceTypePlaylist:
+		return c.GetPlaylist(ctx, src.ID)
+	case models.SourceTypeAlbum:
+		return c.GetAlbumTracks(ctx, src.ID)
+	case models.SourceTypeArtist:
+		return c.GetArtistTracks(ctx, src.ID)
+	case models.SourceTypeArtistTopTracks:
+		return c.GetArtistTopTracks(ctx, src.ID)
+	case models.SourceTypeSavedTracks:
+		return c.GetSavedTracks(ctx)
+	}
+	return models.PlaylistData{}, fmt.Errorf("unknown source type %q", src.Type)
+}
+
+// FetchSources loads and merges several sources into one track list. Tracks
+// present in more than one source are kept once. The returned sources have
+// their display names and snapshot IDs filled in.
+//
+// With a store, every snapshot is saved, and playlists whose snapshot_id is
+// already stored are served from it without fetching their tracks. Other
+// source types have no cheap version check, so they are always fetched and
+// identified by a hash of their content.
+func (c *Client) FetchSources(ctx context.Context, sources []models.Source, store SourceStore) (models.PlaylistData, []models.Source, error) {
+	var merged models.PlaylistData
+	var names []string
+	resolved := make([]models.Source, 0, len(sources))
+	seen := make(map[string]bool)
+
+	for _, src := range sources {
+		data, src, err := c.fetchSnapshot(ctx, src, store)
+		if err != nil {
+			return models.PlaylistData{}, nil, err
+		}
+
+		resolved = append(resolved, src)
+		names = append(names, data.PlaylistName)
+
+		for _, track := range data.Tracks {
+			if seen[track.ID] {
+				continue
+			}
+			seen[track.ID] = true
+			merged.Tracks = append(merged.Tracks, track)
+		}
+	}
+
+	if len(resolved) == 1 {
+		merged.PlaylistID = resolved[0].ID
+	}
+	merged.PlaylistName = strings.Join(names, " + ")
+	return merged, resolved, nil
+}
+
+func (c *Client) fetchSnapshot(ctx context.Context, src models.Source, store SourceStore) (models.PlaylistData, models.Source, error) {
+	var data models.PlaylistData
+	if src.Type == models.SourceTypePlaylist {
+		playlist, err := c.GetPlaylistSnapshot(ctx, src.ID)
+		if err != nil {
+			return models.PlaylistData{}, src, err
+		}
+		src.Name = playlist.Name
+		src.SnapshotID = playlist.SnapshotID
+
+		if store != nil && src.SnapshotID != "" {
+			cached, ok, err := store.LookupSource(ctx, src)
+			if err != nil {
+				return models.PlaylistData{}, src, err
+			}
+			if ok {
+				metrics.SourceCacheLookups.Inc("hit")
+				cached.PlaylistName = playlist.Name
+				return cached, src, nil
+			}
+			metrics.SourceCacheLookups.Inc("miss")
+		}
+
+		data, err = c.GetPlaylistTracks(ctx, src.ID)
+		if err != nil {
+			return models.PlaylistData{}, src, err
+		}
+		data.PlaylistName = playlist.Name
+	} else {
+		var err error
+		data, err = c.FetchSource(ctx, src)
+		if err != nil {
+			return models.PlaylistData{}, src, err
+		}
+		src.Name = data.PlaylistName
+	}
+
+	if err := c.addGenres(ctx, data.Tracks); err != nil {
+		return models.PlaylistData{}, src, err
+	}
+	if src.SnapshotID == "" {
+		src.SnapshotID = models.ContentSnapshotID(data.Tracks)
+	}
+	if store != nil {
+		if err := store.SaveSource(ctx, src, data); err != nil {
+			return models.PlaylistData{}, src, err
+		}
+	}
+	return data, src, nil
+}
+
+// albumTracks returns an album's tracks, fetching further pages when the
+// embedded track list was truncated.
+func (c *Client) albumTracks(ctx context.Context, album Album) ([]models.Track, error) {
+	// Tracks in an album's listing don't embed the album, so it is filled
+	// in for the release date.
+	ref := &Album{ID: album.ID, Name: album.Name, Artists: album.Artists, ReleaseDate: album.ReleaseDate}
+	var tracks []models.Track
+	page := album.Tracks
+	for {
+		for _, t := range page.Items {
+			if t.Album == nil {
+				t.Album = ref
+			}
+			if track, ok := toModelTrack(t); ok {
+				tracks = append(tracks, track)
+			}
+		}
+		next := nextURL(page.Next)
+		if next == "" {
+			return tracks, nil
+		}
+		page = Paging[Track]{}
+		if err := c.get(ctx, "albums/{id}/tracks", next, &page); err != nil {
+			return nil, fmt.Errorf("failed to get album tracks: %w", err)
+		}
+	}
+}
+
+func nextURL(next *string) string {
+	if next == nil {
+		return ""
+	}
+	return *next
+}
@@ -0,0 +1,510 @@
+// Package spotifytest provides an in-process fake of the parts of the Spotify
+// Web API and accounts service that the bingo server uses, for offline tests.
+package spotifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kirkegaard/go-spotify-bingo/pkg/spotify"
+)
+
+const (
+	ClientID     = "test-client-id"
+	ClientSecret = "test-client-secret"
+	AuthCode     = "test-auth-code"
+	AccessToken  = "test-access-token"
+)
+
+// Route patterns, usable with Server.Fail.
+const (
+	RouteToken          = "POST /api/token"
+	RouteUserPlaylists  = "GET /v1/me/playlists"
+	RoutePlaylist       = "GET /v1/playlists/{id}"
+	RoutePlaylistTracks = "GET /v1/playlists/{id}/tracks"
+	RouteAlbum          = "GET /v1/albums/{id}"
+	RouteAlbumTracks    = "GET /v1/albums/{id}/tracks"
+	RouteAlbums         = "GET /v1/albums"
+	RouteArtists        = "GET /v1/artists"
+	RouteArtist         = "GET /v1/artists/{id}"
+	RouteArtistAlbums   = "GET /v1/artists/{id}/albums"
+	RouteArtistTop      = "GET /v1/artists/{id}/top-tracks"
+	RouteSavedTracks    = "GET /v1/me/tracks"
+)
+
+// Playlist is a playlist served by the fake.
+type Playlist struct {
+	ID     string
+	Name   string
+	Owner  string
+	Tracks []spotify.Track
+	// SnapshotID defaults to a hash of the track IDs, so replacing a
+	// playlist with different tracks changes it like the real API does.
+	SnapshotID string
+}
+
+func (p *Playlist) item() spotify.PlaylistItem {
+	return spotify.PlaylistItem{
+		ID:         p.ID,
+		Name:       p.Name,
+		SnapshotID: p.snapshotID(),
+		Owner:      spotify.User{ID: p.Owner, DisplayName: p.Owner},
+		Images:     []spotify.Image{{URL: "https://i.scdn.co/image/" + p.ID, Width: 300, Height: 300}},
+		Tracks:     spotify.TracksSummary{Total: len(p.Tracks)},
+	}
+}
+
+func (p *Playlist) snapshotID() string {
+	if p.SnapshotID != "" {
+		return p.SnapshotID
+	}
+	h := fnv.New64a()
+	for _, t := range p.Tracks {
+		h.Write([]byte(t.ID + "\x00"))
+	}
+	return fmt.Sprintf("snap%016x", h.Sum64())
+}
+
+// Album is an album served by the fake.
+type Album struct {
+	ID          string
+	Name        string
+	ReleaseDate string
+	Tracks      []spotify.Track
+}
+
+// Artist is an artist served by the fake. AlbumIDs refer to albums added
+// with AddAlbum.
+type Artist struct {
+	ID        string
+	Name      string
+	Genres    []string
+	AlbumIDs  []string
+	TopTracks []spotify.Track
+}
+
+// Fault is an injected error response.
+type Fault struct {
+	Status int
+	// RetryAfter is sent as the Retry-After header when non-empty.
+	RetryAfter string
+}
+
+// Server is a fake Spotify. Its URL serves as both the API and accounts
+// base URL.
+type Server struct {
+	*httptest.Server
+
+	// PageSize caps the number of items per page, regardless of the limit
+	// the client asks for, so pagination can be exercised with small data.
+	PageSize int
+
+	mu          sync.Mutex
+	playlists   map[string]*Playlist
+	order       []string
+	albums      map[string]*Album
+	artists     map[string]*Artist
+	savedTracks []spotify.Track
+	faults      map[string][]Fault
+	requests    map[string]int
+}
+
+// NewServer starts a fake Spotify. It is closed automatically when the test
+// finishes.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	s := &Server{
+		PageSize:  100,
+		playlists: make(map[string]*Playlist),
+		albums:    make(map[string]*Album),
+		artists:   make(map[string]*Artist),
+		faults:    make(map[string][]Fault),
+		requests:  make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	s.handle(mux, RouteToken, s.token)
+	s.handle(mux, RouteUserPlaylists, s.authorized(s.userPlaylists))
+	s.handle(mux, RoutePlaylist, s.authorized(s.playlist))
+	s.handle(mux, RoutePlaylistTracks, s.authorized(s.playlistTracks))
+	s.handle(mux, RouteAlbum, s.authorized(s.album))
+	s.handle(mux, RouteAlbumTracks, s.authorized(s.albumTracks))
+	s.handle(mux, RouteAlbums, s.authorized(s.severalAlbums))
+	s.handle(mux, RouteArtists, s.authorized(s.severalArtists))
+	s.handle(mux, RouteArtist, s.authorized(s.artist))
+	s.handle(mux, RouteArtistAlbums, s.authorized(s.artistAlbums))
+	s.handle(mux, RouteArtistTop, s.authorized(s.artistTopTracks))
+	s.handle(mux, RouteSavedTracks, s.authorized(s.savedTracksPage))
+
+	s.Server = httptest.NewServer(mux)
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// AddPlaylist makes a playlist available and lists it in the user's
+// playlists.
+func (s *Server) AddPlaylist(p Playlist) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.playlists[p.ID]; !ok {
+		s.order = append(s.order, p.ID)
+	}
+	s.playlists[p.ID] = &p
+}
+
+func (s *Server) AddAlbum(a Album) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.albums[a.ID] = &a
+}
+
+func (s *Server) AddArtist(a Artist) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artists[a.ID] = &a
+}
+
+// SetSavedTracks replaces the user's Liked Songs.
+func (s *Server) SetSavedTracks(tracks []spotify.Track) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.savedTracks = tracks
+}
+
+// Fail queues error responses for a route. Each fault is used once, in
+// order, before the route goes back to normal.
+func (s *Server) Fail(route string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[route] = append(s.faults[route], faults...)
+}
+
+// Requests reports how many requests a route has received, including
+// failed ones.
+func (s *Server) Requests(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[route]
+}
+
+// Tracks builds n tracks named "Track 1".."Track n" by artists "Artist 1"..,
+// enough to satisfy the generator's uniqueness requirements.
+func Tracks(n int) []spotify.Track {
+	return NamedTracks("Track", n)
+}
+
+// NamedTracks is like Tracks but uses prefix in IDs, titles and artist
+// names, so tracks from different sources don't collide.
+func NamedTracks(prefix string, n int) []spotify.Track {
+	tracks := make([]spotify.Track, n)
+	for i := range tracks {
+		tracks[i] = spotify.Track{
+			ID:      fmt.Sprintf("%s%d", strings.ToLower(prefix), i+1),
+			Name:    fmt.Sprintf("%s %d", prefix, i+1),
+			Artists: []spotify.Artist{{Name: fmt.Sprintf("%s Artist %d", prefix, i+1)}},
+		}
+	}
+	return tracks
+}
+
+func (s *Server) handle(mux *http.ServeMux, route string, h http.HandlerFunc) {
+	mux.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[route]++
+		var fault *Fault
+		if queue := s.faults[route]; len(queue) > 0 {
+			fault = &queue[0]
+			s.faults[route] = queue[1:]
+		}
+		s.mu.Unlock()
+
+		if fault != nil {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			writeError(w, fault.Status, http.StatusText(fault.Status))
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != ClientID || secret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != AuthCode {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeJSON(w, http.StatusOK, spotify.TokenResponse{
+		AccessToken:  AccessToken,
+		TokenType:    "Bearer",
+		Scope:        "playlist-read-private playlist-read-collaborative",
+		ExpiresIn:    3600,
+		RefreshToken: "test-refresh-token",
+	})
+}
+
+func (s *Server) userPlaylists(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	items := make([]spotify.PlaylistItem, 0, len(s.order))
+	for _, id := range s.order {
+		items = append(items, s.playlists[id].item())
+	}
+	s.mu.Unlock()
+
+	page, next := s.paginate(r, len(items))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": items[page.start:page.end],
+		"next":  next,
+		"total": len(items),
+	})
+}
+
+func (s *Server) playlist(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookup(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, p.item())
+}
+
+func (s *Server) playlistTracks(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.lookup(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+
+	page, next := s.paginate(r, len(p.Tracks))
+	items := make([]spotify.TrackItem, 0, page.end-page.start)
+	for _, t := range p.Tracks[page.start:page.end] {
+		items = append(items, spotify.TrackItem{Track: t})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": items,
+		"next":  next,
+		"total": len(p.Tracks),
+	})
+}
+
+func (s *Server) lookup(id string) (Playlist, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.playlists[id]
+	if !ok {
+		return Playlist{}, false
+	}
+	return *p, true
+}
+
+type pageRange struct{ start, end int }
+
+// paginate reads offset/limit like the real API and returns the slice bounds
+// plus the absolute URL of the next page, or nil on the last page.
+func (s *Server) paginate(r *http.Request, total int) (pageRange, *string) {
+	return s.paginatePath(r.URL.Path, r.URL.Query(), total)
+}
+
+func (s *Server) paginatePath(path string, q url.Values, total int) (pageRange, *string) {
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > s.PageSize {
+		limit = s.PageSize
+	}
+	offset = min(max(offset, 0), total)
+	end := min(offset+limit, total)
+	if end >= total {
+		return pageRange{offset, end}, nil
+	}
+
+	next := url.Values{}
+	for k, v := range q {
+		next[k] = v
+	}
+	next.Set("offset", strconv.Itoa(end))
+	next.Set("limit", strconv.Itoa(limit))
+	nextURL := s.URL + path + "?" + next.Encode()
+	return pageRange{offset, end}, &nextURL
+}
+
+func (s *Server) album(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.lookupAlbum(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.albumObject(a))
+}
+
+func (s *Server) albumTracks(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.lookupAlbum(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	page, next := s.paginate(r, len(a.Tracks))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": a.Tracks[page.start:page.end],
+		"next":  next,
+		"total": len(a.Tracks),
+	})
+}
+
+func (s *Server) severalAlbums(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) > 20 {
+		writeError(w, http.StatusBadRequest, "Too many ids requested")
+		return
+	}
+	albums := make([]any, 0, len(ids))
+	for _, id := range ids {
+		if a, ok := s.lookupAlbum(id); ok {
+			albums = append(albums, s.albumObject(a))
+		} else {
+			albums = append(albums, nil)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"albums": albums})
+}
+
+// albumObject renders an album with its first page of tracks embedded, the
+// way the real API does.
+func (s *Server) albumObject(a Album) map[string]any {
+	page, next := s.paginatePath("/v1/albums/"+a.ID+"/tracks", url.Values{}, len(a.Tracks))
+	return map[string]any{
+		"id":           a.ID,
+		"name":         a.Name,
+		"release_date": a.ReleaseDate,
+		"tracks": map[string]any{
+			"items": a.Tracks[page.start:page.end],
+			"next":  next,
+			"total": len(a.Tracks),
+		},
+	}
+}
+
+func (s *Server) artist(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.lookupArtist(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, spotify.ArtistDetails{ID: a.ID, Name: a.Name, Genres: a.Genres})
+}
+
+func (s *Server) severalArtists(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) > 50 {
+		writeError(w, http.StatusBadRequest, "Too many ids requested")
+		return
+	}
+	artists := make([]any, 0, len(ids))
+	for _, id := range ids {
+		if a, ok := s.lookupArtist(id); ok {
+			artists = append(artists, spotify.ArtistDetails{ID: a.ID, Name: a.Name, Genres: a.Genres})
+		} else {
+			artists = append(artists, nil)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"artists": artists})
+}
+
+func (s *Server) artistAlbums(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.lookupArtist(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	page, next := s.paginate(r, len(a.AlbumIDs))
+	items := make([]map[string]string, 0, page.end-page.start)
+	for _, id := range a.AlbumIDs[page.start:page.end] {
+		items = append(items, map[string]string{"id": id})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": items,
+		"next":  next,
+		"total": len(a.AlbumIDs),
+	})
+}
+
+func (s *Server) artistTopTracks(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.lookupArtist(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": a.TopTracks})
+}
+
+func (s *Server) savedTracksPage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	tracks := s.savedTracks
+	s.mu.Unlock()
+
+	page, next := s.paginate(r, len(tracks))
+	items := make([]spotify.TrackItem, 0, page.end-page.start)
+	for _, t := range tracks[page.start:page.end] {
+		items = append(items, spotify.TrackItem{Track: t})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items": items,
+		"next":  next,
+		"total": len(tracks),
+	})
+}
+
+func (s *Server) lookupAlbum(id string) (Album, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.albums[id]
+	if !ok {
+		return Album{}, false
+	}
+	return *a, true
+}
+
+func (s *Server) lookupArtist(id string) (Artist, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.artists[id]
+	if !ok {
+		return Artist{}, false
+	}
+	return *a, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError mimics the Web API's regular error object.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{"status": status, "message": message},
+	})
+}
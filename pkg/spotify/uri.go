@@ -0,0 +1,172 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ResourceType string
+
+const (
+	ResourcePlaylist ResourceType = "playlist"
+	ResourceAlbum    ResourceType = "album"
+	ResourceArtist   ResourceType = "artist"
+	ResourceTrack    ResourceType = "track"
+)
+
+// Resource identifies a Spotify object by type and base62 ID.
+type Resource struct {
+	Type ResourceType
+	ID   string
+}
+
+func (r Resource) URI() string {
+	return "spotify:" + string(r.Type) + ":" + r.ID
+}
+
+var (
+	ErrInvalidURI = errors.New("spotify: not a recognised Spotify link or URI")
+	// ErrShortLink is returned for spotify.link / spoti.fi links, which only
+	// reveal their target via an HTTP redirect. Use Client.ResolveShortLink.
+	ErrShortLink = errors.New("spotify: short link must be resolved")
+)
+
+const idLength = 22
+
+var shortLinkHosts = map[string]bool{
+	"spotify.link":     true,
+	"spotify.app.link": true,
+	"spoti.fi":         true,
+}
+
+// ParseResourceURI extracts the resource type and ID from any of the forms
+// users paste:
+//
+//	spotify:playlist:37i9dQZF1DXcBWIGoYBM5M
+//	spotify:user:someone:playlist:37i9dQZF1DXcBWIGoYBM5M
+//	https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M?si=abc
+//	https://open.spotify.com/intl-de/album/1DFixLWuPkv3KT3TnV35m3
+//	https://open.spotify.com/embed/artist/0OdUWJ0sBjDrqHygGUXeCF
+//	open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC
+//
+// Short links (spotify.link/...) return ErrShortLink.
+func ParseResourceURI(s string) (Resource, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Resource{}, ErrInvalidURI
+	}
+
+	if rest, ok := cutPrefixFold(s, "spotify:"); ok {
+		return parseSegments(strings.Split(rest, ":"))
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return Resource{}, ErrInvalidURI
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Resource{}, ErrInvalidURI
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if shortLinkHosts[host] {
+		return Resource{}, ErrShortLink
+	}
+	if host != "open.spotify.com" && host != "play.spotify.com" {
+		return Resource{}, ErrInvalidURI
+	}
+
+	return parseSegments(strings.Split(strings.Trim(u.Path, "/"), "/"))
+}
+
+// parseSegments finds the last "<type>/<id>" pair in a path or URI,
+// skipping locale prefixes (intl-xx), embed/ and legacy user/<name>/ parts.
+func parseSegments(segments []string) (Resource, error) {
+	for i := len(segments) - 2; i >= 0; i-- {
+		typ := ResourceType(strings.ToLower(segments[i]))
+		switch typ {
+		case ResourcePlaylist, ResourceAlbum, ResourceArtist, ResourceTrack:
+		default:
+			continue
+		}
+		id := segments[i+1]
+		if !validID(id) {
+			return Resource{}, fmt.Errorf("%w: invalid %s ID %q", ErrInvalidURI, typ, id)
+		}
+		return Resource{Type: typ, ID: id}, nil
+	}
+	return Resource{}, ErrInvalidURI
+}
+
+func validID(id string) bool {
+	if len(id) != idLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// ResolveShortLink follows the redirects of a spotify.link style URL until
+// it reaches a parseable open.spotify.com link. Other links are parsed
+// directly without a network round trip.
+func (c *Client) ResolveShortLink(ctx context.Context, link string) (Resource, error) {
+	res, err := ParseResourceURI(link)
+	if !errors.Is(err, ErrShortLink) {
+		return res, err
+	}
+
+	noFollow := *c.httpClient
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	current := strings.TrimSpace(link)
+	if !strings.Contains(current, "://") {
+		current = "https://" + current
+	}
+	for range 5 {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, current, nil)
+		if err != nil {
+			return Resource{}, err
+		}
+		resp, err := noFollow.Do(req)
+		if err != nil {
+			return Resource{}, fmt.Errorf("failed to resolve short link: %w", err)
+		}
+		resp.Body.Close()
+
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return Resource{}, fmt.Errorf("%w: short link did not redirect", ErrInvalidURI)
+		}
+		next, err := resp.Request.URL.Parse(location)
+		if err != nil {
+			return Resource{}, ErrInvalidURI
+		}
+
+		res, err := ParseResourceURI(next.String())
+		if !errors.Is(err, ErrShortLink) {
+			return res, err
+		}
+		current = next.String()
+	}
+	return Resource{}, fmt.Errorf("%w: too many redirects", ErrInvalidURI)
+}
@@ -0,0 +1,132 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseResourceURI(t *testing.T) {
+	const id = "37i9dQZF1DXcBWIGoYBM5M"
+
+	tests := []struct {
+		name    string
+		input   string
+		want    Resource
+		wantErr error
+	}{
+		// Web links
+		{"playlist url", "https://open.spotify.com/playlist/" + id, Resource{ResourcePlaylist, id}, nil},
+		{"playlist url with si", "https://open.spotify.com/playlist/" + id + "?si=a1b2c3d4e5f6", Resource{ResourcePlaylist, id}, nil},
+		{"playlist url with fragment", "https://open.spotify.com/playlist/" + id + "#top", Resource{ResourcePlaylist, id}, nil},
+		{"trailing slash", "https://open.spotify.com/playlist/" + id + "/", Resource{ResourcePlaylist, id}, nil},
+		{"http scheme", "http://open.spotify.com/playlist/" + id, Resource{ResourcePlaylist, id}, nil},
+		{"no scheme", "open.spotify.com/playlist/" + id, Resource{ResourcePlaylist, id}, nil},
+		{"uppercase host", "https://OPEN.SPOTIFY.COM/playlist/" + id, Resource{ResourcePlaylist, id}, nil},
+		{"surrounding whitespace", "  https://open.spotify.com/playlist/" + id + "\n", Resource{ResourcePlaylist, id}, nil},
+		{"intl locale", "https://open.spotify.com/intl-de/playlist/" + id, Resource{ResourcePlaylist, id}, nil},
+		{"intl locale with region", "https://open.spotify.com/intl-pt-BR/album/" + id, Resource{ResourceAlbum, id}, nil},
+		{"embed", "https://open.spotify.com/embed/playlist/" + id + "?utm_source=generator", Resource{ResourcePlaylist, id}, nil},
+		{"legacy user playlist", "https://open.spotify.com/user/spotify/playlist/" + id, Resource{ResourcePlaylist, id}, nil},
+		{"play host", "https://play.spotify.com/album/" + id, Resource{ResourceAlbum, id}, nil},
+		{"album", "https://open.spotify.com/album/" + id, Resource{ResourceAlbum, id}, nil},
+		{"artist", "https://open.spotify.com/artist/" + id, Resource{ResourceArtist, id}, nil},
+		{"track", "https://open.spotify.com/track/" + id + "?si=x", Resource{ResourceTrack, id}, nil},
+
+		// URIs
+		{"playlist uri", "spotify:playlist:" + id, Resource{ResourcePlaylist, id}, nil},
+		{"album uri", "spotify:album:" + id, Resource{ResourceAlbum, id}, nil},
+		{"artist uri", "spotify:artist:" + id, Resource{ResourceArtist, id}, nil},
+		{"track uri", "spotify:track:" + id, Resource{ResourceTrack, id}, nil},
+		{"legacy user playlist uri", "spotify:user:someone:playlist:" + id, Resource{ResourcePlaylist, id}, nil},
+		{"uri case insensitive prefix", "Spotify:Playlist:" + id, Resource{ResourcePlaylist, id}, nil},
+
+		// Short links need a redirect
+		{"spotify.link", "https://spotify.link/AbCdEfGhIjK", Resource{}, ErrShortLink},
+		{"spotify.link no scheme", "spotify.link/AbCdEfGhIjK", Resource{}, ErrShortLink},
+		{"spoti.fi", "https://spoti.fi/3xYz", Resource{}, ErrShortLink},
+
+		// Rejected
+		{"empty", "", Resource{}, ErrInvalidURI},
+		{"bare id", id, Resource{}, ErrInvalidURI},
+		{"other host", "https://example.com/playlist/" + id, Resource{}, ErrInvalidURI},
+		{"lookalike host", "https://open.spotify.com.evil.com/playlist/" + id, Resource{}, ErrInvalidURI},
+		{"unsupported type", "https://open.spotify.com/show/" + id, Resource{}, ErrInvalidURI},
+		{"unsupported uri type", "spotify:episode:" + id, Resource{}, ErrInvalidURI},
+		{"short id", "https://open.spotify.com/playlist/abc123", Resource{}, ErrInvalidURI},
+		{"long id", "https://open.spotify.com/playlist/" + id + "X", Resource{}, ErrInvalidURI},
+		{"non base62 id", "https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5-", Resource{}, ErrInvalidURI},
+		{"missing id", "https://open.spotify.com/playlist/", Resource{}, ErrInvalidURI},
+		{"ftp scheme", "ftp://open.spotify.com/playlist/" + id, Resource{}, ErrInvalidURI},
+		{"uri missing id", "spotify:playlist", Resource{}, ErrInvalidURI},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResourceURI(tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParseResourceURI(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseResourceURI(%q) unexpected error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseResourceURI(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceURI(t *testing.T) {
+	r := Resource{Type: ResourceAlbum, ID: "1DFixLWuPkv3KT3TnV35m3"}
+	if got, want := r.URI(), "spotify:album:1DFixLWuPkv3KT3TnV35m3"; got != want {
+		t.Errorf("URI() = %q, want %q", got, want)
+	}
+}
+
+type redirectTransport map[string]string
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if location, ok := rt[req.URL.String()]; ok {
+		resp.StatusCode = http.StatusTemporaryRedirect
+		resp.Header.Set("Location", location)
+	}
+	return resp, nil
+}
+
+func TestResolveShortLink(t *testing.T) {
+	const id = "1DFixLWuPkv3KT3TnV35m3"
+	client := NewClient("token", WithHTTPClient(&http.Client{Transport: redirectTransport{
+		"https://spotify.link/abc":          "https://spotify.app.link/abc?_p=1",
+		"https://spotify.app.link/abc?_p=1": "https://open.spotify.com/album/" + id + "?si=x",
+		"https://spotify.link/dead":         "",
+	}}))
+
+	got, err := client.ResolveShortLink(context.Background(), "spotify.link/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Resource{ResourceAlbum, id}); got != want {
+		t.Errorf("ResolveShortLink = %+v, want %+v", got, want)
+	}
+
+	if _, err := client.ResolveShortLink(context.Background(), "https://spotify.link/dead"); !errors.Is(err, ErrInvalidURI) {
+		t.Errorf("dead short link error = %v, want ErrInvalidURI", err)
+	}
+
+	// Regular links are parsed without touching the network.
+	got, err = client.ResolveShortLink(context.Background(), "spotify:playlist:"+id)
+	if err != nil || got.Type != ResourcePlaylist {
+		t.Errorf("ResolveShortLink(uri) = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package trackname strips release noise such as "- Radio Edit" or
+// "(2011 Remaster)" from track titles so bingo fields show the song name
+// people actually shout. Cleaning is driven by named regular expression
+// rules, compiled once, and every removal is attributed to the rule that
+// made it.
+package trackname
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Rule removes every match of Pattern from a title.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+
+	re *regexp.Regexp
+}
+
+// Removal records text taken out of a title and the rule responsible.
+type Removal struct {
+	Rule string `json:"rule"`
+	Text string `json:"text"`
+}
+
+type Result struct {
+	Original string    `json:"original"`
+	Cleaned  string    `json:"cleaned"`
+	Removals []Removal `json:"removals,omitempty"`
+}
+
+// Cleaner applies a compiled rule set in order. It is safe for concurrent
+// use.
+type Cleaner struct {
+	rules []Rule
+}
+
+// qualifiers are the words that mark a version suffix. A suffix is only
+// removed when it ends in one of them (optionally followed by a year), so
+// titles like "Mix Tape" or "(1999)" survive.
+const qualifiers = `(?:mix|remix|edit|version|remaster(?:ed)?)(?:\s+\d{4})?`
+
+// DefaultRules is the built-in rule set.
+var DefaultRules = []Rule{
+	{Name: "featuring-brackets", Pattern: `(?i)\s*[(\[](?:feat\.?|ft\.?|featuring)\s[^)\]]*[)\]]`},
+	{Name: "featuring-dash", Pattern: `(?i)\s+-\s+(?:feat\.?|ft\.?|featuring)\s.*$`},
+	{Name: "version-brackets", Pattern: `(?i)\s*[(\[][^()\[\]]*\b` + qualifiers + `\s*[)\]]`},
+	{Name: "version-dash", Pattern: `(?i)\s+-\s+[^-]*\b` + qualifiers + `\s*$`},
+	{Name: "original-brackets", Pattern: `(?i)\s*[(\[]original[)\]]`},
+	{Name: "original-dash", Pattern: `(?i)\s+-\s+original\s*$`},
+}
+
+var (
+	defaultCleaner = MustNew(DefaultRules)
+	spaces         = regexp.MustCompile(`\s+`)
+)
+
+// Default returns a cleaner using DefaultRules.
+func Default() *Cleaner {
+	return defaultCleaner
+}
+
+// New compiles rules into a cleaner.
+func New(rules []Rule) (*Cleaner, error) {
+	seen := make(map[string]bool)
+	compiled := make([]Rule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("trackname: rule %d has no name", i+1)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("trackname: duplicate rule %q", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("trackname: rule %q: %w", rule.Name, err)
+		}
+		rule.re = re
+		compiled = append(compiled, rule)
+	}
+	return &Cleaner{rules: compiled}, nil
+}
+
+func MustNew(rules []Rule) *Cleaner {
+	c, err := New(rules)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// File is the format of a rule set file:
+//
+//	{
+//	  "include_defaults": true,
+//	  "disable": ["featuring-dash"],
+//	  "rules": [{"name": "live", "pattern": "(?i)\\s*\\(live[^)]*\\)"}]
+//	}
+//
+// Rules from the file run after the enabled default rules.
+type File struct {
+	IncludeDefaults bool     `json:"include_defaults"`
+	Disable         []string `json:"disable"`
+	Rules           []Rule   `json:"rules"`
+}
+
+// LoadFile reads a rule set file. An empty path returns the default
+// cleaner.
+func LoadFile(path string) (*Cleaner, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("trackname: %w", err)
+	}
+	var f File
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("trackname: parsing %s: %w", path, err)
+	}
+
+	var rules []Rule
+	if f.IncludeDefaults {
+		disabled := make(map[string]bool)
+		for _, name := range f.Disable {
+			disabled[name] = true
+		}
+		for _, rule := range DefaultRules {
+			if !disabled[rule.Name] {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	rules = append(rules, f.Rules...)
+	return New(rules)
+}
+
+// Rules returns the rule set in the order it is applied.
+func (c *Cleaner) Rules() []Rule {
+	return append([]Rule(nil), c.rules...)
+}
+
+// Clean applies every rule in order. If cleaning would leave nothing, the
+// original title is kept and no removals are reported.
+func (c *Cleaner) Clean(name string) Result {
+	result := Result{Original: name}
+	cleaned := name
+	for _, rule := range c.rules {
+		matches := rule.re.FindAllString(cleaned, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		for _, m := range matches {
+			if m = strings.TrimSpace(m); m != "" {
+				result.Removals = append(result.Removals, Removal{Rule: rule.Name, Text: m})
+			}
+		}
+		cleaned = rule.re.ReplaceAllString(cleaned, "")
+	}
+
+	cleaned = strings.TrimSpace(spaces.ReplaceAllString(cleaned, " "))
+	if cleaned == "" {
+		return Result{Original: name, Cleaned: name}
+	}
+	result.Cleaned = cleaned
+	return result
+}
+
+// CleanName returns just the cleaned title.
+func (c *Cleaner) CleanName(name string) string {
+	return c.Clean(name).Cleaned
+}
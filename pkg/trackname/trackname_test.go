@@ -0,0 +1,103 @@
+package trackname
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultClean(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		// Version suffixes are removed.
+		{"Blue Monday - 2016 Remaster", "Blue Monday"},
+		{"Heroes - Remastered 2017", "Heroes"},
+		{"Sandstorm (Original Mix)", "Sandstorm"},
+		{"Blue Monday (12\" Version)", "Blue Monday"},
+		{"Around the World (Radio Edit)", "Around the World"},
+		{"Wonderwall - Radio Edit / Remastered 2025", "Wonderwall"},
+		{"One More Time [Club Mix]", "One More Time"},
+		{"Hung Up - Single Version", "Hung Up"},
+		{"Levels - Original", "Levels"},
+		{"Levels (Original)", "Levels"},
+		{"Lean On (feat. MØ & DJ Snake)", "Lean On"},
+		{"Titanium - feat. Sia", "Titanium"},
+		{"Get Lucky (feat. Pharrell Williams) - Radio Edit", "Get Lucky"},
+
+		// Real titles are left alone.
+		{"Mix Tape", "Mix Tape"},
+		{"Brand New - Mix Tape", "Brand New - Mix Tape"},
+		{"(1999)", "(1999)"},
+		{"Party Like (1999)", "Party Like (1999)"},
+		{"Happy Xmas (War Is Over)", "Happy Xmas (War Is Over)"},
+		{"Auld Lang Syne (New Year's Version Of A Song)", "Auld Lang Syne (New Year's Version Of A Song)"},
+		{"Re-Edit", "Re-Edit"},
+		{"Remix", "Remix"},
+		{"Don't Stop Me Now - Live at Wembley", "Don't Stop Me Now - Live at Wembley"},
+
+		// Whitespace is tidied, and a title is never emptied.
+		{"  Spaced   Out  ", "Spaced Out"},
+		{"(Radio Edit)", "(Radio Edit)"},
+	}
+
+	for _, tt := range tests {
+		if got := Default().CleanName(tt.in); got != tt.want {
+			t.Errorf("CleanName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCleanAttributesRemovals(t *testing.T) {
+	got := Default().Clean("Get Lucky (feat. Pharrell Williams) - Radio Edit")
+	want := []Removal{
+		{Rule: "featuring-brackets", Text: "(feat. Pharrell Williams)"},
+		{Rule: "version-dash", Text: "- Radio Edit"},
+	}
+	if !reflect.DeepEqual(got.Removals, want) {
+		t.Errorf("removals = %+v, want %+v", got.Removals, want)
+	}
+
+	if got := Default().Clean("(Radio Edit)"); got.Removals != nil {
+		t.Errorf("emptied title reported removals: %+v", got.Removals)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(`{
+		"include_defaults": true,
+		"disable": ["featuring-brackets"],
+		"rules": [{"name": "live", "pattern": "(?i)\\s+-\\s+live\\b.*$"}]
+	}`), 0o644)
+
+	c, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.CleanName("Lean On (feat. MØ) - Live at Roskilde"), "Lean On (feat. MØ)"; got != want {
+		t.Errorf("CleanName = %q, want %q", got, want)
+	}
+	if got := c.Clean("Song - Live").Removals; len(got) != 1 || got[0].Rule != "live" {
+		t.Errorf("removals = %+v, want one by live", got)
+	}
+
+	c, err = LoadFile("")
+	if err != nil || c != Default() {
+		t.Errorf("LoadFile(\"\") = %p, %v, want default", c, err)
+	}
+}
+
+func TestNewRejectsBadRules(t *testing.T) {
+	tests := map[string][]Rule{
+		"unnamed":   {{Pattern: "x"}},
+		"duplicate": {{Name: "a", Pattern: "x"}, {Name: "a", Pattern: "y"}},
+		"bad regex": {{Name: "a", Pattern: "("}},
+	}
+	for name, rules := range tests {
+		if _, err := New(rules); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
@@ -27,27 +27,74 @@ function showAuthSection() {
     };
 }
 
+let userPlaylists = [];
+let playlistSearchTimer = null;
+
 function showAuthenticatedSection(userData) {
     document.getElementById('auth-section').style.display = 'none';
     document.getElementById('authenticated-section').style.display = 'block';
     
-    // Populate playlists dropdown
+    userPlaylists = userData.playlists || [];
+    renderPlaylistOptions();
+    
+    // Re-filter when the game size changes, and search server-side as the
+    // host types
+    document.getElementById('player-count').addEventListener('input', renderPlaylistOptions);
+    document.getElementById('plates-per-player').addEventListener('input', renderPlaylistOptions);
+    document.getElementById('playlist-search').addEventListener('input', function() {
+        clearTimeout(playlistSearchTimer);
+        playlistSearchTimer = setTimeout(() => searchPlaylists(this.value.trim()), 300);
+    });
+}
+
+// Must match the server's minimum track check in CreateGame
+function requiredTrackCount() {
+    const playerCount = parseInt(document.getElementById('player-count').value) || 1;
+    const platesPerPlayer = parseInt(document.getElementById('plates-per-player').value) || 1;
+    return playerCount * platesPerPlayer * 5;
+}
+
+async function searchPlaylists(query) {
+    try {
+        const response = await fetch(`/api/playlists?q=${encodeURIComponent(query)}`);
+        if (response.ok) {
+            const data = await response.json();
+            userPlaylists = data.playlists || [];
+            renderPlaylistOptions();
+        }
+    } catch (error) {
+        // Keep showing the previous list
+    }
+}
+
+function renderPlaylistOptions() {
     const playlistSelect = document.getElementById('playlist-select');
+    const selected = playlistSelect.value;
+    const required = requiredTrackCount();
     playlistSelect.innerHTML = '<option value="">Select a playlist...</option>';
     
-    if (userData.playlists && userData.playlists.length > 0) {
-        userData.playlists.forEach(playlist => {
+    const usable = userPlaylists.filter(playlist => playlist.track_count >= required);
+    if (usable.length > 0) {
+        usable.forEach(playlist => {
             const option = document.createElement('option');
             option.value = playlist.id;
-            option.textContent = playlist.name;
+            option.textContent = `${playlist.name} (${playlist.track_count} tracks)`;
+            if (playlist.owner) {
+                option.title = `By ${playlist.owner}`;
+            }
+            option.selected = playlist.id === selected;
             playlistSelect.appendChild(option);
         });
     } else {
         const option = document.createElement('option');
-        option.textContent = 'No playlists found';
+        option.textContent = userPlaylists.length > 0 ? `No playlists with at least ${required} tracks` : 'No playlists found';
         option.disabled = true;
         playlistSelect.appendChild(option);
     }
+    
+    const hidden = userPlaylists.length - usable.length;
+    document.getElementById('playlist-hidden-note').textContent =
+        hidden > 0 ? `${hidden} playlist(s) hidden: too few tracks for this game size` : '';
 }
 
 function showLoading() {
@@ -70,4 +117,22 @@ function showError(message) {
 
 function hideError() {
     document.getElementById('error-message').style.display = 'none';
-}
\ No newline at end of file
+}
+
+// Reads the API error envelope from a failed response. Falls back to the
+// given message when the body isn't one.
+async function readApiError(response, fallback) {
+    try {
+        const body = await response.json();
+        if (body && body.error) {
+            return {
+                code: body.error.code,
+                message: body.error.message || fallback,
+                details: body.error.details || {}
+            };
+        }
+    } catch (e) {
+        // Not JSON
+    }
+    return { code: 'unknown', message: fallback, details: {} };
+}
@@ -1,6 +1,8 @@
 document.addEventListener('DOMContentLoaded', function() {
     const createGameForm = document.getElementById('create-game-form');
     const joinGameForm = document.getElementById('join-game-form');
+    const importGameForm = document.getElementById('import-game-form');
+    const redeemTransferForm = document.getElementById('redeem-transfer-form');
     
     if (createGameForm) {
         createGameForm.addEventListener('submit', handleCreateGame);
@@ -9,6 +11,14 @@ document.addEventListener('DOMContentLoaded', function() {
     if (joinGameForm) {
         joinGameForm.addEventListener('submit', handleJoinGame);
     }
+    
+    if (importGameForm) {
+        importGameForm.addEventListener('submit', handleImportGame);
+    }
+
+    if (redeemTransferForm) {
+        redeemTransferForm.addEventListener('submit', handleRedeemTransfer);
+    }
 });
 
 async function handleCreateGame(event) {
@@ -17,27 +27,41 @@ async function handleCreateGame(event) {
     showLoading();
     
     const playlistSelect = document.getElementById('playlist-select');
-    const playlistUrl = document.getElementById('playlist-url').value.trim();
+    const links = document.getElementById('playlist-url').value
+        .split('\n')
+        .map(line => line.trim())
+        .filter(line => line !== '');
+    const artistTopTracks = document.getElementById('artist-top-tracks').checked;
+    const includeLiked = document.getElementById('include-liked-songs').checked;
     const playerCount = parseInt(document.getElementById('player-count').value);
     const platesPerPlayer = parseInt(document.getElementById('plates-per-player').value);
     const contentType = document.getElementById('content-type').value;
     
-    const requestData = {
-        player_count: playerCount,
-        plates_per_player: platesPerPlayer,
-        content_type: contentType
-    };
+    const sources = [];
+    if (playlistSelect.value) {
+        sources.push({ type: 'playlist', id: playlistSelect.value });
+    }
+    links.forEach(link => {
+        sources.push(artistTopTracks ? { type: 'artist_top_tracks', url: link } : { url: link });
+    });
+    if (includeLiked) {
+        sources.push({ type: 'saved_tracks' });
+    }
     
-    if (playlistUrl) {
-        requestData.playlist_url = playlistUrl;
-    } else if (playlistSelect.value) {
-        requestData.playlist_id = playlistSelect.value;
-    } else {
+    if (sources.length === 0) {
         hideLoading();
-        showError('Please select a playlist or enter a playlist URL');
+        showError('Please select a playlist, paste a Spotify link or include your Liked Songs');
         return;
     }
     
+    const requestData = {
+        sources: sources,
+        player_count: playerCount,
+        plates_per_player: platesPerPlayer,
+        content_type: contentType,
+        difficulty: document.getElementById('difficulty').value
+    };
+    
     try {
         const response = await fetch('/api/games', {
             method: 'POST',
@@ -54,8 +78,50 @@ async function handleCreateGame(event) {
             // Redirect to game view
             window.location.href = `/game-view.html?code=${gameData.game_code}`;
         } else {
-            const errorText = await response.text();
-            showError(errorText || 'Failed to create game');
+            const apiError = await readApiError(response, 'Failed to create game');
+            if (apiError.code === 'session_expired' && typeof showAuthSection === 'function') {
+                showAuthSection();
+            }
+            showError(apiError.message);
+        }
+    } catch (error) {
+        hideLoading();
+        showError('Network error: ' + error.message);
+    }
+}
+
+async function handleImportGame(event) {
+    event.preventDefault();
+    hideError();
+    
+    const file = document.getElementById('import-file').files[0];
+    if (!file) {
+        showError('Please choose a CSV, M3U or JSON file');
+        return;
+    }
+    
+    const formData = new FormData();
+    formData.append('file', file);
+    formData.append('player_count', document.getElementById('import-player-count').value);
+    formData.append('plates_per_player', document.getElementById('import-plates-per-player').value);
+    formData.append('content_type', document.getElementById('import-content-type').value);
+    
+    showLoading();
+    
+    try {
+        const response = await fetch('/api/games/import', {
+            method: 'POST',
+            body: formData
+        });
+        
+        hideLoading();
+        
+        if (response.ok) {
+            const gameData = await response.json();
+            window.location.href = `/game-view.html?code=${gameData.game_code}`;
+        } else {
+            const apiError = await readApiError(response, 'Failed to import track list');
+            showError(apiError.message);
         }
     } catch (error) {
         hideLoading();
@@ -86,8 +152,37 @@ async function handleJoinGame(event) {
             // Redirect to game view
             window.location.href = `/game-view.html?code=${gameData.game_code}`;
         } else {
-            const errorText = await response.text();
-            showError(errorText || 'Failed to join game');
+            const apiError = await readApiError(response, 'Failed to join game');
+            showError(apiError.message);
+        }
+    } catch (error) {
+        hideLoading();
+        showError('Network error: ' + error.message);
+    }
+}
+
+async function handleRedeemTransfer(event) {
+    event.preventDefault();
+    hideError();
+    showLoading();
+
+    const code = document.getElementById('transfer-code').value.trim();
+
+    try {
+        const response = await fetch('/api/device-transfer/redeem', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code: code })
+        });
+
+        hideLoading();
+
+        if (response.ok) {
+            const gameData = await response.json();
+            window.location.href = `/game-view.html?code=${gameData.game_code}`;
+        } else {
+            const apiError = await readApiError(response, 'Failed to move plates');
+            showError(apiError.message);
         }
     } catch (error) {
         hideLoading();
@@ -1,6 +1,7 @@
 let currentGameData = null;
 let allPlatesData = null;
 let isViewingAllPlates = false;
+let eventSource = null;
 
 async function loadGame(gameCode) {
     showLoading();
@@ -12,8 +13,8 @@ async function loadGame(gameCode) {
             currentGameData = await response.json();
             displayGame(currentGameData);
         } else {
-            const errorText = await response.text();
-            showError(errorText || 'Failed to load game');
+            const apiError = await readApiError(response, 'Failed to load game');
+            showError(apiError.message);
         }
     } catch (error) {
         showError('Network error: ' + error.message);
@@ -41,6 +42,18 @@ function displayGame(gameData) {
     
     // Set up event listeners
     setupEventListeners(gameData.game_code);
+
+    // Teammates' marks arrive as server-sent events
+    if (!eventSource) {
+        eventSource = new EventSource(`/api/games/${gameData.game_code}/events`);
+        eventSource.addEventListener('mark', function(e) {
+            const mark = JSON.parse(e.data);
+            const cell = document.querySelector(`#grid-${mark.plate_number} [data-row="${mark.row}"][data-col="${mark.col}"]`);
+            if (cell) {
+                cell.classList.toggle('marked', mark.marked);
+            }
+        });
+    }
 }
 
 function createPlateElement(plate, plateNumber, playerName = null) {
@@ -77,7 +90,10 @@ function createPlateElement(plate, plateNumber, playerName = null) {
                 
                 // Add click event for marking/unmarking
                 cellDiv.addEventListener('click', function() {
-                    this.classList.toggle('marked');
+                    const marked = this.classList.toggle('marked');
+                    if (!isViewingAllPlates) {
+                        saveMark(plate.plate_number, row, col, marked);
+                    }
                 });
             } else {
                 cellDiv.classList.add('empty');
@@ -90,6 +106,18 @@ function createPlateElement(plate, plateNumber, playerName = null) {
     return plateDiv;
 }
 
+async function saveMark(plateNumber, row, col, marked) {
+    try {
+        await fetch(`/api/games/${currentGameData.game_code}/plates/${plateNumber}/marks`, {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ row, col, marked })
+        });
+    } catch (error) {
+        console.error('Failed to save mark:', error);
+    }
+}
+
 function setupEventListeners(gameCode) {
     // Print plates button - prints whatever is currently displayed
     document.getElementById('print-plates').addEventListener('click', function() {
@@ -120,16 +148,30 @@ function setupEventListeners(gameCode) {
         showShareModal(gameCode);
     });
     
-    // Modal close button
-    document.querySelector('.close').addEventListener('click', function() {
-        document.getElementById('share-modal').style.display = 'none';
+    // Move to another device button
+    document.getElementById('move-device').addEventListener('click', function() {
+        document.getElementById('move-result').style.display = 'none';
+        document.getElementById('move-modal').style.display = 'block';
+    });
+
+    document.getElementById('move-start').addEventListener('click', function() {
+        startTransfer(gameCode);
+    });
+
+    // Modal close buttons
+    document.querySelectorAll('.modal .close').forEach(function(close) {
+        close.addEventListener('click', function() {
+            close.closest('.modal').style.display = 'none';
+        });
     });
     
-    // Close modal when clicking outside
-    document.getElementById('share-modal').addEventListener('click', function(e) {
-        if (e.target === this) {
-            this.style.display = 'none';
-        }
+    // Close modals when clicking outside
+    document.querySelectorAll('.modal').forEach(function(modal) {
+        modal.addEventListener('click', function(e) {
+            if (e.target === this) {
+                this.style.display = 'none';
+            }
+        });
     });
     
     // Copy buttons
@@ -140,6 +182,65 @@ function setupEventListeners(gameCode) {
     document.getElementById('copy-url').addEventListener('click', function() {
         copyToClipboard(document.getElementById('share-url').value, 'URL copied!');
     });
+
+    document.getElementById('copy-move-code').addEventListener('click', function() {
+        copyToClipboard(document.getElementById('move-code').value, 'Transfer code copied!');
+    });
+
+    document.getElementById('copy-move-url').addEventListener('click', function() {
+        copyToClipboard(document.getElementById('move-url').value, 'Link copied!');
+    });
+}
+
+async function startTransfer(gameCode) {
+    try {
+        const response = await fetch(`/api/games/${gameCode}/device-transfer`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                invalidate_old_session: document.getElementById('move-logout').checked
+            })
+        });
+
+        if (response.ok) {
+            const transfer = await response.json();
+            document.getElementById('move-code').value = transfer.code;
+            document.getElementById('move-url').value = transfer.url;
+            document.getElementById('move-result').style.display = 'block';
+        } else {
+            const apiError = await readApiError(response, 'Failed to create transfer link');
+            showError(apiError.message);
+        }
+    } catch (error) {
+        showError('Network error: ' + error.message);
+    }
+}
+
+// redeemTransfer moves plates from another device to this one, then shows
+// them. The token is dropped from the address bar since it only works once.
+async function redeemTransfer(gameCode, token) {
+    showLoading();
+
+    try {
+        const response = await fetch('/api/device-transfer/redeem', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token: token })
+        });
+        history.replaceState(null, '', `/game-view.html?code=${gameCode}`);
+
+        if (response.ok) {
+            hideLoading();
+            loadGame(gameCode);
+        } else {
+            const apiError = await readApiError(response, 'Failed to move plates');
+            showError(apiError.message);
+            hideLoading();
+        }
+    } catch (error) {
+        showError('Network error: ' + error.message);
+        hideLoading();
+    }
 }
 
 function showShareModal(gameCode) {
@@ -207,6 +308,24 @@ function showSuccess(message) {
 }
 
 // Utility functions
+// Reads the API error envelope from a failed response. Falls back to the
+// given message when the body isn't one.
+async function readApiError(response, fallback) {
+    try {
+        const body = await response.json();
+        if (body && body.error) {
+            return {
+                code: body.error.code,
+                message: body.error.message || fallback,
+                details: body.error.details || {}
+            };
+        }
+    } catch (e) {
+        // Not JSON
+    }
+    return { code: 'unknown', message: fallback, details: {} };
+}
+
 function showLoading() {
     document.getElementById('loading').style.display = 'block';
 }
@@ -256,7 +375,8 @@ async function loadAllPlates(gameCode) {
             `;
             isViewingAllPlates = true;
         } else {
-            showError('Failed to load all plates');
+            const apiError = await readApiError(response, 'Failed to load all plates');
+            showError(apiError.message);
         }
     } catch (error) {
         showError('Network error: ' + error.message);